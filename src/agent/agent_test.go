@@ -4,31 +4,40 @@ import (
 	"bytes"
 	"encoding/json"
 	"io/ioutil"
+	"math"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/m4tveevm/GoCalc/calc"
 )
 
 type FakeOrchestrator struct {
-	mu        sync.Mutex
-	taskSent  bool
-	postedID  int
-	postedRes float64
+	mu            sync.Mutex
+	task          map[string]interface{}
+	taskSent      bool
+	postedID      int
+	postedRes     float64
+	postedValue   *calc.Value
+	postedError   string
+	postedSamples []calc.Sample
 }
 
 func (f *FakeOrchestrator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet && r.URL.Path == "/internal/task" {
 		f.mu.Lock()
 		if !f.taskSent {
-			resp := map[string]interface{}{
-				"task": map[string]interface{}{
+			task := f.task
+			if task == nil {
+				task = map[string]interface{}{
 					"id":         42,
 					"expression": "2+2",
-				},
+				}
 			}
+			resp := map[string]interface{}{"task": task}
 			f.taskSent = true
 			f.mu.Unlock()
 			w.Header().Set("Content-Type", "application/json")
@@ -38,12 +47,17 @@ func (f *FakeOrchestrator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		f.mu.Unlock()
 		w.WriteHeader(http.StatusNotFound)
 		json.NewEncoder(w).Encode(map[string]string{"error": "No task available"})
+	} else if r.Method == http.MethodGet && r.URL.Path == "/healthz" {
+		w.WriteHeader(http.StatusOK)
 	} else if r.Method == http.MethodPost && r.URL.Path == "/internal/task" {
 		var rp ResultPayload
 		json.NewDecoder(r.Body).Decode(&rp)
 		f.mu.Lock()
 		f.postedID = rp.ID
 		f.postedRes = rp.Result
+		f.postedValue = rp.Value
+		f.postedError = rp.Error
+		f.postedSamples = rp.Samples
 		f.mu.Unlock()
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]string{"status": "result accepted"})
@@ -136,3 +150,121 @@ func TestFakeOrchestratorEndpoints(t *testing.T) {
 		t.Fatalf("unexpected response: %s", string(bodyBytes))
 	}
 }
+
+func TestWorkerMetrics(t *testing.T) {
+	fake := &FakeOrchestrator{}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	go worker(7, srv.URL, 100*time.Millisecond)
+	time.Sleep(3 * time.Second)
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := w.Body.String()
+	for _, line := range []string{
+		`gocalc_agent_tasks_processed_total{worker="7",outcome="success"} 1`,
+		`gocalc_agent_evaluation_duration_seconds_count{worker="7"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("expected %q in metrics output:\n%s", line, out)
+		}
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	srv := httptest.NewServer(&FakeOrchestrator{})
+	w := httptest.NewRecorder()
+	readyzHandler(srv.URL)(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, w.Result().StatusCode)
+	}
+	srv.Close()
+	w = httptest.NewRecorder()
+	readyzHandler(srv.URL)(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Result().StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected %d with orchestrator down, got %d", http.StatusServiceUnavailable, w.Result().StatusCode)
+	}
+}
+
+func TestWorkerDecimalMode(t *testing.T) {
+	fake := &FakeOrchestrator{task: map[string]interface{}{
+		"id":         43,
+		"expression": "0.1 + 0.2",
+		"mode":       "decimal",
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	go worker(8, srv.URL, 100*time.Millisecond)
+	time.Sleep(3 * time.Second)
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.postedID != 43 {
+		t.Fatalf("expected posted id 43, got %d", fake.postedID)
+	}
+	if fake.postedValue == nil || fake.postedValue.Decimal != "0.3" {
+		t.Fatalf("expected exact decimal 0.3, got %+v", fake.postedValue)
+	}
+}
+
+func TestWorkerReportsErrors(t *testing.T) {
+	fake := &FakeOrchestrator{task: map[string]interface{}{"id": 7, "expression": "1/0"}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	go worker(1, srv.URL, 100*time.Millisecond)
+	time.Sleep(500 * time.Millisecond)
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.postedID != 7 || fake.postedError != "division by zero" {
+		t.Fatalf("expected failure of task 7 to be reported, got id %d, error %q", fake.postedID, fake.postedError)
+	}
+}
+
+func TestEncodeResult(t *testing.T) {
+	var rp ResultPayload
+	if err := json.Unmarshal(encodeResult(ResultPayload{ID: 5, Result: math.Inf(1)}), &rp); err != nil {
+		t.Fatal(err)
+	}
+	if rp.ID != 5 || !strings.HasPrefix(rp.Error, "cannot encode result") {
+		t.Fatalf("expected an unencodable result to be reported as an error, got %+v", rp)
+	}
+}
+
+func TestWorkerReportsDecimalBeyondFloat64(t *testing.T) {
+	fake := &FakeOrchestrator{task: map[string]interface{}{"id": 8, "expression": "10^400", "mode": "decimal"}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	go worker(1, srv.URL, 100*time.Millisecond)
+	time.Sleep(3 * time.Second)
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.postedID != 8 || fake.postedError != "" || fake.postedRes != 0 || fake.postedValue == nil || fake.postedValue.Decimal != "1"+strings.Repeat("0", 400) {
+		t.Fatalf("expected the exact result of task 8 without an approximation, got id %d, result %v, value %+v, error %q", fake.postedID, fake.postedRes, fake.postedValue, fake.postedError)
+	}
+}
+
+func TestWorkerSweepChunk(t *testing.T) {
+	fake := &FakeOrchestrator{task: map[string]interface{}{
+		"id":         9,
+		"expression": "x*y",
+		"mode":       "decimal",
+		"grid": []map[string]interface{}{
+			{"name": "x", "from": 0, "to": 1, "step": 0.1},
+			{"name": "y", "values": []interface{}{1, 3}},
+		},
+		"offset": 6,
+		"count":  3,
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	go worker(1, srv.URL, 100*time.Millisecond)
+	time.Sleep(3500 * time.Millisecond)
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.postedID != 9 || len(fake.postedSamples) != 3 {
+		t.Fatalf("expected 3 samples for task 9, got id %d, %+v", fake.postedID, fake.postedSamples)
+	}
+	for i, want := range []string{"0.3", "0.9", "0.4"} {
+		if v := fake.postedSamples[i].Value; v == nil || v.Decimal != want {
+			t.Fatalf("sample %d: expected %s, got %+v", i, want, fake.postedSamples[i])
+		}
+	}
+}
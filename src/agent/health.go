@@ -0,0 +1,34 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+func handleHealthz(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(map[string]string{"status": "ok"})
+}
+
+// readyzHandler reports the agent ready once the orchestrator answers its
+// liveness probe; without it the workers cannot fetch any tasks.
+func readyzHandler(orchestratorURL string) http.HandlerFunc {
+	client := &http.Client{Timeout: 2 * time.Second}
+	return func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		resp, err := client.Get(orchestratorURL + "/healthz")
+		if err != nil {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(writer).Encode(map[string]string{"status": "orchestrator unreachable", "error": err.Error()})
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(writer).Encode(map[string]string{"status": "orchestrator unhealthy"})
+			return
+		}
+		json.NewEncoder(writer).Encode(map[string]string{"status": "ready"})
+	}
+}
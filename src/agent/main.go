@@ -2,20 +2,34 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
 	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/m4tveevm/GoCalc/calc"
+	"github.com/m4tveevm/GoCalc/logging"
+	"github.com/m4tveevm/GoCalc/tracing"
 )
 
 type Task struct {
 	ID         int    `json:"id"`
 	Expression string `json:"expression"`
+	RequestID  string `json:"request_id,omitempty"`
+	// Grid, Offset and Count are set for a chunk of a sweep: the task is
+	// to evaluate Expression at Count points of Grid from Offset on.
+	Grid   calc.Grid `json:"grid,omitempty"`
+	Offset int       `json:"offset,omitempty"`
+	Count  int       `json:"count,omitempty"`
+	calc.Options
 }
 
 type TaskResponse struct {
@@ -23,16 +37,64 @@ type TaskResponse struct {
 }
 
 type ResultPayload struct {
-	ID     int     `json:"id"`
-	Result float64 `json:"result"`
+	ID     int         `json:"id"`
+	Result float64     `json:"result"`
+	Value  *calc.Value `json:"value,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	// Samples are the outcomes at the points of a chunk of a sweep.
+	Samples []calc.Sample `json:"samples,omitempty"`
+}
+
+// evaluate computes the result of task.
+func evaluate(task Task) (ResultPayload, error) {
+	if len(task.Grid) > 0 {
+		compiled, err := calc.Compile(task.Expression, task.Options)
+		if err != nil {
+			return ResultPayload{}, err
+		}
+		samples, err := compiled.Sweep(task.Grid, task.Offset, task.Count)
+		return ResultPayload{ID: task.ID, Samples: samples}, err
+	}
+	result, value, err := calc.Evaluate(task.Expression, task.Options)
+	return ResultPayload{ID: task.ID, Result: result, Value: value}, err
+}
+
+var (
+	// agentID identifies this agent in the orchestrator's logs.
+	agentID string
+
+	tracer = tracing.NewTracer("agent", nil)
+)
+
+// newRequest builds a request to the orchestrator that carries the agent
+// and worker identifiers, so both sides log the same correlation fields.
+func newRequest(method, url string, body io.Reader, workerLabel string) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Agent-ID", agentID)
+	req.Header.Set("X-Worker-ID", workerLabel)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
 }
 
 func worker(workerID int, orchestratorURL string, pollInterval time.Duration) {
 	client := &http.Client{Timeout: 5 * time.Second}
+	workerLabel := strconv.Itoa(workerID)
+	logger := slog.With("agent_id", agentID, "worker_id", workerID)
 	for {
-		resp, err := client.Get(orchestratorURL + "/internal/task")
+		req, err := newRequest(http.MethodGet, orchestratorURL+"/internal/task", nil, workerLabel)
+		if err != nil {
+			logger.Error("error building request", "error", err)
+			return
+		}
+		resp, err := client.Do(req)
 		if err != nil {
-			log.Printf("[Worker %d] Error fetching task: %v", workerID, err)
+			logger.Warn("error fetching task", "error", err)
+			httpErrors.Inc(workerLabel, "fetch")
 			time.Sleep(pollInterval)
 			continue
 		}
@@ -43,39 +105,106 @@ func worker(workerID int, orchestratorURL string, pollInterval time.Duration) {
 		}
 		var taskResp TaskResponse
 		if err := json.NewDecoder(resp.Body).Decode(&taskResp); err != nil {
-			log.Printf("[Worker %d] Error decoding task: %v", workerID, err)
+			logger.Warn("error decoding task", "error", err)
+			httpErrors.Inc(workerLabel, "decode")
 			resp.Body.Close()
 			time.Sleep(pollInterval)
 			continue
 		}
 		resp.Body.Close()
-		log.Printf("[Worker %d] Received task %d: %s", workerID, taskResp.Task.ID, taskResp.Task.Expression)
+		taskLogger := logger.With("task_id", taskResp.Task.ID, "request_id", taskResp.Task.RequestID)
+		taskLogger.Info("task received", "expression", taskResp.Task.Expression)
 
-		calculator := calc.NewBasicCalculator()
-		result, err := calculator.Calculate(taskResp.Task.Expression)
+		taskCtx := tracing.Extract(context.Background(), resp.Header)
+		_, evalSpan := tracer.Start(taskCtx, "evaluate")
+		evalSpan.SetAttributes("task_id", taskResp.Task.ID, "agent_id", agentID, "worker_id", workerID)
+		start := time.Now()
+		resPayload, err := evaluate(taskResp.Task)
+		evaluationDuration.Observe(time.Since(start).Seconds(), workerLabel)
+		evalSpan.RecordError(err)
+		evalSpan.End()
 		if err != nil {
-			log.Printf("[Worker %d] Error computing expression: %v", workerID, err)
+			taskLogger.Warn("error computing expression", "error", err)
+			tasksProcessed.Inc(workerLabel, "error")
+			// Report the failure, so tasks depending on this one fail
+			// too instead of waiting forever.
+			data := encodeResult(ResultPayload{ID: taskResp.Task.ID, Error: err.Error()})
+			if err := submitResult(taskCtx, client, orchestratorURL, data, workerLabel); err != nil {
+				taskLogger.Warn("error sending failure", "error", err)
+				httpErrors.Inc(workerLabel, "submit")
+			}
 			continue
 		}
+		tasksProcessed.Inc(workerLabel, "success")
 		delay := time.Duration(1000+rand.Intn(2000)) * time.Millisecond
 		time.Sleep(delay)
 
-		resPayload := ResultPayload{
-			ID:     taskResp.Task.ID,
-			Result: result,
-		}
-		data, _ := json.Marshal(resPayload)
-		res, err := client.Post(orchestratorURL+"/internal/task", "application/json", bytes.NewReader(data))
-		if err != nil {
-			log.Printf("[Worker %d] Error sending result: %v", workerID, err)
+		data := encodeResult(resPayload)
+		if err := submitResult(taskCtx, client, orchestratorURL, data, workerLabel); err != nil {
+			taskLogger.Warn("error sending result", "error", err)
+			httpErrors.Inc(workerLabel, "submit")
 			continue
 		}
-		res.Body.Close()
-		log.Printf("[Worker %d] Sent result for task %d: %v", workerID, taskResp.Task.ID, result)
+		taskLogger.Info("result sent", "result", resPayload.Result)
 	}
 }
 
+// encodeResult encodes res for the orchestrator. A result that cannot be
+// encoded, such as an infinite float64, is reported as the task's error
+// instead, so the task fails rather than staying in progress.
+func encodeResult(res ResultPayload) []byte {
+	data, err := json.Marshal(res)
+	if err != nil {
+		data, _ = json.Marshal(ResultPayload{ID: res.ID, Error: fmt.Sprintf("cannot encode result: %v", err)})
+	}
+	return data
+}
+
+// submitResult posts a result to the orchestrator inside a client span
+// whose context is propagated in the traceparent header.
+func submitResult(ctx context.Context, client *http.Client, orchestratorURL string, data []byte, workerLabel string) error {
+	ctx, span := tracer.Start(ctx, "submit result", tracing.WithKind(tracing.KindClient))
+	defer span.End()
+	req, err := newRequest(http.MethodPost, orchestratorURL+"/internal/task", bytes.NewReader(data), workerLabel)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	tracing.Inject(ctx, req.Header)
+	res, err := client.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("result rejected with status %d", res.StatusCode)
+		span.RecordError(err)
+		return err
+	}
+	return nil
+}
+
 func main() {
+	logger, err := logging.FromEnv()
+	if err != nil {
+		slog.Error("invalid logging configuration", "error", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	var closeTraces io.Closer
+	tracer, closeTraces, err = tracing.FromEnv("agent")
+	if err != nil {
+		slog.Error("invalid tracing configuration", "error", err)
+		os.Exit(1)
+	}
+	defer closeTraces.Close()
+
+	agentID = os.Getenv("AGENT_ID")
+	if agentID == "" {
+		agentID, _ = os.Hostname()
+	}
 	workers := 1
 	if val := os.Getenv("COMPUTING_POWER"); val != "" {
 		if n, err := strconv.Atoi(val); err == nil && n > 0 {
@@ -87,11 +216,33 @@ func main() {
 		orchestratorURL = "http://orchestrator:8080"
 	}
 	pollInterval := 2 * time.Second
+	httpAddr := os.Getenv("AGENT_HTTP_ADDR")
+	if httpAddr == "" {
+		httpAddr = ":8081"
+	}
+
+	// Stop on SIGTERM or an interrupt, so the deferred Close flushes the
+	// trace file.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", registry)
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", readyzHandler(orchestratorURL))
+	go func() {
+		slog.Info("agent HTTP listener running", "addr", httpAddr)
+		if err := http.ListenAndServe(httpAddr, mux); err != nil {
+			slog.Error("HTTP listener failed", "error", err)
+			os.Exit(1)
+		}
+	}()
 
-	log.Printf("Agent started with %d workers", workers)
+	slog.Info("agent started", "agent_id", agentID, "workers", workers)
 	rand.Seed(time.Now().UnixNano())
 	for i := 1; i <= workers; i++ {
 		go worker(i, orchestratorURL, pollInterval)
 	}
-	select {}
+	<-ctx.Done()
+	slog.Info("shutting down agent")
 }
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"github.com/m4tveevm/GoCalc/metrics"
+)
+
+var (
+	registry = metrics.NewRegistry()
+
+	tasksProcessed = registry.NewCounter("gocalc_agent_tasks_processed_total",
+		"Tasks evaluated by a worker, by outcome.", "worker", "outcome")
+	evaluationDuration = registry.NewHistogram("gocalc_agent_evaluation_duration_seconds",
+		"Time spent evaluating an expression.", nil, "worker")
+	httpErrors = registry.NewCounter("gocalc_agent_http_errors_total",
+		"Failed requests to the orchestrator, by operation.", "worker", "operation")
+)
@@ -0,0 +1,337 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// binders are the built-in functions whose second argument is a variable
+// bound in their first: integrate(expr, x, a, b), sum(expr, k, a, b) and
+// prod(expr, k, a, b).
+var binders = map[string]bool{"integrate": true, "sum": true, "prod": true}
+
+const (
+	// maxSeriesTerms bounds the terms a single sum or prod evaluates.
+	maxSeriesTerms = 1000000
+	// maxQuadratureDepth bounds how often integrate halves an interval,
+	// and minQuadratureDepth is how often it halves one regardless of the
+	// error estimate, so periodic integrands are not mistaken for
+	// constants.
+	maxQuadratureDepth = 40
+	minQuadratureDepth = 4
+	// maxQuadratureEvaluations bounds the integrand evaluations of a
+	// single integral.
+	maxQuadratureEvaluations = 1000000
+)
+
+// evaluateBinder evaluates a call of one of the binders, evaluating the
+// body with the variable bound in a copy of e.
+func evaluateBinder[T any](n *callNode, e *env[T]) (T, error) {
+	var zero T
+	if len(n.args) != 4 {
+		return zero, fmt.Errorf("%s expects 4 argument(s), got %d", n.name, len(n.args))
+	}
+	variable, ok := n.args[1].(*identNode)
+	if !ok || isReference(variable.name) {
+		return zero, fmt.Errorf("%s expects a variable as its second argument", n.name)
+	}
+	lower, err := evaluate(n.args[2], e)
+	if err != nil {
+		return zero, err
+	}
+	upper, err := evaluate(n.args[3], e)
+	if err != nil {
+		return zero, err
+	}
+	local := &env[T]{d: e.d, vars: make(map[string]T, len(e.vars)+1), global: e.global, depth: e.depth}
+	for name, v := range e.vars {
+		local.vars[name] = v
+	}
+	body := n.args[0]
+	f := func(x T) (T, error) {
+		local.vars[variable.name] = x
+		return evaluate(body, local)
+	}
+	if n.name == "integrate" {
+		return integrateIn(f, lower, upper)
+	}
+	return series(n.name, f, lower, upper, e.d)
+}
+
+// series adds up (sum) or multiplies (prod) f(k) for k from lower to
+// upper in steps of 1.
+func series[T any](name string, f func(T) (T, error), lower, upper T, d domain[T]) (T, error) {
+	op, identity := "+", "0"
+	if name == "prod" {
+		op, identity = "*", "1"
+	}
+	result, err := d.number(identity)
+	if err != nil {
+		return result, err
+	}
+	one, err := d.number("1")
+	if err != nil {
+		return result, err
+	}
+	for k, terms := lower, 0; ; terms++ {
+		c, err := d.compare(k, upper)
+		if err != nil {
+			return result, err
+		}
+		if c > 0 {
+			return result, nil
+		}
+		if terms == maxSeriesTerms {
+			return result, fmt.Errorf("%s has more than %d terms", name, maxSeriesTerms)
+		}
+		term, err := f(k)
+		if err != nil {
+			return result, err
+		}
+		if result, err = d.binary(op, result, term); err != nil {
+			return result, err
+		}
+		if k, err = d.binary("+", k, one); err != nil {
+			return result, err
+		}
+	}
+}
+
+// integrateIn integrates f from lower to upper, which only float mode
+// can do.
+func integrateIn[T any](f func(T) (T, error), lower, upper T) (T, error) {
+	var zero T
+	a, ok := any(lower).(float64)
+	if !ok {
+		return zero, errors.New("integrate requires float mode")
+	}
+	b := any(upper).(float64)
+	value, estimate, err := integrate(func(x float64) (float64, error) {
+		y, err := f(any(x).(T))
+		return any(y).(float64), err
+	}, a, b, defaultTolerance)
+	if errors.Is(err, errNotConverged) {
+		return zero, fmt.Errorf("integral did not converge: error estimate %g", estimate)
+	}
+	if err != nil {
+		return zero, err
+	}
+	return any(value).(T), nil
+}
+
+// errNotConverged reports an integral whose error estimate is above the
+// tolerance after the quadrature has halved some interval as often as it
+// may. Jumps in the integrand reach that depth too, but with a small
+// estimate, since the intervals around them are tiny by then.
+var errNotConverged = errors.New("integral did not converge")
+
+// Integrate returns the integral of expression over variable from a to
+// b and an estimate of its absolute error, which adaptive Simpson
+// quadrature tries to keep below opts.Tolerance relative to the
+// magnitude of the integral. If it cannot, it returns the integral and
+// its estimate with an error. Functions and inputs in opts are in scope.
+func Integrate(expression, variable string, a, b float64, opts Options) (float64, float64, error) {
+	if !IsLabel(variable) {
+		return 0, 0, fmt.Errorf("invalid variable: %q", variable)
+	}
+	if err := opts.Validate(); err != nil {
+		return 0, 0, err
+	}
+	n, err := parseExpression(expression, syntax{lenient: opts.Lenient})
+	if err != nil {
+		return 0, 0, err
+	}
+	scope, err := opts.scope()
+	if err != nil {
+		return 0, 0, err
+	}
+	e := newEnv[float64](floatDomain{})
+	if err := loadScope(scope, e); err != nil {
+		return 0, 0, err
+	}
+	tolerance := opts.Tolerance
+	if tolerance <= 0 {
+		tolerance = defaultTolerance
+	}
+	return integrate(func(x float64) (float64, error) {
+		e.vars[variable] = x
+		return evaluate(n, e)
+	}, a, b, tolerance)
+}
+
+// quadrature integrates f by adaptive Simpson quadrature, counting the
+// evaluations of f.
+type quadrature struct {
+	f           func(float64) (float64, error)
+	evaluations int
+	// exhausted is set when an interval reaches maxQuadratureDepth
+	// without meeting its tolerance.
+	exhausted bool
+}
+
+func integrate(f func(float64) (float64, error), a, b, tolerance float64) (float64, float64, error) {
+	if a == b {
+		return 0, 0, nil
+	}
+	q := &quadrature{f: f}
+	m := a + (b-a)/2
+	fa, err := q.eval(a)
+	if err != nil {
+		return 0, 0, err
+	}
+	fm, err := q.eval(m)
+	if err != nil {
+		return 0, 0, err
+	}
+	fb, err := q.eval(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	whole := (b - a) / 6 * (fa + 4*fm + fb)
+	value, estimate, err := q.simpson(a, b, fa, fm, fb, whole, tolerance*math.Max(1, math.Abs(whole)), 0)
+	if err == nil && q.exhausted && estimate > tolerance*math.Max(1, math.Abs(value)) {
+		err = errNotConverged
+	}
+	return value, estimate, err
+}
+
+func (q *quadrature) eval(x float64) (float64, error) {
+	q.evaluations++
+	if q.evaluations > maxQuadratureEvaluations {
+		return 0, fmt.Errorf("integral did not converge within %d evaluations", maxQuadratureEvaluations)
+	}
+	y, err := q.f(x)
+	if err == nil && (math.IsNaN(y) || math.IsInf(y, 0)) {
+		err = fmt.Errorf("integrand is not finite at %s", FormatFloat(x))
+	}
+	return y, err
+}
+
+// simpson refines whole, the Simpson estimate of the integral over
+// [a, b], by comparing it with the estimates over both halves. The
+// difference between them is about 15 times the error of their sum, which
+// is the error estimate returned along with the extrapolated integral.
+func (q *quadrature) simpson(a, b, fa, fm, fb, whole, tolerance float64, depth int) (float64, float64, error) {
+	m := a + (b-a)/2
+	flm, err := q.eval(a + (m-a)/2)
+	if err != nil {
+		return 0, 0, err
+	}
+	frm, err := q.eval(m + (b-m)/2)
+	if err != nil {
+		return 0, 0, err
+	}
+	left := (m - a) / 6 * (fa + 4*flm + fm)
+	right := (b - m) / 6 * (fm + 4*frm + fb)
+	delta := left + right - whole
+	converged := math.Abs(delta) <= 15*tolerance
+	if depth >= maxQuadratureDepth || depth >= minQuadratureDepth && converged {
+		q.exhausted = q.exhausted || !converged
+		return left + right + delta/15, math.Abs(delta) / 15, nil
+	}
+	leftValue, leftError, err := q.simpson(a, m, fa, flm, fm, left, tolerance/2, depth+1)
+	if err != nil {
+		return 0, 0, err
+	}
+	rightValue, rightError, err := q.simpson(m, b, fm, frm, fb, right, tolerance/2, depth+1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return leftValue + rightValue, leftError + rightError, nil
+}
+
+// minPartitionTerms is the smallest number of terms Partition gives a
+// part of a sum or prod, and minPartitionWidth the smallest width of the
+// range it gives a part of an integral, since integrals over short ranges
+// converge faster than a task is dispatched.
+const (
+	minPartitionTerms = 10000
+	minPartitionWidth = 100
+)
+
+// Partition splits expression, if it is a single call of integrate, sum
+// or prod with numeric bounds, into up to n calls over consecutive parts
+// of its range, so they can be evaluated separately. It returns the parts
+// and the operator, "+" or "*", that combines their results into the
+// result of expression, or no parts if expression cannot be split.
+// A sum or prod is only split into parts of at least 10000 terms, an
+// integral into parts of a range at least 100 wide, and
+// nothing is split in integer mode, where the parts would be printed with
+// a different meaning of ^, for operations other than evaluation, or if
+// one of the functions in opts replaces the binder. In matrix mode the product
+// of two matrix literals of at least 2000000 element multiplications is
+// split too, into blocks of rows of the left matrix, and the function
+// vstack rather than an operator combines the results. The parts are
+// written in opts.Locale, like expression.
+func Partition(expression string, n int, opts Options) ([]string, string) {
+	if n < 2 || opts.Mode == ModeInteger || opts.Operation != "" && opts.Operation != OperationEvaluate {
+		return nil, ""
+	}
+	node, err := parse(expression, syntax{arrays: opts.Mode == ModeMatrix, percent: opts.Dialect == DialectDesk, lenient: opts.Lenient, locale: opts.Locale})
+	if err != nil {
+		return nil, ""
+	}
+	if opts.Mode == ModeMatrix {
+		if parts := partitionProduct(node, n); parts != nil {
+			for i, part := range parts {
+				parts[i] = opts.Locale.written(part)
+			}
+			return parts, "vstack"
+		}
+	}
+	c, ok := node.(*callNode)
+	if !ok || !binders[c.name] || len(c.args) != 4 {
+		return nil, ""
+	}
+	if opts.defines(c.name) {
+		return nil, ""
+	}
+	bounds := newEnv[float64](floatDomain{})
+	lower, err := evaluate(c.args[2], bounds)
+	if err != nil {
+		return nil, ""
+	}
+	upper, err := evaluate(c.args[3], bounds)
+	if err != nil || !(lower < upper) || math.IsInf(upper-lower, 0) {
+		return nil, ""
+	}
+	op := "+"
+	if c.name == "prod" {
+		op = "*"
+	}
+	part := func(lower, upper float64) string {
+		return opts.Locale.written(format(call(c.name, c.args[0], c.args[1], number(FormatFloat(lower)), number(FormatFloat(upper)))))
+	}
+	var parts []string
+	if c.name == "integrate" {
+		n = int(math.Min(float64(n), (upper-lower)/minPartitionWidth))
+		if n < 2 {
+			return nil, ""
+		}
+		for i := 0; i < n; i++ {
+			a := lower + (upper-lower)*float64(i)/float64(n)
+			b := lower + (upper-lower)*float64(i+1)/float64(n)
+			if i == n-1 {
+				b = upper
+			}
+			parts = append(parts, part(a, b))
+		}
+		return parts, op
+	}
+	// Only integer bounds that float64 represents exactly can be split
+	// without changing which terms the series has.
+	if lower != math.Trunc(lower) || math.Abs(lower) > 1<<53 || math.Abs(upper) > 1<<53 {
+		return nil, ""
+	}
+	terms := math.Floor(upper-lower) + 1
+	n = int(math.Min(float64(n), terms/minPartitionTerms))
+	if n < 2 {
+		return nil, ""
+	}
+	size := math.Ceil(terms / float64(n))
+	for a := lower; a <= upper; a += size {
+		parts = append(parts, part(a, math.Min(a+size-1, upper)))
+	}
+	return parts, op
+}
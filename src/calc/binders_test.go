@@ -0,0 +1,120 @@
+package calc
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestBinders(t *testing.T) {
+	testCases := []struct {
+		expression string
+		mode       Mode
+		expected   float64
+		expectErr  bool
+	}{
+		{"integrate(x^2, x, 0, 3)", "", 9, false},
+		{"integrate(x^2, x, 3, 0)", "", -9, false},
+		{"integrate(sin(x)^2, x, 0, 6.283185307179586)", "", math.Pi, false},
+		{"integrate(exp(-x^2), x, -10, 10)", "", math.Sqrt(math.Pi), false},
+		{"integrate(abs(x), x, -1, 2)", "", 2.5, false},
+		{"integrate(x > 0.3 ? 1 : 0, x, 0, 1)", "", 0.7, false},
+		{"integrate(t*x, t, 0, 1)", "", 1, false},
+		{"integrate(1/x, x, -1, 1)", "", 0, true},
+		{"integrate(1/sqrt(x), x, 1e-300, 1)", "", 0, true},
+		{"integrate(x, x, 0, 1)", ModeDecimal, 0, true},
+		{"sum(k, k, 1, 100)", "", 5050, false},
+		{"sum(k, k, 3, 1)", "", 0, false},
+		{"prod(k, k, 1, 20)", ModeInteger, 2432902008176640000, false},
+		{"prod(k, k, 1, 30)", ModeInteger, 0, true},
+		{"sum(sum(j*k, j, 1, k), k, 1, 3)", "", 25, false},
+		{"k = 5; sum(k, k, 1, 3) + k", "", 11, false},
+		{"sum(f(k), k, 1, 3)", "", 14, false},
+		{"sum(k, k, 1, 1e7)", "", 0, true},
+		{"sum(k, 2, 1, 3)", "", 0, true},
+		{"sum(k, $1, 1, 3)", "", 0, true},
+		{"sum(k, k, 1)", "", 0, true},
+		{"sum(k, k, 1, 3i)", ModeComplex, 0, true},
+	}
+
+	for _, tc := range testCases {
+		opts := Options{Mode: tc.mode, Functions: []string{"f(k) = k^2"}, Inputs: map[string]string{"x": "2"}}
+		result, _, err := Evaluate(tc.expression, opts)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("expected error for %q, got %v", tc.expression, result)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("did not expect error for %q, got %v", tc.expression, err)
+			continue
+		}
+		if math.Abs(result-tc.expected) > 1e-9*math.Max(1, math.Abs(tc.expected)) {
+			t.Errorf("expected %v for %q, got %v", tc.expected, tc.expression, result)
+		}
+	}
+}
+
+func TestExactSeries(t *testing.T) {
+	_, value, err := Evaluate("sum(1/k, k, 1, 10)", Options{Mode: ModeRational})
+	if err != nil || value.Numerator != "7381" || value.Denominator != "2520" {
+		t.Fatalf("expected 7381/2520, got %+v, %v", value, err)
+	}
+}
+
+func TestUserFunctionShadowsBinder(t *testing.T) {
+	result, _, err := Evaluate("sum(1, 2)", Options{Functions: []string{"sum(a, b) = a + b"}})
+	if err != nil || result != 3 {
+		t.Fatalf("expected the user's sum, got %v, %v", result, err)
+	}
+}
+
+func TestIntegrate(t *testing.T) {
+	value, estimate, err := Integrate("1/sqrt(x)", "x", 1e-6, 1, Options{Tolerance: 1e-8})
+	if err != nil || math.Abs(value-1.998) > 1e-7 || estimate <= 0 || estimate > 1e-6 {
+		t.Fatalf("unexpected integral %v with error estimate %v, %v", value, estimate, err)
+	}
+	if _, _, err := Integrate("x", "1x", 0, 1, Options{}); err == nil {
+		t.Error("expected error for invalid variable")
+	}
+}
+
+func TestPartition(t *testing.T) {
+	testCases := []struct {
+		expression string
+		n          int
+		opts       Options
+		expected   []string
+		op         string
+	}{
+		{"sum(k^2, k, 1, 40000)", 4, Options{}, []string{
+			"sum(k^2, k, 1, 10000)", "sum(k^2, k, 10001, 20000)",
+			"sum(k^2, k, 20001, 30000)", "sum(k^2, k, 30001, 40000)",
+		}, "+"},
+		{"prod(1 + 1/k, k, 1, 20000.5)", 8, Options{}, []string{
+			"prod(1 + 1/k, k, 1, 10000)", "prod(1 + 1/k, k, 10001, 20000)",
+		}, "*"},
+		{"integrate(x^2, x, 0, 200)", 2, Options{}, []string{"integrate(x^2, x, 0, 100)", "integrate(x^2, x, 100, 200)"}, "+"},
+		{"integrate(x^2, x, 0, 300)", 4, Options{}, []string{"integrate(x^2, x, 0, 100)", "integrate(x^2, x, 100, 200)", "integrate(x^2, x, 200, 300)"}, "+"},
+		{"integrate(x^2; x; 0; 250,5)", 2, Options{Locale: LocaleGerman}, []string{"integrate(x^2; x; 0; 125,25)", "integrate(x^2; x; 125,25; 250,5)"}, "+"},
+		{"integrate(x^2, x, 0, 1500)", 2, Options{Locale: LocaleEnglish}, []string{"integrate(x^2, x, 0, 750)", "integrate(x^2, x, 750, 1500)"}, "+"},
+		{"sum(k, k, 1, 15000)", 4, Options{}, nil, ""},
+		{"sum(k, k, 0.5, 100000)", 4, Options{}, nil, ""},
+		{"sum(k, k, 1, n)", 4, Options{}, nil, ""},
+		{"sum(k, k, 1, 100000) + 1", 4, Options{}, nil, ""},
+		{"integrate(x, x, 0, 1)", 1, Options{}, nil, ""},
+		{"integrate(x, x, 0, 1)", 4, Options{}, nil, ""},
+		{"integrate(x, x, -90, 90)", 4, Options{}, nil, ""},
+		{"integrate(x, x, 0, 1)", 4, Options{Mode: ModeInteger}, nil, ""},
+		{"integrate(x, x, 0, 1)", 4, Options{Operation: OperationSimplify}, nil, ""},
+		{"integrate(x, x, 0, 1)", 4, Options{Functions: []string{"integrate(a, b, c, d) = a"}}, nil, ""},
+	}
+
+	for _, tc := range testCases {
+		parts, op := Partition(tc.expression, tc.n, tc.opts)
+		if !reflect.DeepEqual(parts, tc.expected) || op != tc.op {
+			t.Errorf("expected %q, %q partitioning %q, got %q, %q", tc.expected, tc.op, tc.expression, parts, op)
+		}
+	}
+}
@@ -1,181 +1,150 @@
 package calc
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
-	"unicode"
 )
 
 type Calculator interface {
 	Calculate(expression string) (float64, error)
 }
 
-type BasicCalculator struct{}
+// ExactCalculator is a Calculator whose results have an exact
+// representation that float64 cannot hold.
+type ExactCalculator interface {
+	Calculator
+	Exact(expression string) (*Value, error)
+}
+
+type BasicCalculator struct {
+	// Scope, if not nil, holds user-defined functions expressions can call.
+	Scope *Scope
+}
 
 func NewBasicCalculator() *BasicCalculator {
 	return &BasicCalculator{}
 }
 
 func (c *BasicCalculator) Calculate(expression string) (float64, error) {
-	if err := validateParentheses(expression); err != nil {
-		return 0, err
-	}
-	tokens, err := getTokenString(expression)
+	result, err := evaluateString[float64](expression, floatDomain{}, c.Scope)
 	if err != nil {
 		return 0, err
 	}
-	postfix, err := infixToPostfix(tokens)
-	if err != nil {
-		return 0, err
-	}
-	return evaluatePostfix(postfix)
-}
-
-func validateParentheses(expression string) error {
-	var stack []rune
-	for _, ch := range expression {
-		if ch == '(' {
-			stack = append(stack, ch)
-		} else if ch == ')' {
-			if len(stack) == 0 {
-				return errors.New("string is not valid")
-			}
-			stack = stack[:len(stack)-1]
-		}
+	if math.IsInf(result, 0) || math.IsNaN(result) {
+		return 0, errors.New("result is not a finite number")
 	}
-	if len(stack) != 0 {
-		return errors.New("string is not valid")
+	return result, nil
+}
+
+func isImaginary(literal string) bool {
+	return strings.HasSuffix(literal, "i")
+}
+
+func parseFloat(literal string) (float64, error) {
+	if isImaginary(literal) {
+		return 0, fmt.Errorf("imaginary literal %s requires complex mode", literal)
 	}
-	return nil
-}
-
-func getTokenString(line string) ([]string, error) {
-	var tokens []string
-	var number strings.Builder
-
-	for i := 0; i < len(line); i++ {
-		elem := line[i]
-		if unicode.IsDigit(rune(elem)) || elem == '.' {
-			number.WriteByte(elem)
-		} else if unicode.IsSpace(rune(elem)) {
-			continue
-		} else {
-			if number.Len() > 0 {
-				tokens = append(tokens, number.String())
-				number.Reset()
-			}
-			if strings.ContainsRune("+-*/()", rune(elem)) {
-				tokens = append(tokens, string(elem))
-			} else {
-				return nil, fmt.Errorf("undefined token: %c", elem)
-			}
-		}
+	f, err := strconv.ParseFloat(literal, 64)
+	if errors.Is(err, strconv.ErrRange) && math.IsInf(f, 0) {
+		return 0, fmt.Errorf("number out of range: %s", literal)
 	}
-	if number.Len() > 0 {
-		tokens = append(tokens, number.String())
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return 0, fmt.Errorf("invalid number: %s", literal)
 	}
-	return tokens, nil
+	return f, nil
 }
 
-func infixToPostfix(tokens []string) ([]string, error) {
-	var answ []string
-	var stack []string
+// floatDomain evaluates in float64.
+type floatDomain struct{}
 
-	precedence := func(oper string) int {
-		switch oper {
-		case "+", "-":
-			return 1
-		case "*", "/":
-			return 2
-		default:
-			return 0
+var floatFunctions = map[string]function[float64]{
+	"abs": {1, func(args []float64) (float64, error) { return math.Abs(args[0]), nil }},
+	"sqrt": {1, func(args []float64) (float64, error) {
+		if args[0] < 0 {
+			return 0, errors.New("square root of a negative number requires complex mode")
 		}
-	}
-
-	for _, token := range tokens {
-		if isNumeric(token) {
-			answ = append(answ, token)
-		} else if token == "(" {
-			stack = append(stack, token)
-		} else if token == ")" {
-			for len(stack) > 0 && stack[len(stack)-1] != "(" {
-				answ = append(answ, stack[len(stack)-1])
-				stack = stack[:len(stack)-1]
-			}
-			stack = stack[:len(stack)-1]
-		} else if isOperator(token) {
-			for len(stack) > 0 {
-				top := stack[len(stack)-1]
-				if isOperator(top) && precedence(top) >= precedence(token) {
-					answ = append(answ, top)
-					stack = stack[:len(stack)-1]
-				} else {
-					break
-				}
-			}
-			stack = append(stack, token)
-		} else {
-			return nil, fmt.Errorf("undefined token: %s", token)
+		return math.Sqrt(args[0]), nil
+	}},
+	"sin": mathFunction(math.Sin),
+	"cos": mathFunction(math.Cos),
+	"tan": mathFunction(math.Tan),
+	"exp": mathFunction(math.Exp),
+	"ln": {1, func(args []float64) (float64, error) {
+		if args[0] <= 0 {
+			return 0, errors.New("logarithm of a non-positive number")
 		}
+		return math.Log(args[0]), nil
+	}},
+}
+
+func mathFunction(fn func(float64) float64) function[float64] {
+	return function[float64]{1, func(args []float64) (float64, error) {
+		return fn(args[0]), nil
+	}}
+}
+
+func (floatDomain) number(literal string) (float64, error) {
+	return parseFloat(literal)
+}
+
+func (floatDomain) ident(name string) (float64, error) {
+	return unknownIdent[float64](name)
+}
+
+func (floatDomain) unary(op string, x float64) (float64, error) {
+	switch op {
+	case "+":
+		return x, nil
+	case "-":
+		return -x, nil
+	default:
+		return undefinedOperator[float64](op)
 	}
-	for len(stack) > 0 {
-		top := stack[len(stack)-1]
-		answ = append(answ, top)
-		stack = stack[:len(stack)-1]
-	}
-	return answ, nil
-}
-
-func evaluatePostfix(postfix []string) (float64, error) {
-	var stack []float64
-
-	for _, token := range postfix {
-		if isNumeric(token) {
-			num, _ := strconv.ParseFloat(token, 64)
-			stack = append(stack, num)
-		} else if isOperator(token) {
-			if len(stack) < 2 {
-				return 0, errors.New("undefined line")
-			}
-			b := stack[len(stack)-1]
-			a := stack[len(stack)-2]
-			stack = stack[:len(stack)-2]
-
-			var res float64
-			switch token {
-			case "+":
-				res = a + b
-			case "-":
-				res = a - b
-			case "*":
-				res = a * b
-			case "/":
-				if b == 0 {
-					return 0, errors.New("division by zero")
-				}
-				res = a / b
-			default:
-				return 0, fmt.Errorf("undefined token: %s", token)
-			}
-			stack = append(stack, res)
-		} else {
-			return 0, fmt.Errorf("undefined token: %s", token)
+}
+
+func (floatDomain) binary(op string, a, b float64) (float64, error) {
+	switch op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return 0, errors.New("division by zero")
 		}
+		return a / b, nil
+	case "**":
+		return math.Pow(a, b), nil
+	default:
+		return undefinedOperator[float64](op)
 	}
+}
 
-	if len(stack) != 1 {
-		return 0, errors.New("undefined line")
-	}
-	return stack[0], nil
+func (floatDomain) call(name string, args []float64) (float64, error) {
+	return callFunction(floatFunctions, name, args)
+}
+
+func (floatDomain) compare(a, b float64) (int, error) {
+	return cmp.Compare(a, b), nil
+}
+
+func (floatDomain) equal(a, b float64) bool {
+	return a == b
 }
 
-func isNumeric(token string) bool {
-	_, err := strconv.ParseFloat(token, 64)
-	return err == nil
+func (floatDomain) truth(x float64) bool {
+	return x != 0
 }
 
-func isOperator(token string) bool {
-	return strings.Contains("+-*/", token)
+func (floatDomain) fromBool(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
 }
@@ -1,6 +1,7 @@
 package calc
 
 import (
+	"math"
 	"testing"
 )
 
@@ -38,3 +39,270 @@ func TestCalculate(t *testing.T) {
 		}
 	}
 }
+
+func TestCalculateUnaryAndFunctions(t *testing.T) {
+	calculator := NewBasicCalculator()
+
+	testCases := []struct {
+		expression string
+		expected   float64
+		expectErr  bool
+	}{
+		{"-3 + 5", 2, false},
+		{"2 * -3", -6, false},
+		{"-(1 + 2) * 2", -6, false},
+		{"abs(-4) + sqrt(9)", 7, false},
+		{"sqrt(-1)", 0, true},
+		{"foo(1)", 0, true},
+		{"x + 1", 0, true},
+		{"1.2.3", 0, true},
+		{"3 +", 0, true},
+		{"()", 0, true},
+	}
+
+	for _, tc := range testCases {
+		result, err := calculator.Calculate(tc.expression)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("expected error for expression %q, got none", tc.expression)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("did not expect error for expression %q, got %v", tc.expression, err)
+			}
+			if result != tc.expected {
+				t.Errorf("expected %v for expression %q, got %v", tc.expected, tc.expression, result)
+			}
+		}
+	}
+}
+
+func TestNumericLiterals(t *testing.T) {
+	calculator := NewBasicCalculator()
+
+	testCases := []struct {
+		expression string
+		expected   float64
+	}{
+		{"1e-9", 1e-9},
+		{"2.5E+3", 2500},
+		{"0x1F", 31},
+		{"0X_ff", 255},
+		{"0o17", 15},
+		{"0b1010", 10},
+		{"1_000_000", 1000000},
+		{".5", 0.5},
+		{"5.", 5},
+		{"0x10 + 0b1 * 1e1", 26},
+	}
+	for _, tc := range testCases {
+		result, err := calculator.Calculate(tc.expression)
+		if err != nil {
+			t.Errorf("did not expect error for expression %q, got %v", tc.expression, err)
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("expected %v for expression %q, got %v", tc.expected, tc.expression, result)
+		}
+	}
+
+	malformed := []struct {
+		expression string
+		message    string
+	}{
+		{"1.2.3", `malformed number "1.2.3" at position 0`},
+		{"2 + 1e", `malformed number "1e" at position 4`},
+		{"1e+", `malformed number "1e+" at position 0`},
+		{"0x", `malformed number "0x" at position 0`},
+		{"0b102", `malformed number "0b102" at position 0`},
+		{"1__000", `malformed number "1__000" at position 0`},
+		{"1000_", `malformed number "1000_" at position 0`},
+		{"12abc", `malformed number "12abc" at position 0`},
+		{".", `malformed number "." at position 0`},
+		{"1e999999", `exponent out of range in "1e999999" at position 0`},
+		{"1e400", `number out of range: 1e400`},
+	}
+	for _, tc := range malformed {
+		_, err := calculator.Calculate(tc.expression)
+		if err == nil || err.Error() != tc.message {
+			t.Errorf("expected error %q for expression %q, got %v", tc.message, tc.expression, err)
+		}
+	}
+}
+
+func TestComparisonAndConditional(t *testing.T) {
+	calculator := NewBasicCalculator()
+
+	testCases := []struct {
+		expression string
+		expected   float64
+		expectErr  bool
+	}{
+		{"5 > 3 ? 2 : 1", 2, false},
+		{"1 < 2 && 2 < 3", 1, false},
+		{"0 != 0 || 1", 1, false},
+		{"!0 + !5", 1, false},
+		{"1 == 1.0", 1, false},
+		{"2 >= 3", 0, false},
+		{"1 + 1 == 2", 1, false},
+		{"0 && 1 / 0", 0, false},
+		{"1 || 1 / 0", 1, false},
+		{"if(1 > 2, 1 / 0, 7)", 7, false},
+		{"1 ? 2 : 0 ? 3 : 4", 2, false},
+		{"0 ? 2 : 0 ? 3 : 4", 4, false},
+		{"if(1, 2)", 0, true},
+		{"1 ? 2", 0, true},
+		{"1 <> 2", 0, true},
+	}
+
+	for _, tc := range testCases {
+		result, err := calculator.Calculate(tc.expression)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("expected error for expression %q, got none", tc.expression)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("did not expect error for expression %q, got %v", tc.expression, err)
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("expected %v for expression %q, got %v", tc.expected, tc.expression, result)
+		}
+	}
+}
+
+func TestDeskDialect(t *testing.T) {
+	testCases := []struct {
+		expression string
+		mode       Mode
+		expected   string
+		expectErr  bool
+	}{
+		{"200 + 10%", "", "220", false},
+		{"200 - 10%", "", "180", false},
+		{"200 * 10%", "", "20", false},
+		{"20 / 10%", "", "200", false},
+		{"50% of 80", "", "40", false},
+		{"7% of 300", "", "21", false},
+		{"10% of 50% of 200", "", "10", false},
+		{"100 + 10% + 10%", "", "121", false},
+		{"2 * 100 + 10%", "", "220", false},
+		{"200 + -10%", "", "180", false},
+		{"200 + 10% of 50", "", "205", false},
+		{"10%", "", "0.1", false},
+		{"10% > 5%", "", "1", false},
+		{"19.99 + 15%", ModeDecimal, "22.9885", false},
+		{"1/3 + 50%", ModeRational, "1/2", false},
+		{"2 km + 10%", ModeUnits, "2.2 km", false},
+		{"tip(x) = x * 15%; tip(40)", "", "6", false},
+		{"x = 5%; 100 + x", "", "100.05", false},
+		{"10 % 3", ModeInteger, "", true},
+		{"5%%", "", "", true},
+	}
+
+	for _, tc := range testCases {
+		result, value, err := Evaluate(tc.expression, Options{Mode: tc.mode, Dialect: DialectDesk})
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got %v", tc.expression, result)
+			}
+			continue
+		}
+		got := FormatFloat(result)
+		if value != nil {
+			got = value.Literal()
+		}
+		if err != nil || got != tc.expected {
+			t.Errorf("%s: expected %s, got %s (%v)", tc.expression, tc.expected, got, err)
+		}
+	}
+
+	// % stays the remainder in the programming dialect.
+	if _, value, err := Evaluate("10 % 3", Options{Mode: ModeInteger, Dialect: DialectProgramming}); err != nil || value.Literal() != "1" {
+		t.Errorf("expected 10 %% 3 to be 1, got %+v (%v)", value, err)
+	}
+	if _, err := (Definition{Definition: "tip(x) = x * 15%", Dialect: DialectDesk}).Parse(); err != nil {
+		t.Errorf("expected a definition in the desk dialect to be accepted, got %v", err)
+	}
+	for _, opts := range []Options{{Dialect: "pocket"}, {Dialect: DialectDesk, Operation: OperationSimplify}} {
+		if err := opts.Validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", opts)
+		}
+	}
+}
+
+func TestLenientParsing(t *testing.T) {
+	testCases := []struct {
+		expression string
+		mode       Mode
+		expected   string
+		expectErr  bool
+	}{
+		{"x = 3; 2x", "", "6", false},
+		{"3(4+5)", "", "27", false},
+		{"a = 5; b = 2; (a+b)(a-b)", "", "21", false},
+		{"2pi", "", "6.283185307179586", false},
+		{"2π", ModeDecimal, "6.283185307179586476925286766559006", false},
+		{"3 × 4 ÷ 2 − 1", "", "5", false},
+		{"3·4", "", "12", false},
+		{"√16 + 2√9", "", "10", false},
+		{"x = 3; 2x²", "", "18", false},
+		{"2³", ModeInteger, "8", false},
+		{"x = -2; √x²", "", "2", false},
+		{"x = 4; 1/2x", ModeRational, "1/8", false},
+		{"2 sin(0) + 1", "", "1", false},
+		{"2e", "", "5.43656365691809", false},
+		{"2e3", "", "2000", false},
+		{"e = 2; 3e", "", "6", false},
+		{"area(r) = π r²; area(1)", "", "3.141592653589793", false},
+		{"2 3", "", "", true},
+		{"2.5.1", "", "", true},
+		{"pi", ModeInteger, "", true},
+	}
+
+	for _, tc := range testCases {
+		result, value, err := Evaluate(tc.expression, Options{Mode: tc.mode, Lenient: true})
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got %v", tc.expression, result)
+			}
+			continue
+		}
+		got := FormatFloat(result)
+		if value != nil {
+			got = value.Literal()
+		}
+		if err != nil || got != tc.expected {
+			t.Errorf("%s: expected %s, got %s (%v)", tc.expression, tc.expected, got, err)
+		}
+	}
+
+	// Without lenient parsing the notation stays an error, but the
+	// constants are available.
+	for _, expression := range []string{"x = 3; 2x", "3(4+5)", "2 × 3", "√4", "3²", "2e"} {
+		if _, _, err := Evaluate(expression, Options{}); err == nil {
+			t.Errorf("%s: expected error without lenient parsing", expression)
+		}
+	}
+	if result, _, err := Evaluate("2 * pi", Options{}); err != nil || FormatFloat(result) != "6.283185307179586" {
+		t.Errorf("expected pi to be a constant, got %v (%v)", result, err)
+	}
+	if _, err := (Definition{Definition: "area(r) = π r²", Lenient: true}).Parse(); err != nil {
+		t.Errorf("expected a lenient definition to be accepted, got %v", err)
+	}
+	// Symbolic operations parse leniently too.
+	if _, value, err := Evaluate("(x+1)(x-1)", Options{Lenient: true, Operation: OperationSimplify}); err != nil || value.Expression != "x^2 - 1" {
+		t.Errorf("expected a lenient simplification, got %+v (%v)", value, err)
+	}
+	if _, value, err := Evaluate("3x²", Options{Lenient: true, Operation: OperationDerive, Variable: "x"}); err != nil || value.Expression != "6*x" {
+		t.Errorf("expected a lenient derivative, got %+v (%v)", value, err)
+	}
+	if _, value, err := Evaluate("x^3 - 2x = 5", Options{Lenient: true, Operation: OperationSolve, Variable: "x"}); err != nil || len(value.Roots) != 1 || math.Abs(value.Roots[0]-2.0945514815423265) > 1e-9 {
+		t.Errorf("expected the root of x^3 - 2x = 5, got %+v (%v)", value, err)
+	}
+	if _, _, err := Evaluate("x^3 - 2x = 5", Options{Operation: OperationSolve, Variable: "x"}); err == nil {
+		t.Error("expected 2x to be rejected without lenient parsing")
+	}
+}
@@ -0,0 +1,176 @@
+package calc
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"math/cmplx"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Compiled is an expression parsed once, with its functions and inputs
+// loaded, for evaluating it at many values of its variables.
+type Compiled struct {
+	evaluate func(names, values []string) (float64, *Value, error)
+}
+
+// A Sample is the outcome of evaluating a Compiled expression at one
+// point: Result and Value as Evaluate returns them, with Result only set
+// for real values within the range of float64, or Error.
+type Sample struct {
+	Result *float64 `json:"result,omitempty"`
+	Value  *Value   `json:"value,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// Compile prepares expression for evaluation as configured by opts. Only
+// evaluation can be compiled, not symbolic operations or solving.
+func Compile(expression string, opts Options) (*Compiled, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.Operation != "" && opts.Operation != OperationEvaluate {
+		return nil, errors.New("only evaluation can be compiled")
+	}
+	scope, err := opts.scope()
+	if err != nil {
+		return nil, err
+	}
+	switch opts.Mode {
+	case ModeDecimal:
+		rounding, _ := ParseRoundingMode(opts.Rounding)
+		c := NewDecimalCalculator(opts.Precision, rounding)
+		ctx := decimalContext{precision: c.Precision, rounding: c.Rounding}
+		return compile(expression, ctx, scope, func(d *Decimal) (float64, *Value, error) {
+			d = d.reduce()
+			return d.Float64(), &Value{Type: ModeDecimal, Decimal: d.String()}, nil
+		})
+	case ModeRational:
+		return compile(expression, rationalDomain{}, scope, func(r *big.Rat) (float64, *Value, error) {
+			f, _ := r.Float64()
+			return f, ratValue(r, opts.Precision), nil
+		})
+	case ModeComplex:
+		return compile(expression, complexDomain{}, scope, func(z complex128) (float64, *Value, error) {
+			if cmplx.IsInf(z) || cmplx.IsNaN(z) {
+				return 0, nil, errors.New("result is not a finite number")
+			}
+			return real(z), complexValue(z), nil
+		})
+	case ModeInteger:
+		return compile(expression, integerDomain{}, scope, func(n int64) (float64, *Value, error) {
+			return float64(n), &Value{Type: ModeInteger, Integer: strconv.FormatInt(n, 10)}, nil
+		})
+	case ModeUnits:
+		return compile(expression, unitsDomain{}, scope, func(q quantity) (float64, *Value, error) {
+			if math.IsInf(q.value, 0) || math.IsNaN(q.value) {
+				return 0, nil, errors.New("result is not a finite number")
+			}
+			return q.value, quantityValue(Quantity{Value: q.value, Unit: q.unit()}), nil
+		})
+	case ModeTime:
+		location, _ := time.LoadLocation(opts.TimeZone)
+		return compile(expression, timeDomain{location: location}, scope, func(m moment) (float64, *Value, error) {
+			value, err := m.value(location)
+			if err != nil {
+				return 0, nil, err
+			}
+			return value.Float64(), value, nil
+		})
+	case ModeMatrix:
+		return compile(expression, matrixDomain{}, scope, func(a array) (float64, *Value, error) {
+			value, err := a.value()
+			if err != nil {
+				return 0, nil, err
+			}
+			return value.Float64(), value, nil
+		})
+	default:
+		return compile(expression, floatDomain{}, scope, func(f float64) (float64, *Value, error) {
+			if math.IsInf(f, 0) || math.IsNaN(f) {
+				return 0, nil, errors.New("result is not a finite number")
+			}
+			return f, nil, nil
+		})
+	}
+}
+
+func compile[T any](expression string, d domain[T], scope *Scope, result func(T) (float64, *Value, error)) (*Compiled, error) {
+	global := newEnv(d)
+	if err := loadScope(scope, global); err != nil {
+		return nil, err
+	}
+	n, err := parse(expression, syntaxOf(d, scope))
+	if err != nil {
+		return nil, err
+	}
+	if !hasResult(n) {
+		return nil, errors.New("expression has no result: it ends with a function definition")
+	}
+	return &Compiled{evaluate: func(names, values []string) (float64, *Value, error) {
+		// Scripts may assign variables and define functions, so every
+		// evaluation gets its own copy of the environment.
+		e := newEnv(d)
+		for name, v := range global.vars {
+			e.vars[name] = v
+		}
+		for name, f := range global.functions {
+			e.functions[name] = f
+		}
+		e.datasets = global.datasets
+		for i, name := range names {
+			v, err := literal(d, values[i])
+			if err != nil {
+				return 0, nil, err
+			}
+			e.vars[name] = v
+		}
+		v, err := evaluate(n, e)
+		if err != nil {
+			return 0, nil, err
+		}
+		return result(v)
+	}}, nil
+}
+
+// literal returns the value of a number literal with an optional sign.
+func literal[T any](d domain[T], s string) (T, error) {
+	if rest, negative := strings.CutPrefix(s, "-"); negative {
+		v, err := d.number(rest)
+		if err != nil {
+			return v, err
+		}
+		return d.unary("-", v)
+	}
+	return d.number(s)
+}
+
+// Evaluate evaluates c with the variables in names set to the number
+// literals in values.
+func (c *Compiled) Evaluate(names, values []string) (float64, *Value, error) {
+	return c.evaluate(names, values)
+}
+
+// Sweep evaluates c at count points of g from offset on.
+func (c *Compiled) Sweep(g Grid, offset, count int) ([]Sample, error) {
+	points, err := g.Points(offset, count)
+	if err != nil {
+		return nil, err
+	}
+	names := g.Names()
+	samples := make([]Sample, len(points))
+	for i, point := range points {
+		result, value, err := c.evaluate(names, point)
+		switch {
+		case err != nil:
+			samples[i].Error = err.Error()
+		case value == nil || value.HasFloat64():
+			samples[i].Result, samples[i].Value = &result, value
+		default:
+			samples[i].Value = value
+		}
+	}
+	return samples, nil
+}
@@ -0,0 +1,83 @@
+package calc
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCompiledEvaluate(t *testing.T) {
+	testCases := []struct {
+		expression string
+		opts       Options
+		values     []string
+		expected   string
+		expectErr  bool
+	}{
+		{"x^2 + y", Options{}, []string{"3", "-1"}, "8", false},
+		{"x + y", Options{Mode: ModeDecimal}, []string{"0.1", "0.2"}, "0.3", false},
+		{"x / y", Options{Mode: ModeRational}, []string{"1", "3"}, "1/3", false},
+		{"sqrt(x) + y", Options{Mode: ModeComplex}, []string{"-4", "0"}, "0+2i", false},
+		{"x ^ y", Options{Mode: ModeInteger}, []string{"6", "3"}, "5", false},
+		{"x ^ y", Options{Mode: ModeInteger}, []string{"0.5", "3"}, "", true},
+		{"f(x) + y", Options{Functions: []string{"f(t) = 2*t"}}, []string{"2", "1"}, "5", false},
+		{"x = x + 1; x * y", Options{}, []string{"1", "3"}, "6", false},
+		{"x / y", Options{}, []string{"1", "0"}, "", true},
+	}
+
+	for _, tc := range testCases {
+		c, err := Compile(tc.expression, tc.opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expression, err)
+		}
+		result, value, err := c.Evaluate([]string{"x", "y"}, tc.values)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s at %v: expected error, got %v", tc.expression, tc.values, result)
+			}
+			continue
+		}
+		got := FormatFloat(result)
+		if value != nil {
+			got = value.Literal()
+		}
+		if err != nil || got != tc.expected {
+			t.Errorf("%s at %v: expected %s, got %s (%v)", tc.expression, tc.values, tc.expected, got, err)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, tc := range []struct {
+		expression string
+		opts       Options
+	}{
+		{"x +", Options{}},
+		{"f(x) = x", Options{}},
+		{"x", Options{Operation: OperationDerive, Variable: "x"}},
+		{"x", Options{Mode: "hex"}},
+	} {
+		if _, err := Compile(tc.expression, tc.opts); err == nil {
+			t.Errorf("%s: expected error", tc.expression)
+		}
+	}
+}
+
+func TestCompiledSweep(t *testing.T) {
+	c, err := Compile("1/x", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := Grid{{Name: "x", From: "-1", To: "1", Step: "0.5"}}
+	samples, err := c.Sweep(g, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(samples) != 3 || samples[0].Result == nil || *samples[0].Result != -2 ||
+		samples[1].Error != "division by zero" || samples[2].Result == nil || *samples[2].Result != 2 {
+		data, _ := json.Marshal(samples)
+		t.Fatalf("expected -2, division by zero and 2, got %s", data)
+	}
+	if _, err := c.Sweep(g, 4, 2); err == nil {
+		t.Fatal("expected error for points past the end of the grid")
+	}
+}
@@ -0,0 +1,168 @@
+package calc
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math/cmplx"
+	"strings"
+)
+
+// ComplexCalculator evaluates expressions over complex numbers. The
+// imaginary unit is written i, either alone or as a literal suffix as in
+// 3+4i.
+type ComplexCalculator struct {
+	// Scope, if not nil, holds user-defined functions expressions can call.
+	Scope *Scope
+}
+
+func NewComplexCalculator() *ComplexCalculator {
+	return &ComplexCalculator{}
+}
+
+// Evaluate returns the complex value of expression.
+func (c *ComplexCalculator) Evaluate(expression string) (complex128, error) {
+	result, err := evaluateString[complex128](expression, complexDomain{}, c.Scope)
+	if err != nil {
+		return 0, err
+	}
+	if cmplx.IsInf(result) || cmplx.IsNaN(result) {
+		return 0, errors.New("result is not a finite number")
+	}
+	return result, nil
+}
+
+// Exact implements ExactCalculator.
+func (c *ComplexCalculator) Exact(expression string) (*Value, error) {
+	result, err := c.Evaluate(expression)
+	if err != nil {
+		return nil, err
+	}
+	return complexValue(result), nil
+}
+
+// Calculate implements Calculator with the real part of the result.
+func (c *ComplexCalculator) Calculate(expression string) (float64, error) {
+	result, err := c.Evaluate(expression)
+	return real(result), err
+}
+
+func complexValue(z complex128) *Value {
+	re, im := real(z), imag(z)
+	return &Value{Type: ModeComplex, Re: &re, Im: &im}
+}
+
+// complexDomain evaluates in complex128.
+type complexDomain struct{}
+
+func realFunction(fn func(complex128) float64) function[complex128] {
+	return function[complex128]{1, func(args []complex128) (complex128, error) {
+		return complex(fn(args[0]), 0), nil
+	}}
+}
+
+var complexFunctions = map[string]function[complex128]{
+	"re":  realFunction(func(z complex128) float64 { return real(z) }),
+	"im":  realFunction(func(z complex128) float64 { return imag(z) }),
+	"abs": realFunction(cmplx.Abs),
+	"arg": realFunction(cmplx.Phase),
+	"conj": {1, func(args []complex128) (complex128, error) {
+		return cmplx.Conj(args[0]), nil
+	}},
+	"sqrt": complexFunction(cmplx.Sqrt),
+	"sin":  complexFunction(cmplx.Sin),
+	"cos":  complexFunction(cmplx.Cos),
+	"tan":  complexFunction(cmplx.Tan),
+	"exp":  complexFunction(cmplx.Exp),
+	"ln": {1, func(args []complex128) (complex128, error) {
+		if args[0] == 0 {
+			return 0, errors.New("logarithm of zero")
+		}
+		return cmplx.Log(args[0]), nil
+	}},
+}
+
+func complexFunction(fn func(complex128) complex128) function[complex128] {
+	return function[complex128]{1, func(args []complex128) (complex128, error) {
+		return fn(args[0]), nil
+	}}
+}
+
+func (complexDomain) number(literal string) (complex128, error) {
+	if !isImaginary(literal) {
+		f, err := parseFloat(literal)
+		return complex(f, 0), err
+	}
+	f, err := parseFloat(strings.TrimSuffix(literal, "i"))
+	if err != nil {
+		return 0, fmt.Errorf("invalid number: %s", literal)
+	}
+	return complex(0, f), nil
+}
+
+func (complexDomain) ident(name string) (complex128, error) {
+	if name == "i" {
+		return complex(0, 1), nil
+	}
+	return unknownIdent[complex128](name)
+}
+
+func (complexDomain) unary(op string, x complex128) (complex128, error) {
+	switch op {
+	case "+":
+		return x, nil
+	case "-":
+		// Subtract from zero rather than negate, so -1 does not get a
+		// negative-zero imaginary part that would put sqrt(-1) on the
+		// wrong side of its branch cut.
+		return 0 - x, nil
+	default:
+		return undefinedOperator[complex128](op)
+	}
+}
+
+func (complexDomain) binary(op string, a, b complex128) (complex128, error) {
+	switch op {
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/":
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	case "**":
+		return cmplx.Pow(a, b), nil
+	default:
+		return undefinedOperator[complex128](op)
+	}
+}
+
+func (complexDomain) call(name string, args []complex128) (complex128, error) {
+	return callFunction(complexFunctions, name, args)
+}
+
+func (complexDomain) compare(a, b complex128) (int, error) {
+	if imag(a) != 0 || imag(b) != 0 {
+		return 0, errors.New("complex numbers are not ordered")
+	}
+	return cmp.Compare(real(a), real(b)), nil
+}
+
+func (complexDomain) equal(a, b complex128) bool {
+	return a == b
+}
+
+func (complexDomain) truth(x complex128) bool {
+	return x != 0
+}
+
+func (complexDomain) fromBool(b bool) complex128 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,75 @@
+package calc
+
+import (
+	"math"
+	"testing"
+)
+
+func TestComplexCalculator(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   complex128
+		expectErr  bool
+	}{
+		{"3+4i", complex(3, 4), false},
+		{"i * i", complex(-1, 0), false},
+		{"sqrt(-1)", complex(0, 1), false},
+		{"abs(3+4i)", complex(5, 0), false},
+		{"re(3-4i) + im(3-4i)", complex(-1, 0), false},
+		{"conj(1+2i)", complex(1, -2), false},
+		{"arg(i)", complex(math.Pi/2, 0), false},
+		{"(1+2i) * (3-i)", complex(5, 5), false},
+		{"10 / (1+i)", complex(5, -5), false},
+		{"2.5i", complex(0, 2.5), false},
+		{"1 / (i - i)", 0, true},
+		{"abs(1, 2)", 0, true},
+		{"j", 0, true},
+	}
+
+	calculator := NewComplexCalculator()
+	for _, tc := range testCases {
+		result, err := calculator.Evaluate(tc.expression)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("expected error for expression %q, got none", tc.expression)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("did not expect error for expression %q, got %v", tc.expression, err)
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("expected %v for expression %q, got %v", tc.expected, tc.expression, result)
+		}
+	}
+}
+
+func TestComplexValue(t *testing.T) {
+	result, value, err := Evaluate("3+4i", Options{Mode: ModeComplex})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value.Type != ModeComplex || *value.Re != 3 || *value.Im != 4 || result != 3 {
+		t.Fatalf("unexpected result %v, %+v", result, value)
+	}
+	if value.IsReal() {
+		t.Fatal("3+4i should not be real")
+	}
+	if _, err := NewBasicCalculator().Calculate("3+4i"); err == nil {
+		t.Fatal("expected imaginary literal to be rejected in float mode")
+	}
+}
+
+func TestComplexComparison(t *testing.T) {
+	calculator := NewComplexCalculator()
+	if result, err := calculator.Evaluate("(1+i) == (1+i)"); err != nil || result != 1 {
+		t.Errorf("unexpected equality result %v, %v", result, err)
+	}
+	if result, err := calculator.Evaluate("2 < 3"); err != nil || result != 1 {
+		t.Errorf("unexpected ordering result %v, %v", result, err)
+	}
+	if _, err := calculator.Evaluate("i < 2"); err == nil {
+		t.Error("expected error ordering complex numbers")
+	}
+}
@@ -0,0 +1,383 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// RoundingMode selects how decimal results are rounded to the configured
+// precision.
+type RoundingMode int
+
+const (
+	RoundHalfEven RoundingMode = iota
+	RoundHalfUp
+	RoundHalfDown
+	RoundUp
+	RoundDown
+	RoundCeiling
+	RoundFloor
+)
+
+var roundingModeNames = map[string]RoundingMode{
+	"half_even": RoundHalfEven,
+	"half_up":   RoundHalfUp,
+	"half_down": RoundHalfDown,
+	"up":        RoundUp,
+	"down":      RoundDown,
+	"ceiling":   RoundCeiling,
+	"floor":     RoundFloor,
+}
+
+// ParseRoundingMode parses a rounding mode name such as "half_even". An
+// empty name selects RoundHalfEven.
+func ParseRoundingMode(name string) (RoundingMode, error) {
+	if name == "" {
+		return RoundHalfEven, nil
+	}
+	mode, ok := roundingModeNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown rounding mode: %s", name)
+	}
+	return mode, nil
+}
+
+// DefaultDecimalPrecision is the number of significant digits kept by
+// decimal arithmetic when no precision is configured, as in IEEE 754
+// decimal128.
+const DefaultDecimalPrecision = 34
+
+// MaxDecimalPrecision bounds the configurable precision.
+const MaxDecimalPrecision = 1000
+
+// Decimal is an arbitrary-precision decimal number equal to
+// coef * 10^-scale.
+type Decimal struct {
+	coef  *big.Int
+	scale int
+}
+
+// ParseDecimal parses an unsigned decimal literal such as "12", "0.1",
+// "3." or "1.5e-9".
+func ParseDecimal(s string) (*Decimal, error) {
+	mantissa, exponent, hasExponent := strings.Cut(strings.ToLower(s), "e")
+	intPart, fracPart, _ := strings.Cut(mantissa, ".")
+	digits := intPart + fracPart
+	if digits == "" || strings.Contains(fracPart, ".") || strings.ContainsAny(digits, "+-") {
+		return nil, fmt.Errorf("invalid number: %s", s)
+	}
+	coef, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid number: %s", s)
+	}
+	scale := len(fracPart)
+	if hasExponent {
+		exp, err := strconv.Atoi(exponent)
+		if err != nil || exp > maxExponent || exp < -maxExponent {
+			return nil, fmt.Errorf("invalid number: %s", s)
+		}
+		scale -= exp
+	}
+	return &Decimal{coef: coef, scale: scale}, nil
+}
+
+// String renders d in plain notation without an exponent.
+func (d *Decimal) String() string {
+	digits := new(big.Int).Abs(d.coef).String()
+	sign := ""
+	if d.coef.Sign() < 0 {
+		sign = "-"
+	}
+	if d.scale <= 0 {
+		return sign + digits + strings.Repeat("0", -d.scale)
+	}
+	if len(digits) <= d.scale {
+		digits = strings.Repeat("0", d.scale-len(digits)+1) + digits
+	}
+	point := len(digits) - d.scale
+	return sign + digits[:point] + "." + digits[point:]
+}
+
+// Float64 returns the float64 nearest to d.
+func (d *Decimal) Float64() float64 {
+	f, _ := strconv.ParseFloat(d.String(), 64)
+	return f
+}
+
+// reduce strips trailing zeros from the fractional part.
+func (d *Decimal) reduce() *Decimal {
+	coef := new(big.Int).Set(d.coef)
+	scale := d.scale
+	ten := big.NewInt(10)
+	rem := new(big.Int)
+	for scale > 0 && coef.Sign() != 0 {
+		q, r := new(big.Int).QuoRem(coef, ten, rem)
+		if r.Sign() != 0 {
+			break
+		}
+		coef = q
+		scale--
+	}
+	if coef.Sign() == 0 {
+		scale = 0
+	}
+	return &Decimal{coef: coef, scale: scale}
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+func numDigits(x *big.Int) int {
+	if x.Sign() == 0 {
+		return 1
+	}
+	return len(new(big.Int).Abs(x).String())
+}
+
+// align returns the coefficients of a and b rescaled to a common scale.
+func align(a, b *Decimal) (*big.Int, *big.Int, int) {
+	switch {
+	case a.scale > b.scale:
+		return a.coef, new(big.Int).Mul(b.coef, pow10(a.scale-b.scale)), a.scale
+	case b.scale > a.scale:
+		return new(big.Int).Mul(a.coef, pow10(b.scale-a.scale)), b.coef, b.scale
+	default:
+		return a.coef, b.coef, a.scale
+	}
+}
+
+// decimalContext holds the precision and rounding applied to every
+// decimal operation.
+type decimalContext struct {
+	precision int
+	rounding  RoundingMode
+}
+
+var decimalFunctions = map[string]function[*Decimal]{
+	"abs": {1, func(args []*Decimal) (*Decimal, error) {
+		return &Decimal{coef: new(big.Int).Abs(args[0].coef), scale: args[0].scale}, nil
+	}},
+}
+
+func (c decimalContext) number(literal string) (*Decimal, error) {
+	if isImaginary(literal) {
+		return nil, fmt.Errorf("imaginary literal %s requires complex mode", literal)
+	}
+	return ParseDecimal(literal)
+}
+
+func (c decimalContext) ident(name string) (*Decimal, error) {
+	return unknownIdent[*Decimal](name)
+}
+
+func (c decimalContext) unary(op string, x *Decimal) (*Decimal, error) {
+	switch op {
+	case "+":
+		return x, nil
+	case "-":
+		return &Decimal{coef: new(big.Int).Neg(x.coef), scale: x.scale}, nil
+	default:
+		return undefinedOperator[*Decimal](op)
+	}
+}
+
+func (c decimalContext) binary(op string, a, b *Decimal) (*Decimal, error) {
+	switch op {
+	case "+":
+		return c.add(a, b), nil
+	case "-":
+		return c.sub(a, b), nil
+	case "*":
+		return c.mul(a, b), nil
+	case "/":
+		return c.quo(a, b)
+	case "**":
+		return c.pow(a, b)
+	default:
+		return undefinedOperator[*Decimal](op)
+	}
+}
+
+func (c decimalContext) call(name string, args []*Decimal) (*Decimal, error) {
+	return callFunction(decimalFunctions, name, args)
+}
+
+func (c decimalContext) compare(a, b *Decimal) (int, error) {
+	x, y, _ := align(a, b)
+	return x.Cmp(y), nil
+}
+
+func (c decimalContext) equal(a, b *Decimal) bool {
+	x, y, _ := align(a, b)
+	return x.Cmp(y) == 0
+}
+
+func (c decimalContext) truth(x *Decimal) bool {
+	return x.coef.Sign() != 0
+}
+
+func (c decimalContext) fromBool(b bool) *Decimal {
+	if b {
+		return &Decimal{coef: big.NewInt(1)}
+	}
+	return &Decimal{coef: big.NewInt(0)}
+}
+
+func (c decimalContext) add(a, b *Decimal) *Decimal {
+	x, y, scale := align(a, b)
+	return c.round(new(big.Int).Add(x, y), scale, false)
+}
+
+func (c decimalContext) sub(a, b *Decimal) *Decimal {
+	x, y, scale := align(a, b)
+	return c.round(new(big.Int).Sub(x, y), scale, false)
+}
+
+func (c decimalContext) mul(a, b *Decimal) *Decimal {
+	return c.round(new(big.Int).Mul(a.coef, b.coef), a.scale+b.scale, false)
+}
+
+func (c decimalContext) quo(a, b *Decimal) (*Decimal, error) {
+	if b.coef.Sign() == 0 {
+		return nil, errors.New("division by zero")
+	}
+	// Shift the dividend so the quotient has at least one digit more than
+	// the precision; the remainder then only decides ties.
+	shift := c.precision + numDigits(b.coef) - numDigits(a.coef) + 1
+	if shift < 0 {
+		shift = 0
+	}
+	num := new(big.Int).Mul(a.coef, pow10(shift))
+	q, r := new(big.Int).QuoRem(num, b.coef, new(big.Int))
+	return c.round(q, a.scale-b.scale+shift, r.Sign() != 0), nil
+}
+
+// maxDecimalScale bounds the scale of powers, which can otherwise reach
+// millions of digits from a short expression.
+const maxDecimalScale = 10 * maxExponent
+
+// pow raises a to the integer power b, rounding after every
+// multiplication.
+func (c decimalContext) pow(a, b *Decimal) (*Decimal, error) {
+	b = b.reduce()
+	if b.scale > 0 {
+		return nil, errors.New("decimal mode supports only integer exponents")
+	}
+	if b.scale < -18 {
+		return nil, errors.New("exponent out of range")
+	}
+	n := new(big.Int).Mul(b.coef, pow10(-b.scale))
+	if !n.IsInt64() {
+		return nil, errors.New("exponent out of range")
+	}
+	exp := n.Int64()
+	negative := exp < 0
+	if negative {
+		exp = -exp
+	}
+	one := &Decimal{coef: big.NewInt(1)}
+	result, err := power(a, exp, one, func(x, y *Decimal) (*Decimal, error) {
+		z := c.mul(x, y)
+		if z.scale > maxDecimalScale || z.scale < -maxDecimalScale {
+			return nil, errors.New("result out of range")
+		}
+		return z, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if negative {
+		return c.quo(one, result)
+	}
+	return result, nil
+}
+
+// round rounds coef*10^-scale to the context precision. sticky reports
+// that nonzero digits were already discarded below coef.
+func (c decimalContext) round(coef *big.Int, scale int, sticky bool) *Decimal {
+	drop := numDigits(coef) - c.precision
+	if drop <= 0 {
+		return &Decimal{coef: coef, scale: scale}
+	}
+	negative := coef.Sign() < 0
+	divisor := pow10(drop)
+	q, r := new(big.Int).QuoRem(new(big.Int).Abs(coef), divisor, new(big.Int))
+	inexact := r.Sign() != 0 || sticky
+	half := new(big.Int).Lsh(r, 1).Cmp(divisor)
+
+	var increment bool
+	switch c.rounding {
+	case RoundDown:
+	case RoundUp:
+		increment = inexact
+	case RoundCeiling:
+		increment = inexact && !negative
+	case RoundFloor:
+		increment = inexact && negative
+	case RoundHalfUp:
+		increment = half >= 0
+	case RoundHalfDown:
+		increment = half > 0 || (half == 0 && sticky)
+	default:
+		increment = half > 0 || (half == 0 && (sticky || q.Bit(0) == 1))
+	}
+	if increment {
+		q.Add(q, big.NewInt(1))
+	}
+	if negative {
+		q.Neg(q)
+	}
+	return &Decimal{coef: q, scale: scale - drop}
+}
+
+// DecimalCalculator evaluates expressions in decimal arithmetic, so that
+// 0.1 + 0.2 is exactly 0.3. Results are rounded to Precision significant
+// digits using Rounding.
+type DecimalCalculator struct {
+	Precision int
+	Rounding  RoundingMode
+	// Scope, if not nil, holds user-defined functions expressions can call.
+	Scope *Scope
+}
+
+// NewDecimalCalculator returns a calculator keeping precision significant
+// digits; a non-positive precision selects DefaultDecimalPrecision.
+func NewDecimalCalculator(precision int, rounding RoundingMode) *DecimalCalculator {
+	if precision <= 0 {
+		precision = DefaultDecimalPrecision
+	}
+	return &DecimalCalculator{Precision: precision, Rounding: rounding}
+}
+
+// Evaluate returns the exact decimal value of expression.
+func (c *DecimalCalculator) Evaluate(expression string) (*Decimal, error) {
+	ctx := decimalContext{precision: c.Precision, rounding: c.Rounding}
+	result, err := evaluateString[*Decimal](expression, ctx, c.Scope)
+	if err != nil {
+		return nil, err
+	}
+	return result.reduce(), nil
+}
+
+// Exact implements ExactCalculator.
+func (c *DecimalCalculator) Exact(expression string) (*Value, error) {
+	result, err := c.Evaluate(expression)
+	if err != nil {
+		return nil, err
+	}
+	return &Value{Type: ModeDecimal, Decimal: result.String()}, nil
+}
+
+// Calculate implements Calculator with a float64 approximation of the
+// decimal result.
+func (c *DecimalCalculator) Calculate(expression string) (float64, error) {
+	result, err := c.Evaluate(expression)
+	if err != nil {
+		return 0, err
+	}
+	return result.Float64(), nil
+}
@@ -0,0 +1,133 @@
+package calc
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDecimalCalculator(t *testing.T) {
+	testCases := []struct {
+		expression string
+		precision  int
+		rounding   RoundingMode
+		expected   string
+		expectErr  bool
+	}{
+		{"0.1 + 0.2", 0, RoundHalfEven, "0.3", false},
+		{"1.50 * 2", 0, RoundHalfEven, "3", false},
+		{"10 / 4", 0, RoundHalfEven, "2.5", false},
+		{"1 / 3", 5, RoundHalfEven, "0.33333", false},
+		{"2 / 3", 5, RoundHalfEven, "0.66667", false},
+		{"2 / 3", 5, RoundDown, "0.66666", false},
+		{"(0 - 2) / 3", 5, RoundFloor, "-0.66667", false},
+		{"(0 - 2) / 3", 5, RoundCeiling, "-0.66666", false},
+		{"0.125 * 1", 2, RoundHalfEven, "0.12", false},
+		{"0.125 * 1", 2, RoundHalfUp, "0.13", false},
+		{"0.135 * 1", 2, RoundHalfEven, "0.14", false},
+		{"0.125 * 1", 2, RoundHalfDown, "0.12", false},
+		{"0.121 * 1", 2, RoundUp, "0.13", false},
+		{"123456 * 1", 3, RoundHalfEven, "123000", false},
+		{"100000000000000000000.1 - 100000000000000000000", 0, RoundHalfEven, "0.1", false},
+		{"1 / 0", 0, RoundHalfEven, "", true},
+		{"1.2.3 + 1", 0, RoundHalfEven, "", true},
+		{"(1 + 2", 0, RoundHalfEven, "", true},
+	}
+
+	for _, tc := range testCases {
+		result, err := NewDecimalCalculator(tc.precision, tc.rounding).Evaluate(tc.expression)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("expected error for expression %q, got none", tc.expression)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("did not expect error for expression %q, got %v", tc.expression, err)
+			continue
+		}
+		if result.String() != tc.expected {
+			t.Errorf("expected %s for expression %q, got %s", tc.expected, tc.expression, result)
+		}
+	}
+}
+
+func TestParseRoundingMode(t *testing.T) {
+	if mode, err := ParseRoundingMode(""); err != nil || mode != RoundHalfEven {
+		t.Errorf("expected half_even default, got %v, %v", mode, err)
+	}
+	if mode, err := ParseRoundingMode("floor"); err != nil || mode != RoundFloor {
+		t.Errorf("expected floor, got %v, %v", mode, err)
+	}
+	if _, err := ParseRoundingMode("sideways"); err == nil {
+		t.Error("expected error for unknown rounding mode")
+	}
+}
+
+func TestEvaluateOptions(t *testing.T) {
+	result, value, err := Evaluate("0.1 + 0.2", Options{Mode: ModeDecimal})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value == nil || value.Type != ModeDecimal || value.Decimal != "0.3" || result != 0.3 {
+		t.Fatalf("unexpected decimal result %v, %+v", result, value)
+	}
+
+	result, value, err = Evaluate("2 + 2", Options{})
+	if err != nil || value != nil || result != 4 {
+		t.Fatalf("unexpected float result %v, %+v, %v", result, value, err)
+	}
+
+	for _, opts := range []Options{{Mode: "hex"}, {Precision: -1}, {Rounding: "sideways"}} {
+		if _, _, err := Evaluate("1", opts); err == nil {
+			t.Errorf("expected error for options %+v", opts)
+		}
+	}
+}
+
+func TestDecimalBeyondFloat64(t *testing.T) {
+	large := "1" + strings.Repeat("0", 400)
+	for expression, expected := range map[string]string{"1e400": large, "10^400": large, "-(10^400)": "-" + large} {
+		result, value, err := Evaluate(expression, Options{Mode: ModeDecimal})
+		if err != nil || result != 0 || value.Decimal != expected || value.HasFloat64() {
+			t.Errorf("%s: expected the exact value %s without an approximation, got %v, %+v (%v)", expression, expected, result, value, err)
+		}
+	}
+	compiled, err := Compile("x * 10^400", Options{Mode: ModeDecimal})
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, err := compiled.Sweep(Grid{{Name: "x", Values: []json.Number{"0", "1"}}}, 0, 2)
+	if err != nil || samples[0].Result == nil || samples[1].Result != nil || samples[1].Value == nil || samples[1].Value.Decimal != large {
+		t.Errorf("expected only the finite sample to have a result, got %+v (%v)", samples, err)
+	}
+	if _, value, err := Evaluate("10^300 * 1e-300", Options{Mode: ModeDecimal}); err != nil || value.Decimal != "1" {
+		t.Errorf("expected large intermediate results to be kept, got %+v (%v)", value, err)
+	}
+}
+
+func TestExactModesNumericLiterals(t *testing.T) {
+	result, err := NewDecimalCalculator(0, RoundHalfEven).Evaluate("1.5e-9 + 0x10")
+	if err != nil || result.String() != "16.0000000015" {
+		t.Errorf("unexpected decimal result %v, %v", result, err)
+	}
+	result, err = NewDecimalCalculator(0, RoundHalfEven).Evaluate("1_000 * 1e3")
+	if err != nil || result.String() != "1000000" {
+		t.Errorf("unexpected decimal result %v, %v", result, err)
+	}
+	rat, err := NewRationalCalculator(0).Evaluate("1e-3 + 0b11")
+	if err != nil || rat.RatString() != "3001/1000" {
+		t.Errorf("unexpected rational result %v, %v", rat, err)
+	}
+}
+
+func TestExactModesComparison(t *testing.T) {
+	result, err := NewDecimalCalculator(0, RoundHalfEven).Evaluate("0.1 + 0.2 == 0.3 ? 10 : 20")
+	if err != nil || result.String() != "10" {
+		t.Errorf("unexpected decimal result %v, %v", result, err)
+	}
+	rat, err := NewRationalCalculator(0).Evaluate("1/3 + 1/3 + 1/3 == 1 && !(1/2 > 2/3)")
+	if err != nil || rat.RatString() != "1" {
+		t.Errorf("unexpected rational result %v, %v", rat, err)
+	}
+}
@@ -0,0 +1,352 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// domain implements the arithmetic of one number system. evaluate walks a
+// syntax tree and defers every operation on values to a domain.
+type domain[T any] interface {
+	number(literal string) (T, error)
+	ident(name string) (T, error)
+	unary(op string, x T) (T, error)
+	binary(op string, x, y T) (T, error)
+	call(name string, args []T) (T, error)
+	// compare orders x and y like cmp.Compare; equal tests equality for
+	// domains whose values are not ordered.
+	compare(x, y T) (int, error)
+	equal(x, y T) bool
+	// truth and fromBool map values to booleans and back: zero is false,
+	// anything else true, and true becomes 1.
+	truth(x T) bool
+	fromBool(b bool) T
+}
+
+var comparisonOperators = map[string]bool{
+	"<": true, "<=": true, ">": true, ">=": true, "==": true, "!=": true,
+}
+
+// xorDomain is implemented by domains in which ^ is exclusive or rather
+// than exponentiation.
+type xorDomain interface {
+	xorCaret()
+}
+
+// timeSyntaxDomain is implemented by domains whose expressions are parsed
+// with the time syntax, which has date, time and duration literals.
+type timeSyntaxDomain interface {
+	timeSyntax()
+}
+
+// unitSyntaxDomain is implemented by domains whose expressions are parsed
+// with the units syntax, in which names after operands are units.
+type unitSyntaxDomain interface {
+	unitSyntax()
+}
+
+// arrayDomain is implemented by domains with array values, whose
+// expressions are parsed with the arrays syntax. array builds the value
+// of an array literal from its elements.
+type arrayDomain[T any] interface {
+	array(elements []T) (T, error)
+}
+
+// maxCallDepth bounds the nesting of user-defined function calls, so that
+// runaway recursion fails instead of exhausting the stack.
+const maxCallDepth = 1000
+
+// listDomain is implemented by domains with values that are lists of
+// values, which statistics functions accept in place of list literals.
+type listDomain[T any] interface {
+	elements(x T) ([]T, bool)
+}
+
+// env is the environment a syntax tree is evaluated in: the variables,
+// user-defined functions and datasets in scope, over the arithmetic of a
+// domain. A function call evaluates its body in a child of the global
+// environment holding the arguments.
+type env[T any] struct {
+	d         domain[T]
+	vars      map[string]T
+	functions map[string]*defineNode
+	// datasets are the values of the datasets, by #name.
+	datasets map[string][]T
+	global   *env[T]
+	depth    int
+}
+
+func newEnv[T any](d domain[T]) *env[T] {
+	e := &env[T]{d: d, vars: make(map[string]T), functions: make(map[string]*defineNode), datasets: make(map[string][]T)}
+	e.global = e
+	return e
+}
+
+// constants are the names of mathematical constants, by the literals
+// they stand for. Variables and the names of a domain, such as units or
+// symbols, take precedence over them.
+var constants = map[string]string{
+	"pi": "3.14159265358979323846264338327950288",
+	"π":  "3.14159265358979323846264338327950288",
+	"e":  "2.71828182845904523536028747135266250",
+}
+
+func (e *env[T]) lookup(name string) (T, error) {
+	if v, ok := e.vars[name]; ok {
+		return v, nil
+	}
+	if v, ok := e.global.vars[name]; ok {
+		return v, nil
+	}
+	if isDataset(name) {
+		return e.dataset(name)
+	}
+	v, err := e.d.ident(name)
+	if literal, ok := constants[name]; ok && err != nil {
+		return e.d.number(literal)
+	}
+	return v, err
+}
+
+// dataset returns the dataset name as a value, which only domains with
+// arrays have.
+func (e *env[T]) dataset(name string) (T, error) {
+	var zero T
+	values, ok := e.global.datasets[name]
+	if !ok {
+		return zero, fmt.Errorf("unknown dataset: %s", name)
+	}
+	if a, ok := e.d.(arrayDomain[T]); ok {
+		return a.array(values)
+	}
+	return zero, fmt.Errorf("dataset %s is a list: pass it to a statistics function, as in mean(%s)", name, name)
+}
+
+func (e *env[T]) call(name string, args []T) (T, error) {
+	f, ok := e.global.functions[name]
+	if !ok {
+		return e.d.call(name, args)
+	}
+	var zero T
+	if len(args) != len(f.params) {
+		return zero, fmt.Errorf("%s expects %d argument(s), got %d", name, len(f.params), len(args))
+	}
+	if e.depth >= maxCallDepth {
+		return zero, fmt.Errorf("maximum call depth exceeded in %s", name)
+	}
+	local := &env[T]{d: e.d, vars: make(map[string]T, len(args)), global: e.global, depth: e.depth + 1}
+	for i, param := range f.params {
+		local.vars[param] = args[i]
+	}
+	return evaluate(f.body, local)
+}
+
+func evaluate[T any](n node, e *env[T]) (T, error) {
+	var zero T
+	d := e.d
+	switch n := n.(type) {
+	case *numberNode:
+		return d.number(n.text)
+	case *identNode:
+		return e.lookup(n.name)
+	case *unaryNode:
+		x, err := evaluate(n.x, e)
+		if err != nil {
+			return zero, err
+		}
+		if n.op == "!" {
+			return d.fromBool(!d.truth(x)), nil
+		}
+		return d.unary(n.op, x)
+	case *binaryNode:
+		x, err := evaluate(n.x, e)
+		if err != nil {
+			return zero, err
+		}
+		y, err := evaluate(n.y, e)
+		if err != nil {
+			return zero, err
+		}
+		if comparisonOperators[n.op] {
+			return compareValues(n.op, x, y, d)
+		}
+		return d.binary(n.op, x, y)
+	case *logicalNode:
+		x, err := evaluate(n.x, e)
+		if err != nil {
+			return zero, err
+		}
+		if d.truth(x) == (n.op == "||") {
+			return d.fromBool(d.truth(x)), nil
+		}
+		y, err := evaluate(n.y, e)
+		if err != nil {
+			return zero, err
+		}
+		return d.fromBool(d.truth(y)), nil
+	case *conditionalNode:
+		cond, err := evaluate(n.cond, e)
+		if err != nil {
+			return zero, err
+		}
+		if d.truth(cond) {
+			return evaluate(n.then, e)
+		}
+		return evaluate(n.otherwise, e)
+	case *callNode:
+		if _, defined := e.global.functions[n.name]; !defined {
+			switch {
+			case binders[n.name] && !(statistics[n.name] != 0 && len(n.args) == 1):
+				return evaluateBinder(n, e)
+			case statistics[n.name] != 0:
+				return evaluateStatistic(n, e)
+			}
+		}
+		args := make([]T, len(n.args))
+		for i, arg := range n.args {
+			v, err := evaluate(arg, e)
+			if err != nil {
+				return zero, err
+			}
+			args[i] = v
+		}
+		return e.call(n.name, args)
+	case *arrayNode:
+		a, ok := d.(arrayDomain[T])
+		if !ok {
+			return zero, errors.New("arrays require matrix mode")
+		}
+		elements := make([]T, len(n.elements))
+		for i, element := range n.elements {
+			v, err := evaluate(element, e)
+			if err != nil {
+				return zero, err
+			}
+			elements[i] = v
+		}
+		return a.array(elements)
+	case *assignNode:
+		x, err := evaluate(n.x, e)
+		if err != nil {
+			return zero, err
+		}
+		e.vars[n.name] = x
+		return x, nil
+	case *defineNode:
+		e.global.functions[n.name] = n
+		return zero, nil
+	case *programNode:
+		var result T
+		for _, statement := range n.statements {
+			v, err := evaluate(statement, e)
+			if err != nil {
+				return zero, err
+			}
+			result = v
+		}
+		return result, nil
+	default:
+		return zero, fmt.Errorf("unsupported expression %T", n)
+	}
+}
+
+func compareValues[T any](op string, x, y T, d domain[T]) (T, error) {
+	switch op {
+	case "==":
+		return d.fromBool(d.equal(x, y)), nil
+	case "!=":
+		return d.fromBool(!d.equal(x, y)), nil
+	}
+	c, err := d.compare(x, y)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	switch op {
+	case "<":
+		return d.fromBool(c < 0), nil
+	case "<=":
+		return d.fromBool(c <= 0), nil
+	case ">":
+		return d.fromBool(c > 0), nil
+	default:
+		return d.fromBool(c >= 0), nil
+	}
+}
+
+// evaluateString parses and evaluates expression in d, with the
+// functions defined in scope, which may be nil.
+func evaluateString[T any](expression string, d domain[T], scope *Scope) (T, error) {
+	var zero T
+	e := newEnv(d)
+	if err := loadScope(scope, e); err != nil {
+		return zero, err
+	}
+	n, err := parse(expression, syntaxOf(d, scope))
+	if err != nil {
+		return zero, err
+	}
+	if !hasResult(n) {
+		return zero, errors.New("expression has no result: it ends with a function definition")
+	}
+	return evaluate(n, e)
+}
+
+// hasResult reports whether n produces a value, i.e. does not end with a
+// function definition.
+func hasResult(n node) bool {
+	if program, ok := n.(*programNode); ok {
+		n = program.statements[len(program.statements)-1]
+	}
+	_, isDefinition := n.(*defineNode)
+	return !isDefinition
+}
+
+// power raises x to the integer power n by repeated squaring, using mul
+// for every multiplication.
+func power[T any](x T, n int64, one T, mul func(a, b T) (T, error)) (T, error) {
+	result := one
+	var err error
+	for n > 0 {
+		if n&1 == 1 {
+			if result, err = mul(result, x); err != nil {
+				return result, err
+			}
+		}
+		n >>= 1
+		if n > 0 {
+			if x, err = mul(x, x); err != nil {
+				return x, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// function is a built-in function of a domain.
+type function[T any] struct {
+	arity int
+	fn    func(args []T) (T, error)
+}
+
+func callFunction[T any](functions map[string]function[T], name string, args []T) (T, error) {
+	var zero T
+	f, ok := functions[name]
+	if !ok {
+		return zero, fmt.Errorf("unknown function: %s", name)
+	}
+	if len(args) != f.arity {
+		return zero, fmt.Errorf("%s expects %d argument(s), got %d", name, f.arity, len(args))
+	}
+	return f.fn(args)
+}
+
+func unknownIdent[T any](name string) (T, error) {
+	var zero T
+	return zero, fmt.Errorf("unknown identifier: %s", name)
+}
+
+func undefinedOperator[T any](op string) (T, error) {
+	var zero T
+	return zero, fmt.Errorf("undefined token: %s", op)
+}
@@ -0,0 +1,154 @@
+package calc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// MaxGridPoints bounds the number of points in a Grid.
+const MaxGridPoints = 1000000
+
+// A GridVariable is one variable of a Grid. It takes the values in Values
+// or, if there are none, the values from From to To in steps of Step.
+// Numbers are decimal literals and the range is computed exactly, so a
+// variable from 0 to 1 in steps of 0.1 takes the value 0.3, not
+// 0.30000000000000004.
+type GridVariable struct {
+	Name   string        `json:"name"`
+	From   json.Number   `json:"from,omitempty"`
+	To     json.Number   `json:"to,omitempty"`
+	Step   json.Number   `json:"step,omitempty"`
+	Values []json.Number `json:"values,omitempty"`
+}
+
+// A Grid is the Cartesian product of the values of its variables, ordered
+// like nested loops over them: the last variable changes fastest.
+type Grid []GridVariable
+
+// axis enumerates the values of a GridVariable.
+type axis struct {
+	values     []string
+	from, step *big.Rat
+	digits     int
+	count      int
+}
+
+func (a *axis) value(i int) string {
+	if a.values != nil {
+		return a.values[i]
+	}
+	r := new(big.Rat).Mul(a.step, new(big.Rat).SetInt64(int64(i)))
+	s := r.Add(r, a.from).FloatString(a.digits)
+	if a.digits > 0 {
+		s = strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+	}
+	return s
+}
+
+func newAxis(v GridVariable) (*axis, error) {
+	if !IsLabel(v.Name) {
+		return nil, fmt.Errorf("invalid variable: %q", v.Name)
+	}
+	if len(v.Values) > 0 {
+		a := &axis{count: len(v.Values)}
+		for _, n := range v.Values {
+			a.values = append(a.values, n.String())
+		}
+		return a, nil
+	}
+	if v.From == "" || v.To == "" || v.Step == "" {
+		return nil, fmt.Errorf("%s needs values or from, to and step", v.Name)
+	}
+	var bounds [3]*big.Rat
+	digits := 0
+	for i, n := range []json.Number{v.From, v.To, v.Step} {
+		r, ok := new(big.Rat).SetString(n.String())
+		if !ok {
+			return nil, fmt.Errorf("invalid number: %s", n)
+		}
+		d, ok := fractionDigits(r.Denom())
+		if !ok {
+			return nil, fmt.Errorf("invalid number: %s", n)
+		}
+		bounds[i], digits = r, max(digits, d)
+	}
+	from, to, step := bounds[0], bounds[1], bounds[2]
+	if step.Sign() == 0 {
+		return nil, fmt.Errorf("step of %s must not be zero", v.Name)
+	}
+	steps := new(big.Rat).Sub(to, from)
+	steps.Quo(steps, step)
+	if steps.Sign() < 0 {
+		return nil, fmt.Errorf("range of %s is empty", v.Name)
+	}
+	if steps.Cmp(big.NewRat(MaxGridPoints, 1)) >= 0 {
+		return nil, fmt.Errorf("grid has more than %d points", MaxGridPoints)
+	}
+	count := new(big.Int).Quo(steps.Num(), steps.Denom())
+	return &axis{from: from, step: step, digits: digits, count: int(count.Int64()) + 1}, nil
+}
+
+func (g Grid) axes() ([]*axis, int, error) {
+	if len(g) == 0 {
+		return nil, 0, errors.New("grid has no variables")
+	}
+	axes := make([]*axis, len(g))
+	seen := make(map[string]bool, len(g))
+	size := 1
+	for i, v := range g {
+		if seen[v.Name] {
+			return nil, 0, fmt.Errorf("duplicate variable: %s", v.Name)
+		}
+		seen[v.Name] = true
+		a, err := newAxis(v)
+		if err != nil {
+			return nil, 0, err
+		}
+		if size *= a.count; size > MaxGridPoints {
+			return nil, 0, fmt.Errorf("grid has more than %d points", MaxGridPoints)
+		}
+		axes[i] = a
+	}
+	return axes, size, nil
+}
+
+// Size validates g and returns its number of points.
+func (g Grid) Size() (int, error) {
+	_, size, err := g.axes()
+	return size, err
+}
+
+// Names returns the names of the variables of g.
+func (g Grid) Names() []string {
+	names := make([]string, len(g))
+	for i, v := range g {
+		names[i] = v.Name
+	}
+	return names
+}
+
+// Points returns count points of g from offset on, each with the values of
+// the variables in order.
+func (g Grid) Points(offset, count int) ([][]string, error) {
+	axes, size, err := g.axes()
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 || count < 0 || offset+count > size {
+		return nil, fmt.Errorf("points %d to %d are outside the grid of %d points", offset, offset+count, size)
+	}
+	points := make([][]string, count)
+	for i := range points {
+		point := make([]string, len(axes))
+		index := offset + i
+		for j := len(axes) - 1; j >= 0; j-- {
+			point[j] = axes[j].value(index % axes[j].count)
+			index /= axes[j].count
+		}
+		points[i] = point
+	}
+	return points, nil
+}
@@ -0,0 +1,64 @@
+package calc
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestGrid(t *testing.T) {
+	testCases := []struct {
+		grid      string
+		size      int
+		points    [][]string
+		expectErr bool
+	}{
+		{`[{"name":"x","from":0,"to":1,"step":0.25}]`, 5, [][]string{{"0"}, {"0.25"}, {"0.5"}, {"0.75"}, {"1"}}, false},
+		{`[{"name":"x","from":0,"to":0.3,"step":0.1}]`, 4, [][]string{{"0"}, {"0.1"}, {"0.2"}, {"0.3"}}, false},
+		{`[{"name":"x","from":1,"to":0,"step":-0.5}]`, 3, [][]string{{"1"}, {"0.5"}, {"0"}}, false},
+		{`[{"name":"x","from":0,"to":1,"step":0.3}]`, 4, [][]string{{"0"}, {"0.3"}, {"0.6"}, {"0.9"}}, false},
+		{`[{"name":"x","from":1,"to":2,"step":1},{"name":"y","values":[10,-2.5]}]`, 4,
+			[][]string{{"1", "10"}, {"1", "-2.5"}, {"2", "10"}, {"2", "-2.5"}}, false},
+		{`[]`, 0, nil, true},
+		{`[{"name":"x","from":0,"to":1}]`, 0, nil, true},
+		{`[{"name":"x","from":0,"to":1,"step":0}]`, 0, nil, true},
+		{`[{"name":"x","from":1,"to":0,"step":1}]`, 0, nil, true},
+		{`[{"name":"x","from":0,"to":1,"step":1e-7}]`, 0, nil, true},
+		{`[{"name":"x","from":0,"to":1000,"step":1},{"name":"y","from":0,"to":1000,"step":1}]`, 0, nil, true},
+		{`[{"name":"x","values":[1]},{"name":"x","values":[2]}]`, 0, nil, true},
+		{`[{"name":"$1","values":[1]}]`, 0, nil, true},
+	}
+
+	for _, tc := range testCases {
+		var g Grid
+		if err := json.Unmarshal([]byte(tc.grid), &g); err != nil {
+			t.Fatalf("%s: %v", tc.grid, err)
+		}
+		size, err := g.Size()
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got %d points", tc.grid, size)
+			}
+			continue
+		}
+		if err != nil || size != tc.size {
+			t.Errorf("%s: expected %d points, got %d (%v)", tc.grid, tc.size, size, err)
+			continue
+		}
+		points, err := g.Points(0, size)
+		if err != nil || !reflect.DeepEqual(points, tc.points) {
+			t.Errorf("%s: expected %v, got %v (%v)", tc.grid, tc.points, points, err)
+		}
+	}
+}
+
+func TestGridPointsOutOfRange(t *testing.T) {
+	g := Grid{{Name: "x", Values: []json.Number{"1", "2", "3"}}}
+	points, err := g.Points(1, 2)
+	if err != nil || !reflect.DeepEqual(points, [][]string{{"2"}, {"3"}}) {
+		t.Fatalf("expected [[2] [3]], got %v (%v)", points, err)
+	}
+	if _, err := g.Points(2, 2); err == nil {
+		t.Fatal("expected error for points past the end of the grid")
+	}
+}
@@ -0,0 +1,202 @@
+package calc
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// IntegerCalculator evaluates expressions in 64-bit signed integer
+// arithmetic. Besides + - * it supports integer division //, modulo % and
+// the bitwise operators & | ^ ~ << >>. Results that do not fit in an int64
+// are errors rather than wrapping around.
+type IntegerCalculator struct {
+	// Scope, if not nil, holds user-defined functions expressions can call.
+	Scope *Scope
+}
+
+func NewIntegerCalculator() *IntegerCalculator {
+	return &IntegerCalculator{}
+}
+
+var errIntegerOverflow = errors.New("integer overflow")
+
+// Evaluate returns the integer value of expression.
+func (c *IntegerCalculator) Evaluate(expression string) (int64, error) {
+	return evaluateString[int64](expression, integerDomain{}, c.Scope)
+}
+
+// Exact implements ExactCalculator.
+func (c *IntegerCalculator) Exact(expression string) (*Value, error) {
+	result, err := c.Evaluate(expression)
+	if err != nil {
+		return nil, err
+	}
+	return &Value{Type: ModeInteger, Integer: strconv.FormatInt(result, 10)}, nil
+}
+
+// Calculate implements Calculator with the result converted to float64,
+// which is inexact beyond 2^53.
+func (c *IntegerCalculator) Calculate(expression string) (float64, error) {
+	result, err := c.Evaluate(expression)
+	return float64(result), err
+}
+
+// integerDomain evaluates in int64, reporting overflow.
+type integerDomain struct{}
+
+func (integerDomain) xorCaret() {}
+
+var integerFunctions = map[string]function[int64]{
+	"abs": {1, func(args []int64) (int64, error) {
+		if args[0] == math.MinInt64 {
+			return 0, errIntegerOverflow
+		}
+		if args[0] < 0 {
+			return -args[0], nil
+		}
+		return args[0], nil
+	}},
+}
+
+func (integerDomain) number(literal string) (int64, error) {
+	if isImaginary(literal) {
+		return 0, fmt.Errorf("imaginary literal %s requires complex mode", literal)
+	}
+	r, err := parseRat(literal)
+	if err != nil {
+		return 0, err
+	}
+	if !r.IsInt() {
+		return 0, fmt.Errorf("integer mode requires whole numbers: %s", literal)
+	}
+	if !r.Num().IsInt64() {
+		return 0, fmt.Errorf("number out of range: %s", literal)
+	}
+	return r.Num().Int64(), nil
+}
+
+func (integerDomain) ident(name string) (int64, error) {
+	return unknownIdent[int64](name)
+}
+
+func (integerDomain) unary(op string, x int64) (int64, error) {
+	switch op {
+	case "+":
+		return x, nil
+	case "-":
+		if x == math.MinInt64 {
+			return 0, errIntegerOverflow
+		}
+		return -x, nil
+	case "~":
+		return ^x, nil
+	default:
+		return undefinedOperator[int64](op)
+	}
+}
+
+func (integerDomain) binary(op string, a, b int64) (int64, error) {
+	switch op {
+	case "+":
+		return checkedInt(new(big.Int).Add(big.NewInt(a), big.NewInt(b)))
+	case "-":
+		return checkedInt(new(big.Int).Sub(big.NewInt(a), big.NewInt(b)))
+	case "*":
+		return checkedInt(new(big.Int).Mul(big.NewInt(a), big.NewInt(b)))
+	case "/":
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		if a%b != 0 {
+			return 0, fmt.Errorf("%d / %d is not a whole number; use // for integer division", a, b)
+		}
+		return checkedInt(new(big.Int).Quo(big.NewInt(a), big.NewInt(b)))
+	case "//", "%":
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		// Floor division and a modulo with the sign of the divisor, so
+		// that a == (a // b) * b + a % b holds for negative operands too.
+		q, m := a/b, a%b
+		if m != 0 && (m < 0) != (b < 0) {
+			q, m = q-1, m+b
+		}
+		if op == "%" {
+			return m, nil
+		}
+		if a == math.MinInt64 && b == -1 {
+			return 0, errIntegerOverflow
+		}
+		return q, nil
+	case "**":
+		if b < 0 {
+			return 0, errors.New("negative exponent in integer mode")
+		}
+		return power(a, b, 1, func(x, y int64) (int64, error) {
+			return checkedInt(new(big.Int).Mul(big.NewInt(x), big.NewInt(y)))
+		})
+	case "&":
+		return a & b, nil
+	case "|":
+		return a | b, nil
+	case "^":
+		return a ^ b, nil
+	case "<<":
+		if b < 0 {
+			return 0, errors.New("negative shift count")
+		}
+		if b >= 64 {
+			if a == 0 {
+				return 0, nil
+			}
+			return 0, errIntegerOverflow
+		}
+		if a<<b>>b != a {
+			return 0, errIntegerOverflow
+		}
+		return a << b, nil
+	case ">>":
+		if b < 0 {
+			return 0, errors.New("negative shift count")
+		}
+		return a >> min(b, 63), nil
+	default:
+		return undefinedOperator[int64](op)
+	}
+}
+
+// checkedInt returns x as an int64, or an overflow error if it does not
+// fit.
+func checkedInt(x *big.Int) (int64, error) {
+	if !x.IsInt64() {
+		return 0, errIntegerOverflow
+	}
+	return x.Int64(), nil
+}
+
+func (integerDomain) call(name string, args []int64) (int64, error) {
+	return callFunction(integerFunctions, name, args)
+}
+
+func (integerDomain) compare(a, b int64) (int, error) {
+	return cmp.Compare(a, b), nil
+}
+
+func (integerDomain) equal(a, b int64) bool {
+	return a == b
+}
+
+func (integerDomain) truth(x int64) bool {
+	return x != 0
+}
+
+func (integerDomain) fromBool(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+package calc
+
+import (
+	"testing"
+)
+
+func TestIntegerCalculator(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   int64
+		expectErr  bool
+	}{
+		{"7 // 2", 3, false},
+		{"-7 // 2", -4, false},
+		{"7 % 3", 1, false},
+		{"-7 % 3", 2, false},
+		{"7 % -3", -2, false},
+		{"12 / 4", 3, false},
+		{"0xF0 | 0x0F", 0xFF, false},
+		{"0xFF & ~0x0F", 0xF0, false},
+		{"0b1100 ^ 0b1010", 0b0110, false},
+		{"1 << 4 | 1 << 2", 20, false},
+		{"0x8000 >> 15", 1, false},
+		{"-1 >> 100", -1, false},
+		{"0xDEAD & 0xFF == 0xAD", 1, false},
+		{"2 + 3 * 4 % 5", 4, false},
+		{"1 << 62", 1 << 62, false},
+		{"-1 << 63", -1 << 63, false},
+		{"9223372036854775807 + 1", 0, true},
+		{"-9223372036854775807 - 2", 0, true},
+		{"4294967296 * 4294967296", 0, true},
+		{"1 << 63", 0, true},
+		{"1 << 64", 0, true},
+		{"1 << -1", 0, true},
+		{"7 / 2", 0, true},
+		{"1 // 0", 0, true},
+		{"1 % 0", 0, true},
+		{"1.5 + 1", 0, true},
+		{"99999999999999999999", 0, true},
+	}
+
+	calculator := NewIntegerCalculator()
+	for _, tc := range testCases {
+		result, err := calculator.Evaluate(tc.expression)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("expected error for expression %q, got none", tc.expression)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("did not expect error for expression %q, got %v", tc.expression, err)
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("expected %d for expression %q, got %d", tc.expected, tc.expression, result)
+		}
+	}
+}
+
+func TestIntegerOperatorsOutsideIntegerMode(t *testing.T) {
+	for _, expression := range []string{"7 // 2", "7 % 2", "1 & 1", "~1", "1 << 2"} {
+		if _, err := NewBasicCalculator().Calculate(expression); err == nil {
+			t.Errorf("expected error for expression %q in float mode", expression)
+		}
+	}
+}
+
+func TestEvaluateIntegerMode(t *testing.T) {
+	result, value, err := Evaluate("0x7FFFFFFFFFFFFFFF & ~0xFF", Options{Mode: ModeInteger})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value == nil || value.Type != ModeInteger || value.Integer != "9223372036854775552" || result != 9223372036854775552 {
+		t.Fatalf("unexpected integer result %v, %+v", result, value)
+	}
+}
@@ -0,0 +1,448 @@
+package calc
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenIdent
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+	tokenSemicolon
+	tokenLBracket
+	tokenRBracket
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// operators lists every operator symbol, longest first, so the lexer
+// always takes the longest match.
+var operators = sortedOperators(
+	"+", "-", "*", "/",
+	"<", "<=", ">", ">=", "==", "!=",
+	"&&", "||", "!", "?", ":",
+	"//", "%", "&", "|", "^", "~", "<<", ">>",
+	"**", "=",
+	".*", "./", ".^",
+)
+
+// lenientOperators are the Unicode operators of formulas copied from
+// documents, by the tokens lenient scanning reads them as: × and the dots
+// multiply, ÷ divides, − subtracts, √ takes the square root of its
+// operand and ² and ³ raise to a power.
+var lenientOperators = map[rune][]string{
+	'×': {"*"}, '·': {"*"}, '⋅': {"*"},
+	'÷': {"/"},
+	'−': {"-"},
+	'√': {"√"},
+	'²': {"**", "2"}, '³': {"**", "3"},
+}
+
+func sortedOperators(ops ...string) []string {
+	sort.SliceStable(ops, func(i, j int) bool { return len(ops[i]) > len(ops[j]) })
+	return ops
+}
+
+func isIdentStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentPart(r rune) bool {
+	return isIdentStart(r) || unicode.IsDigit(r)
+}
+
+// tokenize splits expression into tokens as read with syntax s. Positions
+// are byte offsets into expression. In a locale with the decimal comma, a
+// ; inside parentheses or brackets separates arguments or elements, so it
+// is read as a comma, and a comma outside a number is an error.
+func tokenize(expression string, s syntax) ([]token, error) {
+	var tokens []token
+	numbers := s.locale.numbers()
+	depth := 0
+	for i := 0; i < len(expression); {
+		r, size := utf8.DecodeRuneInString(expression[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case s.time && r >= '0' && r <= '9' && scanTimeLiteral(expression, i) > i:
+			end := scanTimeLiteral(expression, i)
+			tokens = append(tokens, token{kind: tokenNumber, text: expression[i:end], pos: i})
+			i = end
+		case s.arrays && r == '.' && matchOperator(expression[i:]) != "":
+			op := matchOperator(expression[i:])
+			tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+			i += len(op)
+		case r >= '0' && r <= '9' || r == '.':
+			literal, end, err := scanNumber(expression, i, s.lenient, numbers)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: literal, pos: i})
+			i = end
+		case isIdentStart(r):
+			end := i + size
+			for end < len(expression) {
+				next, nextSize := utf8.DecodeRuneInString(expression[end:])
+				if !isIdentPart(next) {
+					break
+				}
+				end += nextSize
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: expression[i:end], pos: i})
+			i = end
+		case r == '$' || r == '@' || r == '#':
+			end := scanReference(expression, i)
+			if end < 0 {
+				return nil, fmt.Errorf("malformed reference at position %d", i)
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: expression[i:end], pos: i})
+			i = end
+		case r == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i})
+			depth++
+			i += size
+		case r == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i})
+			depth--
+			i += size
+		case r == '[':
+			tokens = append(tokens, token{kind: tokenLBracket, text: "[", pos: i})
+			depth++
+			i += size
+		case r == ']':
+			tokens = append(tokens, token{kind: tokenRBracket, text: "]", pos: i})
+			depth--
+			i += size
+		case r == ',':
+			if numbers.decimal == ',' {
+				return nil, fmt.Errorf("unexpected \",\" at position %d: arguments are separated by ; in locale %s", i, s.locale)
+			}
+			tokens = append(tokens, token{kind: tokenComma, text: ",", pos: i})
+			i += size
+		case r == ';' && depth > 0 && numbers.decimal == ',':
+			tokens = append(tokens, token{kind: tokenComma, text: ";", pos: i})
+			i += size
+		case r == ';':
+			tokens = append(tokens, token{kind: tokenSemicolon, text: ";", pos: i})
+			i += size
+		case s.lenient && lenientOperators[r] != nil:
+			for _, text := range lenientOperators[r] {
+				kind := tokenOperator
+				if text[0] >= '0' && text[0] <= '9' {
+					kind = tokenNumber
+				}
+				tokens = append(tokens, token{kind: kind, text: text, pos: i})
+			}
+			i += size
+		default:
+			op := matchOperator(expression[i:])
+			if op == "" && lenientOperators[r] != nil {
+				return nil, fmt.Errorf("undefined token: %c; it is only accepted with lenient parsing", r)
+			}
+			if op == "" {
+				return nil, fmt.Errorf("undefined token: %c", r)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(expression)}), nil
+}
+
+// scanReference scans a reference to another expression's result, $ and
+// a task ID or @ and a label, or to a dataset, # and its name, starting at
+// start. It returns the end of the reference, or -1 if it is malformed.
+func scanReference(s string, start int) int {
+	end := start + 1
+	if s[start] == '$' {
+		for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+			end++
+		}
+		if end == start+1 {
+			return -1
+		}
+	} else {
+		for end < len(s) {
+			r, size := utf8.DecodeRuneInString(s[end:])
+			if !isIdentPart(r) {
+				break
+			}
+			end += size
+		}
+		if !IsLabel(s[start+1 : end]) {
+			return -1
+		}
+	}
+	if end < len(s) {
+		if r, _ := utf8.DecodeRuneInString(s[end:]); isIdentPart(r) {
+			return -1
+		}
+	}
+	return end
+}
+
+// IsLabel reports whether label can be referenced as @label, i.e. is an
+// identifier.
+func IsLabel(label string) bool {
+	for i, r := range label {
+		if !isIdentPart(r) || i == 0 && !isIdentStart(r) {
+			return false
+		}
+	}
+	return label != ""
+}
+
+// isReference reports whether name is a $, @ or # reference.
+func isReference(name string) bool {
+	return strings.HasPrefix(name, "$") || strings.HasPrefix(name, "@") || isDataset(name)
+}
+
+// isDataset reports whether name is a #name reference to a dataset.
+func isDataset(name string) bool {
+	return strings.HasPrefix(name, "#")
+}
+
+// References returns the $ and @ references in expression, each once, in
+// order of appearance. Expression may use the literals of any mode and be
+// written leniently.
+func References(expression string) ([]string, error) {
+	return references(expression, func(name string) bool { return !isDataset(name) })
+}
+
+// Datasets returns the names of the datasets expression references as
+// #name, each once, in order of appearance.
+func Datasets(expression string) ([]string, error) {
+	refs, err := references(expression, isDataset)
+	for i, ref := range refs {
+		refs[i] = strings.TrimPrefix(ref, "#")
+	}
+	return refs, err
+}
+
+// references returns the references in expression that match.
+func references(expression string, match func(name string) bool) ([]string, error) {
+	tokens, err := tokenize(expression, syntax{time: true, arrays: true, lenient: true})
+	if err != nil {
+		return nil, err
+	}
+	var refs []string
+	seen := make(map[string]bool)
+	for _, tok := range tokens {
+		if tok.kind == tokenIdent && isReference(tok.text) && match(tok.text) && !seen[tok.text] {
+			seen[tok.text] = true
+			refs = append(refs, tok.text)
+		}
+	}
+	return refs, nil
+}
+
+// maxExponent bounds the exponent of scientific literals, so that exact
+// number systems are not asked to build numbers with millions of digits.
+const maxExponent = 100000
+
+// scanNumber scans the numeric literal starting at start and returns it in
+// canonical form together with its end. The canonical form is a plain
+// decimal literal without digit separators, optionally with an exponent
+// ("1e-9"); hexadecimal (0x1F), octal (0o17) and binary (0b1010) integers
+// are converted to decimal. A literal directly followed by a lone "i" is
+// an imaginary literal and keeps the suffix. Unless lenient, a literal
+// must not run into a name, so 2x is malformed; lenient scanning ends the
+// literal there instead, and at an e that starts no exponent, as in 2e.
+// Decimal literals are read with the decimal separator of format f and
+// its group separators, which must separate groups of three digits, as in
+// 1.234.567,5 in LocaleGerman.
+func scanNumber(s string, start int, lenient bool, f numberFormat) (string, int, error) {
+	malformed := func(end int) error {
+		for end < len(s) {
+			r, size := utf8.DecodeRuneInString(s[end:])
+			if !isIdentPart(r) && r != '.' {
+				break
+			}
+			end += size
+		}
+		return fmt.Errorf("malformed number %q at position %d", s[start:end], start)
+	}
+
+	var literal string
+	i := start
+	if base := basePrefix(s[i:]); base != 0 {
+		digits, end, ok := scanDigits(s, i+2, base, true)
+		if !ok || digits == "" {
+			return "", 0, malformed(end)
+		}
+		n, _ := new(big.Int).SetString(digits, base)
+		literal, i = n.String(), end
+	} else {
+		intDigits, end, ok := scanDigits(s, i, 10, false)
+		if !ok {
+			return "", 0, malformed(end)
+		}
+		i = end
+		for grouped := false; ; grouped = true {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if i == len(s) || !f.readsGroup(r) || !isDigit(s, i+size) {
+				break
+			}
+			// Only the first group may have fewer than three digits.
+			if !grouped && len(intDigits) > 3 {
+				return "", 0, malformed(i + size)
+			}
+			group, end, _ := scanDigits(s, i+size, 10, false)
+			if len(group) != 3 || end != i+size+3 {
+				return "", 0, malformed(end)
+			}
+			intDigits += group
+			i = end
+		}
+		var fracDigits string
+		hasPoint := strings.HasPrefix(s[i:], string(f.decimal)) && (f.decimal == '.' || isDigit(s, i+1))
+		if hasPoint {
+			fracDigits, end, ok = scanDigits(s, i+1, 10, false)
+			if !ok {
+				return "", 0, malformed(end)
+			}
+			i = end
+		}
+		if intDigits == "" && fracDigits == "" {
+			return "", 0, malformed(i)
+		}
+		if intDigits == "" {
+			intDigits = "0"
+		}
+		literal = intDigits
+		if fracDigits != "" {
+			literal += "." + fracDigits
+		}
+		if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+			j := i + 1
+			sign := ""
+			if j < len(s) && (s[j] == '+' || s[j] == '-') {
+				sign = s[j : j+1]
+				j++
+			}
+			expDigits, end, ok := scanDigits(s, j, 10, false)
+			switch {
+			case (!ok || expDigits == "") && lenient:
+			case !ok || expDigits == "":
+				return "", 0, malformed(end)
+			default:
+				if exp, err := strconv.Atoi(expDigits); err != nil || exp > maxExponent {
+					return "", 0, fmt.Errorf("exponent out of range in %q at position %d", s[start:end], start)
+				}
+				literal += "e" + sign + expDigits
+				i = end
+			}
+		}
+	}
+
+	if i < len(s) && s[i] == 'i' {
+		next, _ := utf8.DecodeRuneInString(s[i+1:])
+		if i+1 == len(s) || !isIdentPart(next) {
+			literal += "i"
+			i++
+		}
+	}
+	if i < len(s) {
+		if r, _ := utf8.DecodeRuneInString(s[i:]); r == '.' && (f.decimal == '.' || isDigit(s, i+1)) || r == f.decimal && isDigit(s, i+1) || isIdentPart(r) && !lenient {
+			return "", 0, malformed(i)
+		}
+	}
+	return literal, i, nil
+}
+
+// basePrefix returns the base selected by a 0x, 0o or 0b prefix of s, or 0
+// if s has no such prefix.
+func basePrefix(s string) int {
+	if len(s) < 2 || s[0] != '0' {
+		return 0
+	}
+	switch s[1] {
+	case 'x', 'X':
+		return 16
+	case 'o', 'O':
+		return 8
+	case 'b', 'B':
+		return 2
+	}
+	return 0
+}
+
+// scanDigits scans digits of base starting at i, skipping single
+// underscores between digits. leadingUnderscore allows an underscore
+// before the first digit, as after a base prefix. ok is false for
+// misplaced underscores.
+func scanDigits(s string, i, base int, leadingUnderscore bool) (digits string, end int, ok bool) {
+	var b strings.Builder
+	afterUnderscore := false
+	for i < len(s) {
+		c := s[i]
+		if c == '_' {
+			if afterUnderscore || (b.Len() == 0 && !leadingUnderscore) {
+				return "", i, false
+			}
+			afterUnderscore = true
+			i++
+			continue
+		}
+		if digitValue(c) >= base {
+			break
+		}
+		b.WriteByte(c)
+		afterUnderscore = false
+		i++
+	}
+	if afterUnderscore {
+		return "", i, false
+	}
+	return b.String(), i, true
+}
+
+// isDigit reports whether s has a decimal digit at i.
+func isDigit(s string, i int) bool {
+	return i < len(s) && s[i] >= '0' && s[i] <= '9'
+}
+
+func digitValue(c byte) int {
+	switch {
+	case c >= '0' && c <= '9':
+		return int(c - '0')
+	case c >= 'a' && c <= 'f':
+		return int(c-'a') + 10
+	case c >= 'A' && c <= 'F':
+		return int(c-'A') + 10
+	default:
+		return 99
+	}
+}
+
+func matchOperator(s string) string {
+	for _, op := range operators {
+		if len(s) >= len(op) && s[:len(op)] == op {
+			return op
+		}
+	}
+	return ""
+}
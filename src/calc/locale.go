@@ -0,0 +1,159 @@
+package calc
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// numberFormat is how numbers are written in a locale. Group separates
+// groups of three digits of the integer part. Numbers are only read with
+// group separators other than the comma, which separates arguments in
+// locales whose decimal separator is the point; where the decimal
+// separator is the comma, arguments are separated by ; instead.
+type numberFormat struct {
+	decimal rune
+	group   rune
+}
+
+var numberFormats = map[Locale]numberFormat{
+	LocaleEnglish: {decimal: '.', group: ','},
+	LocaleGerman:  {decimal: ',', group: '.'},
+	LocaleFrench:  {decimal: ',', group: '\u202f'},
+}
+
+// numbers returns the number format of l, which is that of canonical
+// literals, with a decimal point and no group separator, for the zero
+// Locale.
+func (l Locale) numbers() numberFormat {
+	if f, ok := numberFormats[l]; ok {
+		return f
+	}
+	return numberFormat{decimal: '.'}
+}
+
+// readsGroup reports whether r separates digit groups in numbers read in
+// format f. Where groups are separated by a narrow no-break space, the
+// no-break space, which looks alike, separates them too. Spaces never do,
+// since they separate operands.
+func (f numberFormat) readsGroup(r rune) bool {
+	switch {
+	case f.group == ',' || f.group == 0:
+		return false
+	case f.group == '\u202f':
+		return r == '\u202f' || r == '\u00a0'
+	}
+	return r == f.group
+}
+
+// argumentSeparator returns the separator of arguments and array
+// elements in format f.
+func (f numberFormat) argumentSeparator() string {
+	if f.decimal == ',' {
+		return ";"
+	}
+	return ","
+}
+
+// Format writes the result of an evaluation, its exact value if there is
+// one and otherwise result, with the separators of l, as in 1.234,5 for
+// LocaleGerman. Arguments and elements of arrays are separated by ; if l
+// uses the decimal comma. Dates, times and durations are not localized.
+func (l Locale) Format(result float64, value *Value) string {
+	if value == nil {
+		return l.localize(formatPlain(result))
+	}
+	switch value.Type {
+	case TypeInstant, TypeDuration:
+		return value.Literal()
+	}
+	return l.localize(value.Literal())
+}
+
+// written rewrites expression, a canonical expression as format prints
+// it, to be read in l: with its decimal separator and, if that is the
+// comma, with arguments separated by ;. Digits are not grouped, since
+// LocaleEnglish does not read group separators.
+func (l Locale) written(expression string) string {
+	return l.rewrite(expression, 0)
+}
+
+// formatPlain formats f without an exponent unless f is very large or
+// very small, so its digits can be grouped.
+func formatPlain(f float64) string {
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-4 || abs >= 1e21) {
+		return FormatFloat(f)
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// localize rewrites the numbers of literal, a canonical literal as
+// Value.Literal returns, with the separators of l.
+func (l Locale) localize(literal string) string {
+	return l.rewrite(literal, l.numbers().group)
+}
+
+// rewrite rewrites the numbers of literal with the decimal separator of l
+// and digits grouped by group, unless it is 0, and separates arguments as
+// l does. Digits that are part of a name or an exponent are kept as they
+// are.
+func (l Locale) rewrite(literal string, group rune) string {
+	f := l.numbers()
+	separator := f.argumentSeparator() + " "
+	var b strings.Builder
+	for i := 0; i < len(literal); {
+		c := literal[i]
+		switch {
+		case c >= '0' && c <= '9' && (i == 0 || !isNumberPart(literal[i-1])):
+			end := i
+			for end < len(literal) && literal[end] >= '0' && literal[end] <= '9' {
+				end++
+			}
+			groupDigits(&b, literal[i:end], group)
+			if end+1 < len(literal) && literal[end] == '.' && literal[end+1] >= '0' && literal[end+1] <= '9' {
+				b.WriteRune(f.decimal)
+				end++
+				for end < len(literal) && literal[end] >= '0' && literal[end] <= '9' {
+					b.WriteByte(literal[end])
+					end++
+				}
+			}
+			if end < len(literal) && (literal[end] == 'e' || literal[end] == 'E') {
+				// The exponent is copied as it is.
+				b.WriteByte(literal[end])
+				end++
+				for end < len(literal) && (literal[end] == '+' || literal[end] == '-' || literal[end] >= '0' && literal[end] <= '9') {
+					b.WriteByte(literal[end])
+					end++
+				}
+			}
+			i = end
+		case strings.HasPrefix(literal[i:], ", "):
+			b.WriteString(separator)
+			i += 2
+		default:
+			_, size := utf8.DecodeRuneInString(literal[i:])
+			b.WriteString(literal[i : i+size])
+			i += size
+		}
+	}
+	return b.String()
+}
+
+// isNumberPart reports whether a digit after c continues a name or a
+// number rather than starting a number.
+func isNumberPart(c byte) bool {
+	return c == '_' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= utf8.RuneSelf
+}
+
+// groupDigits writes digits to b with separator between groups of three,
+// counted from the right.
+func groupDigits(b *strings.Builder, digits string, separator rune) {
+	for i, d := range digits {
+		if i > 0 && separator != 0 && (len(digits)-i)%3 == 0 {
+			b.WriteRune(separator)
+		}
+		b.WriteRune(d)
+	}
+}
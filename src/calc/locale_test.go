@@ -0,0 +1,95 @@
+package calc
+
+import "testing"
+
+func TestLocaleNumbers(t *testing.T) {
+	testCases := []struct {
+		expression string
+		locale     Locale
+		mode       Mode
+		expected   string
+		expectErr  bool
+	}{
+		{"3,5 + 1,25", LocaleGerman, "", "4.75", false},
+		{"1.234,56 * 2", LocaleGerman, ModeDecimal, "2469.12", false},
+		{"1.234.567", LocaleGerman, ModeInteger, "1234567", false},
+		{"percentile([1,5; 2; 0,5]; 50)", LocaleGerman, ModeDecimal, "1.5", false},
+		{"f(x; y) = x * 1,5 + y; f(2; 1)", LocaleGerman, "", "4", false},
+		{"integrate(x; x; 0; 1,5)", LocaleGerman, "", "1.125", false},
+		{"[1,5; 2] .* [2; 2]", LocaleGerman, ModeMatrix, "[3, 4]", false},
+		{"5 km + 1,5 km", LocaleGerman, ModeUnits, "6.5 km", false},
+		{"1 234,5 + 1", LocaleFrench, "", "1235.5", false},
+		{"1 234,5", LocaleFrench, "", "1234.5", false},
+		{"sum([1, 2.5])", LocaleEnglish, "", "3.5", false},
+		{"1.5", LocaleGerman, "", "", true},
+		{"12345.678", LocaleGerman, "", "", true},
+		{"1.2345", LocaleGerman, "", "", true},
+		{"sum([1, 2])", LocaleGerman, "", "", true},
+		{"1 234,5", LocaleFrench, "", "", true},
+		{"1,234", LocaleEnglish, "", "", true},
+		{"3,5", "", "", "", true},
+	}
+
+	for _, tc := range testCases {
+		result, value, err := Evaluate(tc.expression, Options{Mode: tc.mode, Locale: tc.locale})
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got %v", tc.expression, result)
+			}
+			continue
+		}
+		got := FormatFloat(result)
+		if value != nil {
+			got = value.Literal()
+		}
+		if err != nil || got != tc.expected {
+			t.Errorf("%s: expected %s, got %s (%v)", tc.expression, tc.expected, got, err)
+		}
+	}
+
+	// Inputs are canonical literals in every locale.
+	opts := Options{Locale: LocaleGerman, Inputs: map[string]string{"$1": "1.5"}}
+	if result, _, err := Evaluate("$1 * 2", opts); err != nil || result != 3 {
+		t.Errorf("expected a canonical input, got %v (%v)", result, err)
+	}
+	if _, err := (Definition{Definition: "f(x; y) = x * 1,5 + y", Locale: LocaleGerman}).Parse(); err != nil {
+		t.Errorf("expected a definition with the decimal comma to be accepted, got %v", err)
+	}
+	for _, opts := range []Options{{Locale: "xx"}, {Locale: LocaleGerman, Operation: OperationSimplify}} {
+		if err := opts.Validate(); err == nil {
+			t.Errorf("expected %+v to be rejected", opts)
+		}
+	}
+}
+
+func TestLocaleFormat(t *testing.T) {
+	testCases := []struct {
+		expression string
+		mode       Mode
+		locale     Locale
+		expected   string
+	}{
+		{"1234567.5", "", LocaleGerman, "1.234.567,5"},
+		{"1234567.5", "", LocaleEnglish, "1,234,567.5"},
+		{"1234567.5", "", LocaleFrench, "1 234 567,5"},
+		{"1e25", "", LocaleGerman, "1e+25"},
+		{"-0.25", ModeDecimal, LocaleGerman, "-0,25"},
+		{"1/3 + 1000", ModeRational, LocaleGerman, "3.001/3"},
+		{"2.5 - 1000i", ModeComplex, LocaleGerman, "2,5-1.000i"},
+		{"[1.5, 2] * 1000", ModeMatrix, LocaleGerman, "[1.500; 2.000]"},
+		{"[1.5, 2]", ModeMatrix, LocaleEnglish, "[1.5, 2]"},
+		{"1500 m", ModeUnits, LocaleGerman, "1.500 m"},
+		{"2026-10-18 + 1d", ModeTime, LocaleGerman, "2026-10-19T00:00:00Z"},
+		{"1234.5", "", "", "1234.5"},
+	}
+
+	for _, tc := range testCases {
+		result, value, err := Evaluate(tc.expression, Options{Mode: tc.mode})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.expression, err)
+		}
+		if got := tc.locale.Format(result, value); got != tc.expected {
+			t.Errorf("%s: expected %q in %q, got %q", tc.expression, tc.expected, tc.locale, got)
+		}
+	}
+}
@@ -0,0 +1,561 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// MatrixCalculator evaluates expressions over numbers, vectors and
+// matrices of float64. [1, 2, 3] is a vector and [[1, 2], [3, 4]] a
+// matrix of two rows. + and - and the element-wise .*, ./ and .^ apply to
+// arrays of the same shape or to an array and a number, * is the matrix
+// product, in which vectors are columns, and A^k raises a square matrix to
+// an integer power. det, inv, transpose, dot, cross and solve(A, b) are
+// available, and the float functions apply to every element.
+type MatrixCalculator struct {
+	// Scope, if not nil, holds user-defined functions expressions can call.
+	Scope *Scope
+}
+
+func NewMatrixCalculator() *MatrixCalculator {
+	return &MatrixCalculator{}
+}
+
+// TypeVector is the Type of a Value holding a vector. Matrices have type
+// ModeMatrix.
+const TypeVector Mode = "vector"
+
+// Exact implements ExactCalculator. Vectors are returned as values of type
+// TypeVector, matrices of type ModeMatrix and numbers of type ModeFloat.
+func (c *MatrixCalculator) Exact(expression string) (*Value, error) {
+	result, err := evaluateString[array](expression, matrixDomain{}, c.Scope)
+	if err != nil {
+		return nil, err
+	}
+	return result.value()
+}
+
+// Calculate implements Calculator. It fails if the result is a vector or
+// a matrix.
+func (c *MatrixCalculator) Calculate(expression string) (float64, error) {
+	value, err := c.Exact(expression)
+	if err != nil {
+		return 0, err
+	}
+	if value.Type != ModeFloat {
+		return 0, fmt.Errorf("result is a %s, not a number", value.Type)
+	}
+	return value.Float64(), nil
+}
+
+// array is a number, a vector or a matrix. Its shape is empty for a
+// number, the length of a vector and the rows and columns of a matrix,
+// whose elements are stored by rows.
+type array struct {
+	shape []int
+	data  []float64
+}
+
+func scalar(x float64) array {
+	return array{data: []float64{x}}
+}
+
+func (a array) isScalar() bool {
+	return len(a.shape) == 0
+}
+
+func (a array) isMatrix() bool {
+	return len(a.shape) == 2
+}
+
+// rows and cols give the size of a as a matrix, in which a vector is a
+// column and a number a single element.
+func (a array) rows() int {
+	if a.isScalar() {
+		return 1
+	}
+	return a.shape[0]
+}
+
+func (a array) cols() int {
+	if a.isMatrix() {
+		return a.shape[1]
+	}
+	return 1
+}
+
+// String describes the shape of a, as in "vector of 3" or "2x3 matrix".
+func (a array) String() string {
+	switch len(a.shape) {
+	case 0:
+		return "number"
+	case 1:
+		return fmt.Sprintf("vector of %d", a.shape[0])
+	default:
+		return fmt.Sprintf("%dx%d matrix", a.shape[0], a.shape[1])
+	}
+}
+
+func (a array) value() (*Value, error) {
+	for _, x := range a.data {
+		if math.IsInf(x, 0) || math.IsNaN(x) {
+			return nil, errors.New("result is not a finite number")
+		}
+	}
+	switch len(a.shape) {
+	case 0:
+		return &Value{Type: ModeFloat, Decimal: FormatFloat(a.data[0])}, nil
+	case 1:
+		return &Value{Type: TypeVector, Vector: a.data}, nil
+	default:
+		rows := make([][]float64, a.rows())
+		for i := range rows {
+			rows[i] = a.data[i*a.cols() : (i+1)*a.cols()]
+		}
+		return &Value{Type: ModeMatrix, Matrix: rows}, nil
+	}
+}
+
+// formatArray writes elements as an array literal.
+func formatArray(elements []float64) string {
+	literals := make([]string, len(elements))
+	for i, x := range elements {
+		literals[i] = FormatFloat(x)
+	}
+	return "[" + strings.Join(literals, ", ") + "]"
+}
+
+// apply returns the array of f of every element of a.
+func (a array) apply(f func(x float64) (float64, error)) (array, error) {
+	result := array{shape: a.shape, data: make([]float64, len(a.data))}
+	for i, x := range a.data {
+		y, err := f(x)
+		if err != nil {
+			return array{}, err
+		}
+		result.data[i] = y
+	}
+	return result, nil
+}
+
+// elementOperators maps the operators applied element by element to the
+// operators of floatDomain they apply.
+var elementOperators = map[string]string{
+	"+": "+", "-": "-", ".*": "*", "./": "/", ".^": "**",
+}
+
+// elementwise applies op to the elements of a and b in the same positions,
+// or to a number and every element of an array.
+func elementwise(op string, a, b array) (array, error) {
+	f := func(x, y float64) (float64, error) {
+		return floatDomain{}.binary(elementOperators[op], x, y)
+	}
+	switch {
+	case a.isScalar():
+		return b.apply(func(y float64) (float64, error) { return f(a.data[0], y) })
+	case b.isScalar():
+		return a.apply(func(x float64) (float64, error) { return f(x, b.data[0]) })
+	case !slices.Equal(a.shape, b.shape):
+		return array{}, fmt.Errorf("mismatched shapes: %s %s %s", a, op, b)
+	}
+	result := array{shape: a.shape, data: make([]float64, len(a.data))}
+	for i := range a.data {
+		z, err := f(a.data[i], b.data[i])
+		if err != nil {
+			return array{}, err
+		}
+		result.data[i] = z
+	}
+	return result, nil
+}
+
+// matrixProduct returns the matrix product of a and b, in which vectors
+// are columns. The product of a matrix and a vector is a vector.
+func matrixProduct(a, b array) (array, error) {
+	m, n, p := a.rows(), a.cols(), b.cols()
+	if n != b.rows() {
+		return array{}, fmt.Errorf("mismatched shapes: %s * %s", a, b)
+	}
+	result := array{shape: []int{m, p}, data: make([]float64, m*p)}
+	if !b.isMatrix() {
+		result.shape = []int{m}
+	}
+	for i := 0; i < m; i++ {
+		for k := 0; k < n; k++ {
+			x := a.data[i*n+k]
+			for j := 0; j < p; j++ {
+				result.data[i*p+j] += x * b.data[k*p+j]
+			}
+		}
+	}
+	return result, nil
+}
+
+func identity(n int) array {
+	result := array{shape: []int{n, n}, data: make([]float64, n*n)}
+	for i := 0; i < n; i++ {
+		result.data[i*n+i] = 1
+	}
+	return result
+}
+
+// square returns a if it is a square matrix and an error naming the
+// function f otherwise.
+func square(f string, a array) (array, error) {
+	if !a.isMatrix() || a.rows() != a.cols() {
+		return array{}, fmt.Errorf("%s expects a square matrix, got a %s", f, a)
+	}
+	return a, nil
+}
+
+// matrixDomain evaluates in arrays of float64, parsing expressions with
+// the arrays syntax.
+type matrixDomain struct{}
+
+var errSingular = errors.New("matrix is singular")
+
+var matrixFunctions = map[string]function[array]{
+	"det": {1, func(args []array) (array, error) {
+		a, err := square("det", args[0])
+		if err != nil {
+			return array{}, err
+		}
+		return scalar(determinant(a)), nil
+	}},
+	"inv": {1, func(args []array) (array, error) {
+		a, err := square("inv", args[0])
+		if err != nil {
+			return array{}, err
+		}
+		return solveLinear(a, identity(a.rows()))
+	}},
+	"transpose": {1, func(args []array) (array, error) {
+		a := args[0]
+		if a.isScalar() {
+			return a, nil
+		}
+		result := array{shape: []int{a.cols(), a.rows()}, data: make([]float64, len(a.data))}
+		for i := 0; i < a.rows(); i++ {
+			for j := 0; j < a.cols(); j++ {
+				result.data[j*a.rows()+i] = a.data[i*a.cols()+j]
+			}
+		}
+		return result, nil
+	}},
+	"dot": {2, func(args []array) (array, error) {
+		a, b := args[0], args[1]
+		if len(a.shape) != 1 || !slices.Equal(a.shape, b.shape) {
+			return array{}, fmt.Errorf("dot expects two vectors of the same length, got a %s and a %s", a, b)
+		}
+		var sum float64
+		for i := range a.data {
+			sum += a.data[i] * b.data[i]
+		}
+		return scalar(sum), nil
+	}},
+	"cross": {2, func(args []array) (array, error) {
+		a, b := args[0], args[1]
+		if !slices.Equal(a.shape, []int{3}) || !slices.Equal(b.shape, []int{3}) {
+			return array{}, fmt.Errorf("cross expects two vectors of 3, got a %s and a %s", a, b)
+		}
+		x, y := a.data, b.data
+		return array{shape: []int{3}, data: []float64{
+			x[1]*y[2] - x[2]*y[1],
+			x[2]*y[0] - x[0]*y[2],
+			x[0]*y[1] - x[1]*y[0],
+		}}, nil
+	}},
+	"solve": {2, func(args []array) (array, error) {
+		a, err := square("solve", args[0])
+		if err != nil {
+			return array{}, err
+		}
+		if b := args[1]; b.isScalar() || b.rows() != a.rows() {
+			return array{}, fmt.Errorf("solve expects a right-hand side of %d rows, got a %s", a.rows(), b)
+		}
+		return solveLinear(a, args[1])
+	}},
+}
+
+// determinant computes the determinant of the square matrix a by Gaussian
+// elimination with partial pivoting.
+func determinant(a array) float64 {
+	n := a.rows()
+	m := slices.Clone(a.data)
+	det := 1.0
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(m[row*n+col]) > math.Abs(m[pivot*n+col]) {
+				pivot = row
+			}
+		}
+		if m[pivot*n+col] == 0 {
+			return 0
+		}
+		if pivot != col {
+			swapRows(m, n, pivot, col)
+			det = -det
+		}
+		det *= m[col*n+col]
+		for row := col + 1; row < n; row++ {
+			factor := m[row*n+col] / m[col*n+col]
+			for j := col; j < n; j++ {
+				m[row*n+j] -= factor * m[col*n+j]
+			}
+		}
+	}
+	return det
+}
+
+// solveLinear solves a x = b for x, which has the shape of b, by
+// Gauss-Jordan elimination with partial pivoting. A pivot that is zero
+// up to rounding relative to the largest element of a makes a singular.
+func solveLinear(a, b array) (array, error) {
+	n, p := a.rows(), b.cols()
+	m, x := slices.Clone(a.data), slices.Clone(b.data)
+	var scale float64
+	for _, v := range m {
+		scale = math.Max(scale, math.Abs(v))
+	}
+	tolerance := float64(n) * scale * 0x1p-52
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(m[row*n+col]) > math.Abs(m[pivot*n+col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(m[pivot*n+col]) <= tolerance {
+			return array{}, errSingular
+		}
+		swapRows(m, n, pivot, col)
+		swapRows(x, p, pivot, col)
+		for row := 0; row < n; row++ {
+			if row == col {
+				continue
+			}
+			factor := m[row*n+col] / m[col*n+col]
+			for j := col; j < n; j++ {
+				m[row*n+j] -= factor * m[col*n+j]
+			}
+			for j := 0; j < p; j++ {
+				x[row*p+j] -= factor * x[col*p+j]
+			}
+		}
+	}
+	for row := 0; row < n; row++ {
+		for j := 0; j < p; j++ {
+			x[row*p+j] /= m[row*n+row]
+		}
+	}
+	return array{shape: b.shape, data: x}, nil
+}
+
+// swapRows swaps rows i and j of the matrix with cols columns stored by
+// rows in data.
+func swapRows(data []float64, cols, i, j int) {
+	if i == j {
+		return
+	}
+	for k := 0; k < cols; k++ {
+		data[i*cols+k], data[j*cols+k] = data[j*cols+k], data[i*cols+k]
+	}
+}
+
+// vstack stacks its arguments vertically: numbers and vectors into a
+// vector, matrices with the same number of columns into a matrix.
+// Partition combines the row blocks of a matrix product with it.
+func vstack(args []array) (array, error) {
+	if len(args) == 0 {
+		return array{}, errors.New("vstack expects at least 1 argument, got 0")
+	}
+	first := args[0]
+	var result array
+	rows := 0
+	for _, arg := range args {
+		if arg.isMatrix() != first.isMatrix() || arg.cols() != first.cols() {
+			return array{}, fmt.Errorf("vstack expects arrays of the same width, got a %s and a %s", first, arg)
+		}
+		rows += arg.rows()
+		result.data = append(result.data, arg.data...)
+	}
+	result.shape = []int{rows}
+	if first.isMatrix() {
+		result.shape = []int{rows, first.cols()}
+	}
+	return result, nil
+}
+
+func (matrixDomain) array(elements []array) (array, error) {
+	if len(elements) == 0 {
+		return array{}, errors.New("empty array")
+	}
+	first := elements[0]
+	if first.isMatrix() {
+		return array{}, errors.New("arrays nest at most two levels deep")
+	}
+	result := array{shape: append([]int{len(elements)}, first.shape...)}
+	for _, element := range elements {
+		if !slices.Equal(element.shape, first.shape) {
+			return array{}, fmt.Errorf("elements of an array must have the same shape, got a %s and a %s", first, element)
+		}
+		result.data = append(result.data, element.data...)
+	}
+	return result, nil
+}
+
+// elements returns the elements of a vector, so vectors can be passed to
+// the statistics functions.
+func (matrixDomain) elements(x array) ([]array, bool) {
+	if len(x.shape) != 1 {
+		return nil, false
+	}
+	elements := make([]array, len(x.data))
+	for i, f := range x.data {
+		elements[i] = scalar(f)
+	}
+	return elements, true
+}
+
+func (matrixDomain) number(literal string) (array, error) {
+	f, err := parseFloat(literal)
+	return scalar(f), err
+}
+
+func (matrixDomain) ident(name string) (array, error) {
+	return unknownIdent[array](name)
+}
+
+func (matrixDomain) unary(op string, x array) (array, error) {
+	return x.apply(func(v float64) (float64, error) { return floatDomain{}.unary(op, v) })
+}
+
+func (matrixDomain) binary(op string, a, b array) (array, error) {
+	if a.isScalar() && b.isScalar() {
+		if f, ok := elementOperators[op]; ok {
+			op = f
+		}
+		x, err := floatDomain{}.binary(op, a.data[0], b.data[0])
+		return scalar(x), err
+	}
+	switch {
+	case elementOperators[op] != "":
+		return elementwise(op, a, b)
+	case op == "*" && (a.isScalar() || b.isScalar()):
+		return elementwise(".*", a, b)
+	case op == "*":
+		return matrixProduct(a, b)
+	case op == "/" && b.isScalar():
+		return elementwise("./", a, b)
+	case op == "**" && b.isScalar():
+		return matrixPower(a, b.data[0])
+	}
+	return array{}, fmt.Errorf("undefined operation: %s %s %s", a, op, b)
+}
+
+// matrixPower raises the square matrix a to the integer power k, inverting
+// it for negative k.
+func matrixPower(a array, k float64) (array, error) {
+	if _, err := square("**", a); err != nil {
+		return array{}, err
+	}
+	if k != math.Trunc(k) || math.Abs(k) >= math.MaxInt64 {
+		return array{}, fmt.Errorf("matrix exponent must be an integer, got %s", FormatFloat(k))
+	}
+	if k < 0 {
+		inverse, err := solveLinear(a, identity(a.rows()))
+		if err != nil {
+			return array{}, err
+		}
+		a, k = inverse, -k
+	}
+	return power(a, int64(k), identity(a.rows()), matrixProduct)
+}
+
+func (matrixDomain) call(name string, args []array) (array, error) {
+	if name == "vstack" {
+		return vstack(args)
+	}
+	if _, ok := matrixFunctions[name]; ok {
+		return callFunction(matrixFunctions, name, args)
+	}
+	if f, ok := floatFunctions[name]; ok && f.arity == 1 && len(args) == 1 {
+		return args[0].apply(func(x float64) (float64, error) { return f.fn([]float64{x}) })
+	}
+	numbers := make([]float64, len(args))
+	for i, arg := range args {
+		if !arg.isScalar() {
+			return array{}, fmt.Errorf("%s expects numbers, got a %s", name, arg)
+		}
+		numbers[i] = arg.data[0]
+	}
+	result, err := callFunction(floatFunctions, name, numbers)
+	return scalar(result), err
+}
+
+func (matrixDomain) compare(a, b array) (int, error) {
+	if !a.isScalar() || !b.isScalar() {
+		return 0, fmt.Errorf("cannot order a %s and a %s", a, b)
+	}
+	return floatDomain{}.compare(a.data[0], b.data[0])
+}
+
+func (matrixDomain) equal(a, b array) bool {
+	return slices.Equal(a.shape, b.shape) && slices.Equal(a.data, b.data)
+}
+
+// truth makes an array true if any of its elements is not zero.
+func (matrixDomain) truth(x array) bool {
+	return slices.ContainsFunc(x.data, func(v float64) bool { return v != 0 })
+}
+
+func (matrixDomain) fromBool(b bool) array {
+	return scalar(floatDomain{}.fromBool(b))
+}
+
+// minPartitionProducts is the smallest number of element multiplications
+// Partition gives a part of a matrix product.
+const minPartitionProducts = 1000000
+
+// partitionProduct splits n, if it is the product of two matrix literals,
+// into up to parts products of consecutive rows of the left matrix with
+// the right one, which vstack combines.
+func partitionProduct(n node, parts int) []string {
+	product, ok := n.(*binaryNode)
+	if !ok || product.op != "*" {
+		return nil
+	}
+	a, ok := product.x.(*arrayNode)
+	if !ok || len(a.elements) == 0 {
+		return nil
+	}
+	row, ok := a.elements[0].(*arrayNode)
+	if !ok {
+		return nil
+	}
+	b, ok := product.y.(*arrayNode)
+	if !ok || len(b.elements) == 0 {
+		return nil
+	}
+	width := 1
+	if row, ok := b.elements[0].(*arrayNode); ok {
+		width = len(row.elements)
+	}
+	rows := len(a.elements)
+	products := float64(rows) * float64(len(row.elements)) * float64(width)
+	parts = int(math.Min(float64(min(parts, rows)), products/minPartitionProducts))
+	if parts < 2 {
+		return nil
+	}
+	size := (rows + parts - 1) / parts
+	var result []string
+	for start := 0; start < rows; start += size {
+		block := &arrayNode{elements: a.elements[start:min(start+size, rows)]}
+		result = append(result, format(mul(block, b)))
+	}
+	return result
+}
@@ -0,0 +1,159 @@
+package calc
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMatrixCalculator(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   string
+		expectErr  bool
+	}{
+		{"[1, 2, 3]", "[1, 2, 3]", false},
+		{"[[1, 2], [3, 4]]", "[[1, 2], [3, 4]]", false},
+		{"[1, 2] + [3, 4]", "[4, 6]", false},
+		{"[[1, 2], [3, 4]] - 1", "[[0, 1], [2, 3]]", false},
+		{"2 * [1, 2]", "[2, 4]", false},
+		{"[2, 4] / 2", "[1, 2]", false},
+		{"-[1, -2]", "[-1, 2]", false},
+		{"[1, 2] .* [3, 4]", "[3, 8]", false},
+		{"[3, 8] ./ [3, 4]", "[1, 2]", false},
+		{"[1, 2, 3] .^ 2", "[1, 4, 9]", false},
+		{"2 .^ [1, 2]", "[2, 4]", false},
+		{"[[1, 2], [3, 4]] * [[5, 6], [7, 8]]", "[[19, 22], [43, 50]]", false},
+		{"[[1, 2], [3, 4]] * [5, 6]", "[17, 39]", false},
+		{"[1, 2] * [[3, 4]]", "[[3, 4], [6, 8]]", false},
+		{"[[1, 1], [1, 0]] ^ 10", "[[89, 55], [55, 34]]", false},
+		{"[[2, 0], [0, 4]] ^ -1", "[[0.5, 0], [0, 0.25]]", false},
+		{"[[1, 2], [3, 4]] ** 0", "[[1, 0], [0, 1]]", false},
+		{"det([[1, 2], [3, 4]])", "-2", false},
+		{"det([[0, 1], [1, 0]])", "-1", false},
+		{"det([[1, 2], [2, 4]])", "0", false},
+		{"inv([[2, 1], [1, 1]])", "[[1, -1], [-1, 2]]", false},
+		{"inv([[2, 0], [0, 4]])", "[[0.5, 0], [0, 0.25]]", false},
+		{"transpose([[1, 2, 3], [4, 5, 6]])", "[[1, 4], [2, 5], [3, 6]]", false},
+		{"transpose([1, 2])", "[[1, 2]]", false},
+		{"dot([1, 2, 3], [4, 5, 6])", "32", false},
+		{"cross([1, 0, 0], [0, 1, 0])", "[0, 0, 1]", false},
+		{"solve([[2, 0], [0, 4]], [2, 8])", "[1, 2]", false},
+		{"solve([[0, 1], [1, 0]], [[1, 2], [3, 4]])", "[[3, 4], [1, 2]]", false},
+		{"vstack([[1, 2]], [[3, 4]])", "[[1, 2], [3, 4]]", false},
+		{"vstack(1, [2, 3])", "[1, 2, 3]", false},
+		{"sqrt([4, 9])", "[2, 3]", false},
+		{"abs([-1, 2])", "[1, 2]", false},
+		{"v = [3, 4]; sqrt(dot(v, v))", "5", false},
+		{"norm(v) = sqrt(dot(v, v)); norm([6, 8])", "10", false},
+		{"sum([k, k^2], k, 1, 3)", "[6, 14]", false},
+		{"[1, 2] == [1, 2]", "1", false},
+		{"[1, 2] != [1, 2, 3]", "1", false},
+		{"[0, 0] ? 1 : 2", "2", false},
+		{"1 + 2 * 3", "7", false},
+		{"[]", "", true},
+		{"[[1], [2, 3]]", "", true},
+		{"[1, [2]]", "", true},
+		{"[[[1]]]", "", true},
+		{"[1, 2] + [1, 2, 3]", "", true},
+		{"[1, 2] * [3, 4]", "", true},
+		{"[[1, 2]] * [[3, 4]]", "", true},
+		{"1 / [1, 2]", "", true},
+		{"[1, 2] / [1, 2]", "", true},
+		{"[1, 2] ./ [1, 0]", "", true},
+		{"[1, 2] ^ 2", "", true},
+		{"[[1, 2], [3, 4]] ^ 0.5", "", true},
+		{"[1, 2] < [3, 4]", "", true},
+		{"det([1, 2])", "", true},
+		{"det([[1, 2, 3], [4, 5, 6]])", "", true},
+		{"inv([[1, 2], [2, 4]])", "", true},
+		{"inv([[1, 2, 3], [4, 5, 6], [7, 8, 9]])", "", true},
+		{"dot([1, 2], [1, 2, 3])", "", true},
+		{"cross([1, 2], [3, 4])", "", true},
+		{"solve([[1, 0], [0, 1]], [1, 2, 3])", "", true},
+		{"vstack([1, 2], [[3, 4]])", "", true},
+		{"sqrt([4, -9])", "", true},
+		{"[1, 2", "", true},
+		{"[1, 2]]", "", true},
+	}
+
+	calc := NewMatrixCalculator()
+	for _, tc := range testCases {
+		value, err := calc.Exact(tc.expression)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got %+v", tc.expression, value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.expression, err)
+			continue
+		}
+		if value.Literal() != tc.expected {
+			t.Errorf("%s: expected %s, got %s", tc.expression, tc.expected, value.Literal())
+		}
+	}
+}
+
+func TestMatrixValue(t *testing.T) {
+	result, value, err := Evaluate("[[1, 2], [3, 4]] * [1, 1]", Options{Mode: ModeMatrix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(value)
+	if result != 0 || value.IsReal() || string(data) != `{"type":"vector","vector":[3,7]}` {
+		t.Fatalf("expected the vector [3, 7], got %v, %s", result, data)
+	}
+	_, value, err = Evaluate("transpose([[1, 2], [3, 4]])", Options{Mode: ModeMatrix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ = json.Marshal(value)
+	if string(data) != `{"type":"matrix","matrix":[[1,3],[2,4]]}` {
+		t.Fatalf("expected the transposed matrix, got %s", data)
+	}
+	// The literal of a result evaluates back to it.
+	_, again, err := Evaluate(value.Literal(), Options{Mode: ModeMatrix})
+	if err != nil || !reflect.DeepEqual(again, value) {
+		t.Fatalf("expected %s to evaluate to itself, got %+v (%v)", value.Literal(), again, err)
+	}
+	result, value, err = Evaluate("det([[2, 1], [1, 1]])", Options{Mode: ModeMatrix})
+	if err != nil || result != 1 || !value.IsReal() || value.Type != ModeFloat {
+		t.Fatalf("expected the number 1, got %v, %+v (%v)", result, value, err)
+	}
+	_, value, err = Evaluate("total(v) * 2", Options{Mode: ModeMatrix, Functions: []string{"total(v) = dot(v, [1, 1, 1])"}, Inputs: map[string]string{"v": "[1, 2, 3]"}})
+	if err != nil || value.Literal() != "12" {
+		t.Fatalf("expected functions and inputs with arrays, got %+v (%v)", value, err)
+	}
+	if _, err := NewMatrixCalculator().Calculate("[1, 2]"); err == nil {
+		t.Fatal("expected Calculate to reject a vector")
+	}
+	if _, _, err := Evaluate("[1, 2]", Options{}); err == nil {
+		t.Fatal("expected arrays to require matrix mode")
+	}
+}
+
+func TestPartitionMatrixProduct(t *testing.T) {
+	var rows []string
+	for i := 0; i < 200; i++ {
+		rows = append(rows, "["+strings.TrimSuffix(strings.Repeat("1, ", 100), ", ")+"]")
+	}
+	a := "[" + strings.Join(rows, ", ") + "]"
+	b := "[" + strings.Join(rows[:100], ", ") + "]"
+	parts, op := Partition(a+" * "+b, 4, Options{Mode: ModeMatrix})
+	if len(parts) != 2 || op != "vstack" {
+		t.Fatalf("expected 2000000 multiplications to be split in 2, got %d parts, %q", len(parts), op)
+	}
+	block := "[" + strings.Join(rows[:100], ", ") + "]"
+	if parts[0] != block+"*"+b || parts[1] != parts[0] {
+		t.Fatalf("expected products of blocks of 100 rows, got %.60q", parts[0])
+	}
+	if parts, _ := Partition(b+" * "+b, 4, Options{Mode: ModeMatrix}); parts != nil {
+		t.Fatalf("expected a small product to stay whole, got %d parts", len(parts))
+	}
+	if parts, _ := Partition(a+" * "+b, 4, Options{}); parts != nil {
+		t.Fatalf("expected no split outside matrix mode, got %d parts", len(parts))
+	}
+}
@@ -0,0 +1,484 @@
+package calc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mode selects the number system an expression is evaluated in.
+type Mode string
+
+const (
+	ModeFloat    Mode = "float"
+	ModeDecimal  Mode = "decimal"
+	ModeRational Mode = "rational"
+	ModeComplex  Mode = "complex"
+	ModeInteger  Mode = "integer"
+	ModeUnits    Mode = "units"
+	ModeTime     Mode = "time"
+	ModeMatrix   Mode = "matrix"
+)
+
+// Operation selects what is computed from an expression.
+type Operation string
+
+const (
+	// OperationEvaluate computes the value of the expression.
+	OperationEvaluate Operation = "evaluate"
+	// OperationDerive computes the derivative of the expression with
+	// respect to Options.Variable.
+	OperationDerive Operation = "derive"
+	// OperationSimplify rewrites the expression in canonical form.
+	OperationSimplify Operation = "simplify"
+	// OperationSolve finds the roots of an equation in Options.Variable.
+	OperationSolve Operation = "solve"
+)
+
+// Dialect selects how an expression is read where calculators disagree.
+type Dialect string
+
+const (
+	// DialectProgramming, the default, reads % as the remainder, as
+	// programming languages do.
+	DialectProgramming Dialect = "programming"
+	// DialectDesk reads % as a percentage, as desk calculators do:
+	// 200 + 10% is 220, 200 * 10% is 20 and 50% of 80 is 40.
+	DialectDesk Dialect = "desk"
+)
+
+// Locale selects the separators numbers are read and formatted with. The
+// zero Locale reads and formats canonical literals, with a decimal point
+// and without group separators.
+type Locale string
+
+const (
+	// LocaleEnglish writes 1,234.5. Since the comma separates arguments,
+	// numbers are read without group separators.
+	LocaleEnglish Locale = "en"
+	// LocaleGerman writes 1.234,5 and separates arguments by ;.
+	LocaleGerman Locale = "de"
+	// LocaleFrench writes 1 234,5, with a narrow no-break space, and
+	// separates arguments by ;.
+	LocaleFrench Locale = "fr"
+)
+
+// TypeExpression is the Type of a Value holding an expression rather than
+// a number, such as a derivative or a simplified expression.
+const TypeExpression Mode = "expression"
+
+// TypeRoots is the Type of a Value holding the roots of an equation.
+const TypeRoots Mode = "roots"
+
+// Options configure how an expression is evaluated. The zero value
+// evaluates in float64, as BasicCalculator does.
+type Options struct {
+	Operation Operation `json:"operation,omitempty"`
+	// Variable is the variable symbolic operations work on.
+	Variable string `json:"variable,omitempty"`
+	Mode     Mode   `json:"mode,omitempty"`
+	// Dialect is the dialect of the expression, functions and inputs.
+	Dialect Dialect `json:"dialect,omitempty"`
+	// Lenient parses the expression, functions and inputs leniently,
+	// accepting implicit multiplication, as in 2x or (a+b)(a-b), and the
+	// Unicode operators ×, ÷, −, √, ² and ³ of formulas copied from
+	// documents, for every operation.
+	Lenient bool `json:"lenient,omitempty"`
+	// Locale is the locale of the numbers in the expression and functions.
+	// Inputs are canonical literals in every locale.
+	Locale Locale `json:"locale,omitempty"`
+	// Precision is the number of significant digits in decimal mode and
+	// the number of fractional digits of the decimal rendering in rational
+	// mode.
+	Precision int    `json:"precision,omitempty"`
+	Rounding  string `json:"rounding,omitempty"`
+	// Functions are definitions such as "f(x) = x^2 + 1" of functions the
+	// expression can call, written like the expression.
+	Functions []string `json:"functions,omitempty"`
+	// Definitions are functions the expression can call that are written
+	// in a syntax of their own, such as saved functions. Functions replace
+	// those of the same name.
+	Definitions []Definition `json:"definitions,omitempty"`
+	// Inputs are the values of the $ and @ references in the expression,
+	// each written as an expression, as Value.Literal returns.
+	Inputs map[string]string `json:"inputs,omitempty"`
+	// Datasets are the values of the datasets the expression references
+	// as #name, by name.
+	Datasets map[string][]json.Number `json:"datasets,omitempty"`
+	// Lower and Upper bound the range solve searches for roots; if both
+	// are zero it searches [-100, 100].
+	Lower float64 `json:"lower,omitempty"`
+	Upper float64 `json:"upper,omitempty"`
+	// Tolerance is the accuracy of the roots solve finds, 1e-10 if zero.
+	Tolerance float64 `json:"tolerance,omitempty"`
+	// MaxIterations bounds the iterations solve spends refining each
+	// root, 100 if zero.
+	MaxIterations int `json:"max_iterations,omitempty"`
+	// TimeZone is the IANA time zone, such as "Europe/Berlin", of dates
+	// and times in time mode, UTC if empty.
+	TimeZone string `json:"timezone,omitempty"`
+}
+
+// Validate reports whether the options describe a supported evaluation.
+func (o Options) Validate() error {
+	switch o.Operation {
+	case "", OperationEvaluate, OperationSimplify:
+	case OperationDerive:
+		if !IsLabel(o.Variable) {
+			return fmt.Errorf("%s requires a variable", o.Operation)
+		}
+	case OperationSolve:
+		if !IsLabel(o.Variable) {
+			return fmt.Errorf("%s requires a variable", o.Operation)
+		}
+		if o.Mode != "" && o.Mode != ModeFloat {
+			return fmt.Errorf("%s only supports float mode", o.Operation)
+		}
+		if o.Lower > o.Upper || o.Lower == o.Upper && o.Lower != 0 {
+			return errors.New("lower must be less than upper")
+		}
+		if o.Tolerance < 0 || o.MaxIterations < 0 {
+			return errors.New("tolerance and max_iterations must not be negative")
+		}
+	default:
+		return fmt.Errorf("unknown operation: %s", o.Operation)
+	}
+	switch o.Mode {
+	case "", ModeFloat, ModeDecimal, ModeRational, ModeComplex, ModeInteger, ModeUnits, ModeTime, ModeMatrix:
+	default:
+		return fmt.Errorf("unknown mode: %s", o.Mode)
+	}
+	switch o.Dialect {
+	case "", DialectProgramming:
+	case DialectDesk:
+		if o.Operation != "" && o.Operation != OperationEvaluate {
+			return fmt.Errorf("%s only supports the programming dialect", o.Operation)
+		}
+	default:
+		return fmt.Errorf("unknown dialect: %s", o.Dialect)
+	}
+	if _, ok := numberFormats[o.Locale]; !ok && o.Locale != "" {
+		return fmt.Errorf("unknown locale: %s", o.Locale)
+	}
+	if o.Locale != "" && o.Operation != "" && o.Operation != OperationEvaluate {
+		return fmt.Errorf("%s does not support locales", o.Operation)
+	}
+	if o.TimeZone != "" {
+		if o.Mode != ModeTime {
+			return errors.New("timezone requires time mode")
+		}
+		if _, err := time.LoadLocation(o.TimeZone); err != nil {
+			return fmt.Errorf("unknown time zone: %s", o.TimeZone)
+		}
+	}
+	if o.Precision < 0 || o.Precision > MaxDecimalPrecision {
+		return fmt.Errorf("precision must be between 0 and %d", MaxDecimalPrecision)
+	}
+	if _, err := ParseRoundingMode(o.Rounding); err != nil {
+		return err
+	}
+	for _, d := range o.definitions() {
+		if _, err := d.Parse(); err != nil {
+			return err
+		}
+	}
+	for name := range o.Datasets {
+		if !IsLabel(name) {
+			return fmt.Errorf("invalid dataset name: %q", name)
+		}
+	}
+	return nil
+}
+
+// New returns the calculator selected by opts.
+func New(opts Options) (Calculator, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	scope, err := opts.scope()
+	if err != nil {
+		return nil, err
+	}
+	switch opts.Mode {
+	case ModeDecimal:
+		rounding, _ := ParseRoundingMode(opts.Rounding)
+		c := NewDecimalCalculator(opts.Precision, rounding)
+		c.Scope = scope
+		return c, nil
+	case ModeRational:
+		return &RationalCalculator{DecimalDigits: opts.Precision, Scope: scope}, nil
+	case ModeComplex:
+		return &ComplexCalculator{Scope: scope}, nil
+	case ModeInteger:
+		return &IntegerCalculator{Scope: scope}, nil
+	case ModeUnits:
+		return &UnitsCalculator{Scope: scope}, nil
+	case ModeTime:
+		location, _ := time.LoadLocation(opts.TimeZone)
+		return &TimeCalculator{Location: location, Scope: scope}, nil
+	case ModeMatrix:
+		return &MatrixCalculator{Scope: scope}, nil
+	default:
+		return &BasicCalculator{Scope: scope}, nil
+	}
+}
+
+// scope returns the Scope holding the functions, inputs and datasets of
+// o in its dialect, parsing and locale, or nil if there are none and o
+// parses canonical literals strictly in the default dialect.
+func (o Options) scope() (*Scope, error) {
+	if len(o.Functions) == 0 && len(o.Definitions) == 0 && len(o.Inputs) == 0 && len(o.Datasets) == 0 && o.Dialect != DialectDesk && !o.Lenient && o.Locale == "" {
+		return nil, nil
+	}
+	scope, err := NewScopeFrom(o.definitions())
+	if err != nil {
+		return nil, err
+	}
+	scope.SetDialect(o.Dialect)
+	scope.SetLenient(o.Lenient)
+	scope.SetLocale(o.Locale)
+	for name, expression := range o.Inputs {
+		scope.Set(name, expression)
+	}
+	for name, values := range o.Datasets {
+		literals := make([]string, len(values))
+		for i, v := range values {
+			literals[i] = v.String()
+		}
+		scope.SetDataset(name, literals)
+	}
+	return scope, nil
+}
+
+// definitions returns the functions of o, the Definitions followed by the
+// Functions, which are written in the syntax of o.
+func (o Options) definitions() []Definition {
+	definitions := append([]Definition(nil), o.Definitions...)
+	for _, definition := range o.Functions {
+		definitions = append(definitions, Definition{Definition: definition, Mode: o.Mode, Dialect: o.Dialect, Lenient: o.Lenient, Locale: o.Locale})
+	}
+	return definitions
+}
+
+// defines reports whether one of the functions of o is named name.
+func (o Options) defines(name string) bool {
+	for _, d := range o.definitions() {
+		if defined, _ := d.Parse(); defined == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Value is the exact result of an evaluation in a form that can be sent
+// over the API. Type names the number system and selects which of the
+// other fields are set: Decimal for decimal results, Numerator and
+// Denominator, optionally with a Decimal rendering, for rational results,
+// Re and Im for complex results, Integer for integer results, Decimal and
+// Unit, which is empty for dimensionless quantities, for units results,
+// Time, in RFC 3339, for results of type TypeInstant, Duration, as a
+// duration literal such as 1d2h30m, for results of type TypeDuration,
+// Vector for results of type TypeVector, Matrix, by rows, for results of
+// type ModeMatrix, Decimal for numbers of type ModeFloat, which only time
+// and matrix mode return, and
+// Expression for results of type TypeExpression and Roots, in ascending
+// order and omitted if there are none, for results of type TypeRoots.
+type Value struct {
+	Type        Mode        `json:"type"`
+	Expression  string      `json:"expression,omitempty"`
+	Decimal     string      `json:"decimal,omitempty"`
+	Numerator   string      `json:"numerator,omitempty"`
+	Denominator string      `json:"denominator,omitempty"`
+	Re          *float64    `json:"re,omitempty"`
+	Im          *float64    `json:"im,omitempty"`
+	Integer     string      `json:"integer,omitempty"`
+	Unit        string      `json:"unit,omitempty"`
+	Time        string      `json:"time,omitempty"`
+	Duration    string      `json:"duration,omitempty"`
+	Vector      []float64   `json:"vector,omitempty"`
+	Matrix      [][]float64 `json:"matrix,omitempty"`
+	Roots       []float64   `json:"roots,omitempty"`
+}
+
+// IsReal reports whether v is a real number, i.e. Float64 represents it
+// without losing an imaginary part.
+func (v *Value) IsReal() bool {
+	switch v.Type {
+	case ModeComplex:
+		return v.Im == nil || *v.Im == 0
+	case TypeExpression, TypeRoots, TypeInstant, TypeDuration, TypeVector, ModeMatrix:
+		return false
+	default:
+		return true
+	}
+}
+
+// Float64 returns the float64 nearest to v. For complex values it is the
+// real part, for roots the smallest root, for instants the Unix time and
+// for durations the number of seconds. Vectors and matrices have no
+// float64 approximation; Float64 returns 0 for them.
+func (v *Value) Float64() float64 {
+	switch v.Type {
+	case ModeComplex:
+		if v.Re == nil {
+			return 0
+		}
+		return *v.Re
+	case ModeRational:
+		r, ok := new(big.Rat).SetString(v.Numerator + "/" + v.Denominator)
+		if !ok {
+			return 0
+		}
+		f, _ := r.Float64()
+		return f
+	case ModeInteger:
+		f, _ := strconv.ParseFloat(v.Integer, 64)
+		return f
+	case TypeRoots:
+		if len(v.Roots) == 0 {
+			return 0
+		}
+		return v.Roots[0]
+	case TypeInstant:
+		t, err := time.Parse(time.RFC3339Nano, v.Time)
+		if err != nil {
+			return 0
+		}
+		return float64(t.UnixNano()) / 1e9
+	case TypeDuration:
+		d, err := parseDuration(strings.TrimPrefix(v.Duration, "-"))
+		if err != nil {
+			return 0
+		}
+		if strings.HasPrefix(v.Duration, "-") {
+			d = -d
+		}
+		return d.Seconds()
+	case TypeVector, ModeMatrix:
+		return 0
+	default:
+		f, _ := strconv.ParseFloat(v.Decimal, 64)
+		return f
+	}
+}
+
+// HasFloat64 reports whether v is real and within the range of float64,
+// so that Float64 approximates it. Exact results such as 10^400 are not.
+func (v *Value) HasFloat64() bool {
+	return v.IsReal() && !math.IsInf(v.Float64(), 0)
+}
+
+// Literal returns an expression that evaluates to v, such as "1/3" for a
+// rational or "3-4i" for a complex value. Roots have a literal only if
+// there is exactly one; otherwise Literal returns "".
+func (v *Value) Literal() string {
+	switch v.Type {
+	case TypeExpression:
+		return "(" + v.Expression + ")"
+	case TypeRoots:
+		// Only a unique root can stand in for the equation.
+		if len(v.Roots) != 1 {
+			return ""
+		}
+		return FormatFloat(v.Roots[0])
+	case ModeRational:
+		if v.Denominator == "1" {
+			return v.Numerator
+		}
+		return v.Numerator + "/" + v.Denominator
+	case ModeComplex:
+		var re, im float64
+		if v.Re != nil {
+			re = *v.Re
+		}
+		if v.Im != nil {
+			im = *v.Im
+		}
+		if im == 0 {
+			return FormatFloat(re)
+		}
+		sign := "+"
+		if im < 0 {
+			sign, im = "-", -im
+		}
+		return FormatFloat(re) + sign + FormatFloat(im) + "i"
+	case ModeInteger:
+		return v.Integer
+	case TypeInstant:
+		return v.Time
+	case TypeDuration:
+		return v.Duration
+	case TypeVector:
+		return formatArray(v.Vector)
+	case ModeMatrix:
+		rows := make([]string, len(v.Matrix))
+		for i, row := range v.Matrix {
+			rows[i] = formatArray(row)
+		}
+		return "[" + strings.Join(rows, ", ") + "]"
+	case ModeUnits:
+		if v.Unit == "" {
+			return v.Decimal
+		}
+		return v.Decimal + " " + v.Unit
+	default:
+		return v.Decimal
+	}
+}
+
+// FormatFloat formats f as a number literal that evaluates back to f.
+func FormatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// Evaluate computes expression as configured by opts. It returns a float64
+// approximation of the result and, for modes with an exact representation,
+// the exact value. Exact values beyond the range of float64 are returned
+// with 0 as their approximation. Symbolic operations return their result as a Value of
+// type TypeExpression and no float64.
+func Evaluate(expression string, opts Options) (float64, *Value, error) {
+	switch opts.Operation {
+	case OperationDerive:
+		if err := opts.Validate(); err != nil {
+			return 0, nil, err
+		}
+		derivative, err := deriveWith(expression, opts.Variable, syntax{lenient: opts.Lenient})
+		if err != nil {
+			return 0, nil, err
+		}
+		return 0, &Value{Type: TypeExpression, Expression: derivative}, nil
+	case OperationSimplify:
+		simplified, err := simplifyWith(expression, syntax{lenient: opts.Lenient})
+		if err != nil {
+			return 0, nil, err
+		}
+		return 0, &Value{Type: TypeExpression, Expression: simplified}, nil
+	case OperationSolve:
+		roots, err := Solve(expression, opts.Variable, opts)
+		if err != nil {
+			return 0, nil, err
+		}
+		return 0, &Value{Type: TypeRoots, Roots: roots}, nil
+	}
+	calculator, err := New(opts)
+	if err != nil {
+		return 0, nil, err
+	}
+	if exact, ok := calculator.(ExactCalculator); ok {
+		value, err := exact.Exact(expression)
+		if err != nil {
+			return 0, nil, err
+		}
+		if f := value.Float64(); !math.IsInf(f, 0) {
+			return f, value, nil
+		}
+		return 0, value, nil
+	}
+	result, err := calculator.Calculate(expression)
+	return result, nil, err
+}
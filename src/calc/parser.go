@@ -0,0 +1,582 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// node is an expression in the abstract syntax tree built by parse.
+type node interface{}
+
+type numberNode struct {
+	text string
+}
+
+type identNode struct {
+	name string
+}
+
+type unaryNode struct {
+	op string
+	x  node
+}
+
+type binaryNode struct {
+	op   string
+	x, y node
+}
+
+type callNode struct {
+	name string
+	args []node
+}
+
+// arrayNode is an array literal [x, y, ...], whose elements may be arrays
+// themselves.
+type arrayNode struct {
+	elements []node
+}
+
+// logicalNode is a short-circuiting && or ||.
+type logicalNode struct {
+	op   string
+	x, y node
+}
+
+// conditionalNode is cond ? then : otherwise, also written
+// if(cond, then, otherwise). Only the selected branch is evaluated.
+type conditionalNode struct {
+	cond, then, otherwise node
+}
+
+// assignNode is a statement name = x.
+type assignNode struct {
+	name string
+	x    node
+}
+
+// defineNode is a statement name(params) = body defining a function.
+type defineNode struct {
+	name   string
+	params []string
+	body   node
+}
+
+// percentNode is x%, a percentage, while it is parsed: depending on what
+// it is an operand of, it becomes x/100 or a percentage of the other
+// operand, so it never reaches evaluation.
+type percentNode struct {
+	x node
+}
+
+// programNode is a sequence of statements separated by semicolons. Its
+// value is the value of the last statement.
+type programNode struct {
+	statements []node
+}
+
+// binaryOperator describes how tightly an infix operator binds.
+type binaryOperator struct {
+	precedence int
+	rightAssoc bool
+}
+
+// binaryOperators is the operator table of the grammar. From loosest to
+// tightest the levels are: || ; && ; equality ; ordering ; | ; ^ ; & ;
+// shifts ; additive ; multiplicative, which includes the element-wise
+// .* and ./ of arrays. As in Python, the bitwise operators
+// bind tighter than comparisons, so x & 0xFF == 0 tests the masked bits.
+// The conditional operator ?: binds looser than all of them and unary
+// operators tighter still, except for exponentiation, which is parsed
+// separately since -2**2 is -(2**2). Outside integer mode ^ is also
+// exponentiation and never reaches this table. Which operators a domain
+// defines is up to the domain.
+var binaryOperators = map[string]binaryOperator{
+	"||": {precedence: 1},
+	"&&": {precedence: 2},
+	"==": {precedence: 3},
+	"!=": {precedence: 3},
+	"<":  {precedence: 4},
+	"<=": {precedence: 4},
+	">":  {precedence: 4},
+	">=": {precedence: 4},
+	"|":  {precedence: 5},
+	"^":  {precedence: 6},
+	"&":  {precedence: 7},
+	"<<": {precedence: 8},
+	">>": {precedence: 8},
+	"+":  {precedence: 9},
+	"-":  {precedence: 9},
+	"*":  {precedence: 10},
+	"/":  {precedence: 10},
+	"//": {precedence: 10},
+	"%":  {precedence: 10},
+	".*": {precedence: 10},
+	"./": {precedence: 10},
+}
+
+var unaryOperators = map[string]bool{
+	"+": true,
+	"-": true,
+	"!": true,
+	"~": true,
+}
+
+// syntax selects the variations of the grammar some domains use.
+type syntax struct {
+	// xorCaret makes ^ exclusive or instead of exponentiation.
+	xorCaret bool
+	// units makes a name directly after an operand multiply it, as in
+	// 5 km, binding tighter than * and /, and adds the conversion x to u.
+	units bool
+	// time adds date, time and duration literals, as in 2026-10-18 or
+	// 1h30m, and the conversion x in u.
+	time bool
+	// arrays adds the element-wise operators .*, ./ and .^ of arrays.
+	// Array literals, as in [1, 2] or [[1, 2], [3, 4]], are parsed in
+	// every syntax, since statistics functions take them as lists.
+	arrays bool
+	// percent makes % a postfix percentage, as on desk calculators,
+	// instead of the remainder, and adds x% of y.
+	percent bool
+	// lenient makes an operand directly followed by a name, a
+	// parenthesis or √ multiply it, as in 2x, 2 sin(x) or (a+b)(a-b),
+	// binding like the names of the units syntax, and adds the Unicode
+	// operators of lenientOperators.
+	lenient bool
+	// locale selects the decimal and group separators of numbers. With
+	// the decimal comma, arguments and array elements are separated by ;.
+	locale Locale
+}
+
+// conversion returns the keyword of the conversion operator of s, if it
+// has one.
+func (s syntax) conversion() string {
+	switch {
+	case s.units:
+		return "to"
+	case s.time:
+		return "in"
+	}
+	return ""
+}
+
+// syntaxOf returns the syntax expressions evaluated in d with scope,
+// which may be nil, are parsed with.
+func syntaxOf[T any](d domain[T], scope *Scope) syntax {
+	_, xorCaret := d.(xorDomain)
+	_, units := d.(unitSyntaxDomain)
+	_, time := d.(timeSyntaxDomain)
+	_, arrays := d.(arrayDomain[T])
+	s := syntax{xorCaret: xorCaret, units: units, time: time, arrays: arrays}
+	if scope != nil {
+		s.percent = scope.dialect == DialectDesk
+		s.lenient = scope.lenient
+		s.locale = scope.locale
+	}
+	return s
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	syntax
+}
+
+// parse builds the syntax tree of expression, which may be a single
+// expression or a program of statements separated by semicolons.
+func parse(expression string, s syntax) (node, error) {
+	tokens, err := tokenize(expression, s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, syntax: s}
+	var statements []node
+	for p.peek().kind != tokenEOF {
+		if p.peek().kind == tokenSemicolon {
+			p.next()
+			continue
+		}
+		n, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, n)
+		if tok := p.peek(); tok.kind != tokenSemicolon && tok.kind != tokenEOF {
+			return nil, p.unexpected(tok)
+		}
+	}
+	switch len(statements) {
+	case 0:
+		return nil, errors.New("empty expression")
+	case 1:
+		return statements[0], nil
+	default:
+		return &programNode{statements: statements}, nil
+	}
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) unexpected(tok token) error {
+	switch tok.kind {
+	case tokenRParen:
+		return fmt.Errorf("unbalanced parentheses at position %d", tok.pos)
+	case tokenRBracket:
+		return fmt.Errorf("unbalanced brackets at position %d", tok.pos)
+	}
+	return fmt.Errorf("unexpected %s at position %d", tok, tok.pos)
+}
+
+// statement parses an expression, an assignment name = x or a function
+// definition name(params) = body.
+func (p *parser) statement() (node, error) {
+	start := p.peek()
+	target, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenOperator || tok.text != "=" {
+		return target, nil
+	}
+	p.next()
+	x, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	switch target := target.(type) {
+	case *identNode:
+		if isReference(target.name) {
+			return nil, fmt.Errorf("cannot assign to reference %s", target.name)
+		}
+		return &assignNode{name: target.name, x: x}, nil
+	case *callNode:
+		if isReference(target.name) {
+			return nil, fmt.Errorf("cannot define function %s", target.name)
+		}
+		params := make([]string, len(target.args))
+		seen := make(map[string]bool)
+		for i, arg := range target.args {
+			param, ok := arg.(*identNode)
+			if !ok || isReference(param.name) {
+				return nil, fmt.Errorf("parameters of %s must be names", target.name)
+			}
+			if seen[param.name] {
+				return nil, fmt.Errorf("duplicate parameter %s of %s", param.name, target.name)
+			}
+			seen[param.name] = true
+			params[i] = param.name
+		}
+		return &defineNode{name: target.name, params: params, body: x}, nil
+	default:
+		return nil, fmt.Errorf("cannot assign to expression at position %d", start.pos)
+	}
+}
+
+// conditional parses an expression, including the right-associative
+// conditional operator.
+func (p *parser) conditional() (node, error) {
+	cond, err := p.conversion()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenOperator || tok.text != "?" {
+		return cond, nil
+	}
+	p.next()
+	then, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.next(); tok.kind != tokenOperator || tok.text != ":" {
+		return nil, p.unexpected(tok)
+	}
+	otherwise, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	return &conditionalNode{cond: cond, then: then, otherwise: otherwise}, nil
+}
+
+// conversion parses an expression followed, with the units or time
+// syntax, by any number of conversions x to u or x in u, which bind looser
+// than all binary operators but tighter than the conditional operator.
+func (p *parser) conversion() (node, error) {
+	x, err := p.expression(1)
+	if err != nil {
+		return nil, err
+	}
+	keyword := p.syntax.conversion()
+	for keyword != "" && p.peek().kind == tokenIdent && p.peek().text == keyword {
+		p.next()
+		unit, err := p.expression(1)
+		if err != nil {
+			return nil, err
+		}
+		x = &binaryNode{op: keyword, x: percentage(x), y: percentage(unit)}
+	}
+	return percentage(x), nil
+}
+
+// expression parses operators binding at least as tightly as minPrecedence
+// by precedence climbing.
+func (p *parser) expression(minPrecedence int) (node, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		op, ok := binaryOperators[tok.text]
+		if tok.kind != tokenOperator || !ok || op.precedence < minPrecedence {
+			return left, nil
+		}
+		p.next()
+		nextMin := op.precedence + 1
+		if op.rightAssoc {
+			nextMin = op.precedence
+		}
+		right, err := p.expression(nextMin)
+		if err != nil {
+			return nil, err
+		}
+		if tok.text == "&&" || tok.text == "||" {
+			left = &logicalNode{op: tok.text, x: percentage(left), y: percentage(right)}
+		} else {
+			left = binary(tok.text, left, right)
+		}
+	}
+}
+
+// binary builds x op y. A percentage y% on the right of + and - is that
+// percentage of x, so 200 + 10% is 220, and on the right of * and / it
+// scales x, so 200 * 10% is 20. Anywhere else y% is y/100. Multiplying
+// before dividing by 100 keeps results like 300 * 7% exact in float
+// mode.
+func binary(op string, x, y node) node {
+	x = percentage(x)
+	if pct, ok := y.(*percentNode); ok {
+		switch op {
+		case "+", "-":
+			return &binaryNode{op: op, x: x, y: div(mul(x, pct.x), number("100"))}
+		case "*":
+			return div(mul(x, pct.x), number("100"))
+		case "/":
+			return div(mul(x, number("100")), pct.x)
+		}
+	}
+	return &binaryNode{op: op, x: x, y: percentage(y)}
+}
+
+// percentage returns n as a value: x/100 if it is a percentage x%.
+func percentage(n node) node {
+	if pct, ok := n.(*percentNode); ok {
+		return div(pct.x, number("100"))
+	}
+	return n
+}
+
+func (p *parser) unary() (node, error) {
+	tok := p.peek()
+	if tok.kind == tokenOperator && unaryOperators[tok.text] {
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		// -10% is a percentage too, so 200 + -10% is 180.
+		if pct, ok := x.(*percentNode); ok {
+			return &percentNode{x: &unaryNode{op: tok.text, x: pct.x}}, nil
+		}
+		return &unaryNode{op: tok.text, x: x}, nil
+	}
+	return p.percent()
+}
+
+// percent parses, with the percent syntax, an operand followed by %,
+// which makes it a percentage, and optionally by of and the value it is a
+// percentage of, as in 50% of 80. Without it, it parses an operand.
+func (p *parser) percent() (node, error) {
+	x, err := p.juxtaposition()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); !p.syntax.percent || tok.kind != tokenOperator || tok.text != "%" {
+		return x, nil
+	}
+	p.next()
+	switch tok := p.peek(); {
+	case tok.kind == tokenIdent && tok.text == "of":
+	case tok.kind == tokenNumber || tok.kind == tokenIdent || tok.kind == tokenLParen:
+		return nil, fmt.Errorf("unexpected %s at position %d: %% is a percentage, not the remainder, in the desk dialect", tok, tok.pos)
+	default:
+		return &percentNode{x: x}, nil
+	}
+	p.next()
+	base, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	return div(mul(x, percentage(base)), number("100")), nil
+}
+
+// juxtaposition parses, with the units syntax, operands followed by names
+// they are multiplied by, as in 5 km or 2 kg m^2, and with the lenient
+// syntax also by parenthesized expressions and square roots, as in 3(4+5)
+// or 2√3. Otherwise it parses an operand.
+func (p *parser) juxtaposition() (node, error) {
+	left, err := p.power()
+	if err != nil {
+		return nil, err
+	}
+	for p.juxtaposed(p.peek()) {
+		right, err := p.power()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: "*", x: left, y: right}
+	}
+	return left, nil
+}
+
+// juxtaposed reports whether tok, following an operand, starts another
+// operand it is multiplied by. A number never does, so 2 3 stays an
+// error.
+func (p *parser) juxtaposed(tok token) bool {
+	switch {
+	case tok.kind == tokenIdent:
+		return (p.units || p.lenient) && tok.text != p.syntax.conversion()
+	case tok.kind == tokenLParen:
+		return p.lenient
+	case tok.kind == tokenOperator && tok.text == "√":
+		return p.lenient
+	}
+	return false
+}
+
+// power parses a right-associative exponentiation, which binds tighter
+// than unary operators on its left but accepts them on its right, as in
+// 2**-1. With the arrays syntax, the element-wise .^ binds like it.
+func (p *parser) power() (node, error) {
+	base, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	tok := p.peek()
+	if tok.kind != tokenOperator || (tok.text != "**" && tok.text != ".^" && (tok.text != "^" || p.xorCaret)) {
+		return base, nil
+	}
+	p.next()
+	exponent, err := p.exponent()
+	if err != nil {
+		return nil, err
+	}
+	op := "**"
+	if tok.text == ".^" {
+		op = ".^"
+	}
+	return &binaryNode{op: op, x: base, y: exponent}, nil
+}
+
+// exponent parses the operand of an exponentiation, which may have unary
+// operators but, unlike other operands, takes no juxtaposed names: m^2 s
+// is m^2 times s.
+func (p *parser) exponent() (node, error) {
+	tok := p.peek()
+	if tok.kind == tokenOperator && unaryOperators[tok.text] {
+		p.next()
+		x, err := p.exponent()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: tok.text, x: x}, nil
+	}
+	return p.power()
+}
+
+func (p *parser) primary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenNumber:
+		return &numberNode{text: tok.text}, nil
+	case tokenIdent:
+		if p.peek().kind == tokenLParen {
+			p.next()
+			args, err := p.arguments(tokenRParen)
+			if err != nil {
+				return nil, err
+			}
+			if tok.text == "if" {
+				if len(args) != 3 {
+					return nil, fmt.Errorf("if expects 3 arguments, got %d", len(args))
+				}
+				return &conditionalNode{cond: args[0], then: args[1], otherwise: args[2]}, nil
+			}
+			return &callNode{name: tok.text, args: args}, nil
+		}
+		return &identNode{name: tok.text}, nil
+	case tokenLParen:
+		x, err := p.conditional()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokenRParen {
+			return nil, fmt.Errorf("unbalanced parentheses at position %d", tok.pos)
+		}
+		return x, nil
+	case tokenOperator:
+		if tok.text != "√" {
+			return nil, p.unexpected(tok)
+		}
+		// √ applies to the operand after it, powers included, so √x² is
+		// the square root of x².
+		x, err := p.power()
+		if err != nil {
+			return nil, err
+		}
+		return call("sqrt", x), nil
+	case tokenLBracket:
+		elements, err := p.arguments(tokenRBracket)
+		if err != nil {
+			return nil, err
+		}
+		return &arrayNode{elements: elements}, nil
+	default:
+		return nil, p.unexpected(tok)
+	}
+}
+
+// arguments parses a comma-separated argument list after the opening
+// parenthesis of a call, or the elements of an array after its opening
+// bracket, up to the closing token.
+func (p *parser) arguments(closing tokenKind) ([]node, error) {
+	var args []node
+	if p.peek().kind == closing {
+		p.next()
+		return args, nil
+	}
+	for {
+		arg, err := p.conditional()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		switch tok := p.next(); tok.kind {
+		case tokenComma:
+		case closing:
+			return args, nil
+		default:
+			return nil, p.unexpected(tok)
+		}
+	}
+}
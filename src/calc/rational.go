@@ -0,0 +1,172 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// RationalCalculator evaluates expressions in exact rational arithmetic,
+// so 1/3 + 1/6 is exactly 1/2.
+type RationalCalculator struct {
+	// DecimalDigits, when positive, adds a decimal rendering with that many
+	// fractional digits to exact results.
+	DecimalDigits int
+	// Scope, if not nil, holds user-defined functions expressions can call.
+	Scope *Scope
+}
+
+func NewRationalCalculator(decimalDigits int) *RationalCalculator {
+	return &RationalCalculator{DecimalDigits: decimalDigits}
+}
+
+func parseRat(token string) (*big.Rat, error) {
+	r, ok := new(big.Rat).SetString(token)
+	if !ok || strings.Contains(token, "/") {
+		return nil, fmt.Errorf("invalid number: %s", token)
+	}
+	return r, nil
+}
+
+// Evaluate returns the exact rational value of expression.
+func (c *RationalCalculator) Evaluate(expression string) (*big.Rat, error) {
+	return evaluateString[*big.Rat](expression, rationalDomain{}, c.Scope)
+}
+
+// rationalDomain evaluates in exact big.Rat arithmetic.
+type rationalDomain struct{}
+
+var rationalFunctions = map[string]function[*big.Rat]{
+	"abs": {1, func(args []*big.Rat) (*big.Rat, error) { return new(big.Rat).Abs(args[0]), nil }},
+}
+
+func (rationalDomain) number(literal string) (*big.Rat, error) {
+	if isImaginary(literal) {
+		return nil, fmt.Errorf("imaginary literal %s requires complex mode", literal)
+	}
+	return parseRat(literal)
+}
+
+func (rationalDomain) ident(name string) (*big.Rat, error) {
+	return unknownIdent[*big.Rat](name)
+}
+
+func (rationalDomain) unary(op string, x *big.Rat) (*big.Rat, error) {
+	switch op {
+	case "+":
+		return x, nil
+	case "-":
+		return new(big.Rat).Neg(x), nil
+	default:
+		return undefinedOperator[*big.Rat](op)
+	}
+}
+
+func (rationalDomain) binary(op string, a, b *big.Rat) (*big.Rat, error) {
+	switch op {
+	case "+":
+		return new(big.Rat).Add(a, b), nil
+	case "-":
+		return new(big.Rat).Sub(a, b), nil
+	case "*":
+		return new(big.Rat).Mul(a, b), nil
+	case "/":
+		if b.Sign() == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return new(big.Rat).Quo(a, b), nil
+	case "**":
+		return ratPower(a, b)
+	default:
+		return undefinedOperator[*big.Rat](op)
+	}
+}
+
+func (rationalDomain) call(name string, args []*big.Rat) (*big.Rat, error) {
+	return callFunction(rationalFunctions, name, args)
+}
+
+func (rationalDomain) compare(a, b *big.Rat) (int, error) {
+	return a.Cmp(b), nil
+}
+
+func (rationalDomain) equal(a, b *big.Rat) bool {
+	return a.Cmp(b) == 0
+}
+
+func (rationalDomain) truth(x *big.Rat) bool {
+	return x.Sign() != 0
+}
+
+func (rationalDomain) fromBool(b bool) *big.Rat {
+	if b {
+		return big.NewRat(1, 1)
+	}
+	return new(big.Rat)
+}
+
+// Exact implements ExactCalculator.
+func (c *RationalCalculator) Exact(expression string) (*Value, error) {
+	result, err := c.Evaluate(expression)
+	if err != nil {
+		return nil, err
+	}
+	return ratValue(result, c.DecimalDigits), nil
+}
+
+// Calculate implements Calculator with a float64 approximation of the
+// rational result.
+func (c *RationalCalculator) Calculate(expression string) (float64, error) {
+	result, err := c.Evaluate(expression)
+	if err != nil {
+		return 0, err
+	}
+	f, _ := result.Float64()
+	return f, nil
+}
+
+// maxRationalBits bounds the size of the numerator and denominator of
+// powers.
+const maxRationalBits = 1 << 20
+
+// ratPower raises a to the integer power b.
+func ratPower(a, b *big.Rat) (*big.Rat, error) {
+	if !b.IsInt() {
+		return nil, errors.New("rational mode supports only integer exponents")
+	}
+	if !b.Num().IsInt64() {
+		return nil, errors.New("exponent out of range")
+	}
+	n := b.Num().Int64()
+	if n < 0 {
+		if a.Sign() == 0 {
+			return nil, errors.New("division by zero")
+		}
+		a, n = new(big.Rat).Inv(a), -n
+	}
+	bits := int64(max(a.Num().BitLen(), a.Denom().BitLen()))
+	if bits > 1 && n > maxRationalBits/bits {
+		return nil, errors.New("result out of range")
+	}
+	num := new(big.Int).Exp(a.Num(), big.NewInt(n), nil)
+	den := new(big.Int).Exp(a.Denom(), big.NewInt(n), nil)
+	return new(big.Rat).SetFrac(num, den), nil
+}
+
+func ratValue(r *big.Rat, decimalDigits int) *Value {
+	v := &Value{Type: ModeRational, Numerator: r.Num().String(), Denominator: r.Denom().String()}
+	if decimalDigits > 0 {
+		v.Decimal = trimFraction(r.FloatString(decimalDigits))
+	}
+	return v
+}
+
+// trimFraction strips trailing zeros after the decimal point.
+func trimFraction(s string) string {
+	if !strings.Contains(s, ".") {
+		return s
+	}
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ".")
+}
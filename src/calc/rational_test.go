@@ -0,0 +1,101 @@
+package calc
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRationalCalculator(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   string
+		expectErr  bool
+	}{
+		{"1/3 + 1/6", "1/2", false},
+		{"1/3 * 3", "1", false},
+		{"0.1 + 0.2", "3/10", false},
+		{"(1 - 1/7) / (2/7)", "3", false},
+		{"1 / (1/3 - 1/3)", "", true},
+		{"1/3 +", "", true},
+	}
+
+	calculator := NewRationalCalculator(0)
+	for _, tc := range testCases {
+		result, err := calculator.Evaluate(tc.expression)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("expected error for expression %q, got none", tc.expression)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("did not expect error for expression %q, got %v", tc.expression, err)
+			continue
+		}
+		if result.RatString() != tc.expected {
+			t.Errorf("expected %s for expression %q, got %s", tc.expected, tc.expression, result.RatString())
+		}
+	}
+}
+
+func TestRationalExact(t *testing.T) {
+	value, err := NewRationalCalculator(5).Exact("1/3 + 1/6")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value.Numerator != "1" || value.Denominator != "2" || value.Decimal != "0.5" {
+		t.Fatalf("unexpected value %+v", value)
+	}
+	if value.Float64() != 0.5 {
+		t.Fatalf("expected float approximation 0.5, got %v", value.Float64())
+	}
+
+	value, _ = NewRationalCalculator(4).Exact("2/3")
+	if value.Decimal != "0.6667" {
+		t.Fatalf("expected decimal rendering 0.6667, got %s", value.Decimal)
+	}
+	value, _ = NewRationalCalculator(0).Exact("2/3")
+	if value.Decimal != "" {
+		t.Fatalf("expected no decimal rendering, got %s", value.Decimal)
+	}
+}
+
+func TestRationalBeyondFloat64(t *testing.T) {
+	large := "1" + strings.Repeat("0", 400)
+	testCases := []struct {
+		expression  string
+		numerator   string
+		denominator string
+	}{
+		{"10^400", large, "1"},
+		{"-(10^400) / 3", "-" + large, "3"},
+	}
+	for _, tc := range testCases {
+		result, value, err := Evaluate(tc.expression, Options{Mode: ModeRational})
+		if err != nil || result != 0 || value.Numerator != tc.numerator || value.Denominator != tc.denominator || value.HasFloat64() {
+			t.Errorf("%s: expected the exact value without an approximation, got %v, %+v (%v)", tc.expression, result, value, err)
+		}
+	}
+	compiled, err := Compile("x * 10^400", Options{Mode: ModeRational})
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, err := compiled.Sweep(Grid{{Name: "x", Values: []json.Number{"0", "1"}}}, 0, 2)
+	if err != nil || samples[0].Result == nil || samples[1].Result != nil || samples[1].Value == nil || samples[1].Value.Numerator != large {
+		t.Errorf("expected only the finite sample to have a result, got %+v (%v)", samples, err)
+	}
+	if _, value, err := Evaluate("10^400 / 10^399", Options{Mode: ModeRational}); err != nil || value.Numerator != "10" {
+		t.Errorf("expected large intermediate results to be kept, got %+v (%v)", value, err)
+	}
+}
+
+func TestEvaluateRationalMode(t *testing.T) {
+	result, value, err := Evaluate("1/3 + 1/6", Options{Mode: ModeRational})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != 0.5 || value.Type != ModeRational || value.Numerator != "1" || value.Denominator != "2" {
+		t.Fatalf("unexpected result %v, %+v", result, value)
+	}
+}
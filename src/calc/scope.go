@@ -0,0 +1,217 @@
+package calc
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Scope holds user-defined functions that expressions evaluated in it can
+// call, and variables and datasets they can read. Functions are kept as
+// their definitions, each parsed in the syntax it is written in, so
+// "sq(x) = x^2" squares in integer mode too, where ^ in expressions means
+// exclusive or. Variables are kept as expressions and datasets as number
+// literals, both parsed for the mode of each evaluation, since only some
+// modes can represent a value like 1/3 or 0.1 exactly. The scope also
+// selects the dialect expressions and variables are written in, whether
+// they are parsed leniently and the locale of the numbers in expressions.
+// The zero value and a nil *Scope are empty and parse canonical literals
+// strictly in the programming dialect.
+type Scope struct {
+	definitions map[string]Definition
+	variables   map[string]string
+	datasets    map[string][]string
+	dialect     Dialect
+	lenient     bool
+	locale      Locale
+}
+
+// A Definition is a function definition, such as "f(x) = x^2 + 1", with
+// the options selecting the syntax it is written in: Mode, which decides
+// whether ^ is exclusive or and which literals there are, Dialect,
+// Lenient and Locale. The zero options select the syntax of float mode.
+// Wherever the function is called, its body keeps the meaning it has in
+// that syntax and is evaluated in the mode of the call, so a function
+// using units fails outside units mode instead of meaning something else.
+type Definition struct {
+	Definition string  `json:"definition"`
+	Mode       Mode    `json:"mode,omitempty"`
+	Dialect    Dialect `json:"dialect,omitempty"`
+	Lenient    bool    `json:"lenient,omitempty"`
+	Locale     Locale  `json:"locale,omitempty"`
+}
+
+// Parse checks that d defines a single function in its syntax and returns
+// its name.
+func (d Definition) Parse() (string, error) {
+	if err := (Options{Mode: d.Mode, Dialect: d.Dialect, Locale: d.Locale}).Validate(); err != nil {
+		return "", err
+	}
+	f, err := parseDefinition(d.Definition, d.syntax())
+	if err != nil {
+		return "", err
+	}
+	return f.name, nil
+}
+
+// syntax returns the syntax d is written in.
+func (d Definition) syntax() syntax {
+	return syntax{
+		xorCaret: d.Mode == ModeInteger,
+		units:    d.Mode == ModeUnits,
+		time:     d.Mode == ModeTime,
+		arrays:   d.Mode == ModeMatrix,
+		percent:  d.Dialect == DialectDesk,
+		lenient:  d.Lenient,
+		locale:   d.Locale,
+	}
+}
+
+func NewScope() *Scope {
+	return &Scope{definitions: make(map[string]Definition)}
+}
+
+// NewScopeFrom returns a scope holding definitions. Later definitions of
+// the same name replace earlier ones.
+func NewScopeFrom(definitions []Definition) (*Scope, error) {
+	s := NewScope()
+	for _, d := range definitions {
+		if _, err := s.Add(d); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// ParseDefinition checks that definition defines a single function in the
+// syntax of float mode and returns its name.
+func ParseDefinition(definition string) (string, error) {
+	return Definition{Definition: definition}.Parse()
+}
+
+func parseDefinition(definition string, s syntax) (*defineNode, error) {
+	n, err := parse(definition, s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid definition %q: %w", definition, err)
+	}
+	f, ok := n.(*defineNode)
+	if !ok {
+		return nil, fmt.Errorf("not a function definition: %q", definition)
+	}
+	return f, nil
+}
+
+// Define adds the function defined by definition, written in the syntax
+// of float mode, to s, replacing any function of the same name, and
+// returns its name.
+func (s *Scope) Define(definition string) (string, error) {
+	return s.Add(Definition{Definition: definition})
+}
+
+// Add adds the function defined by d to s, replacing any function of the
+// same name, and returns its name.
+func (s *Scope) Add(d Definition) (string, error) {
+	name, err := d.Parse()
+	if err != nil {
+		return "", err
+	}
+	if s.definitions == nil {
+		s.definitions = make(map[string]Definition)
+	}
+	s.definitions[name] = d
+	return name, nil
+}
+
+// Set binds the variable name to the value of expression, which is
+// evaluated in the mode of each evaluation without access to the scope.
+func (s *Scope) Set(name, expression string) {
+	if s.variables == nil {
+		s.variables = make(map[string]string)
+	}
+	s.variables[name] = expression
+}
+
+// SetDataset binds the dataset name, which expressions reference as
+// #name, to values, which are number literals such as "-1.5" or "2e3".
+func (s *Scope) SetDataset(name string, values []string) {
+	if s.datasets == nil {
+		s.datasets = make(map[string][]string)
+	}
+	s.datasets[name] = values
+}
+
+// SetDialect selects the dialect expressions evaluated in s are written
+// in.
+func (s *Scope) SetDialect(dialect Dialect) {
+	s.dialect = dialect
+}
+
+// SetLenient selects lenient parsing, which accepts implicit
+// multiplication and Unicode operators, for expressions evaluated in s.
+func (s *Scope) SetLenient(lenient bool) {
+	s.lenient = lenient
+}
+
+// SetLocale selects the locale of the numbers in expressions evaluated in
+// s. Variables are canonical literals in every locale, and definitions
+// are written in their own.
+func (s *Scope) SetLocale(locale Locale) {
+	s.locale = locale
+}
+
+// Definitions returns the definitions in s ordered by function name.
+func (s *Scope) Definitions() []Definition {
+	if s == nil {
+		return nil
+	}
+	names := make([]string, 0, len(s.definitions))
+	for name := range s.definitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	definitions := make([]Definition, len(names))
+	for i, name := range names {
+		definitions[i] = s.definitions[name]
+	}
+	return definitions
+}
+
+// loadScope parses the definitions in s into the functions of e and
+// evaluates its variables and datasets into the variables and datasets of
+// e.
+func loadScope[T any](s *Scope, e *env[T]) error {
+	if s == nil {
+		return nil
+	}
+	for _, d := range s.definitions {
+		f, err := parseDefinition(d.Definition, d.syntax())
+		if err != nil {
+			return err
+		}
+		e.functions[f.name] = f
+	}
+	variables := syntaxOf(e.d, s)
+	variables.locale = ""
+	for name, expression := range s.variables {
+		n, err := parse(expression, variables)
+		if err != nil {
+			return fmt.Errorf("invalid value of %s: %w", name, err)
+		}
+		v, err := evaluate(n, newEnv(e.d))
+		if err != nil {
+			return fmt.Errorf("invalid value of %s: %w", name, err)
+		}
+		e.vars[name] = v
+	}
+	for name, literals := range s.datasets {
+		values := make([]T, len(literals))
+		for i, l := range literals {
+			v, err := literal(e.d, l)
+			if err != nil {
+				return fmt.Errorf("invalid value of #%s: %w", name, err)
+			}
+			values[i] = v
+		}
+		e.datasets["#"+name] = values
+	}
+	return nil
+}
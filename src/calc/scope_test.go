@@ -0,0 +1,200 @@
+package calc
+
+import (
+	"testing"
+)
+
+func TestPrograms(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   float64
+		expectErr  bool
+	}{
+		{"f(x) = x^2 + 1; a = f(3); a * 2", 20, false},
+		{"a = 2; b = a ** 3; b - a", 6, false},
+		{"a = 5", 5, false},
+		{"a = 1; a = a + 1; a", 2, false},
+		{"1; 2;", 2, false},
+		{"k = 3; scale(x) = k * x; scale(2)", 6, false},
+		{"fact(n) = n <= 1 ? 1 : n * fact(n - 1); fact(10)", 3628800, false},
+		{"hyp(a, b) = sqrt(a^2 + b^2); hyp(3, 4)", 5, false},
+		{"x = 10; f(x) = x + 1; f(1) + x", 12, false},
+		{"-2^2", -4, false},
+		{"2^3^2", 512, false},
+		{"2**-1", 0.5, false},
+		{"f(x) = x + 1", 0, true},
+		{"f(x) = x; f(1, 2)", 0, true},
+		{"f(x, x) = x; 1", 0, true},
+		{"f(1) = 2; 1", 0, true},
+		{"1 + 2 = 3", 0, true},
+		{"loop(n) = loop(n + 1); loop(0)", 0, true},
+		{"a = 1; b", 0, true},
+		{";", 0, true},
+		{"1 2", 0, true},
+	}
+
+	calculator := NewBasicCalculator()
+	for _, tc := range testCases {
+		result, err := calculator.Calculate(tc.expression)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("expected error for expression %q, got none", tc.expression)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("did not expect error for expression %q, got %v", tc.expression, err)
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("expected %v for expression %q, got %v", tc.expected, tc.expression, result)
+		}
+	}
+}
+
+func TestScope(t *testing.T) {
+	scope := NewScope()
+	if name, err := scope.Define("sq(x) = x^2"); err != nil || name != "sq" {
+		t.Fatalf("unexpected define result %q, %v", name, err)
+	}
+	if _, err := scope.Define("sq(x) = x * x; 1"); err == nil {
+		t.Error("expected error defining a program")
+	}
+	if _, err := scope.Define("x + 1"); err == nil {
+		t.Error("expected error defining an expression")
+	}
+
+	result, err := (&BasicCalculator{Scope: scope}).Calculate("sq(3) + 1")
+	if err != nil || result != 10 {
+		t.Errorf("unexpected float result %v, %v", result, err)
+	}
+	// In integer mode, where ^ in expressions is xor, the definition
+	// keeps its meaning.
+	n, err := (&IntegerCalculator{Scope: scope}).Evaluate("sq(3) ^ 1")
+	if err != nil || n != 8 {
+		t.Errorf("unexpected integer result %v, %v", n, err)
+	}
+	if _, err := scope.Add(Definition{Definition: "flip(x) = x ^ 1", Mode: ModeInteger}); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := (&IntegerCalculator{Scope: scope}).Evaluate("flip(3)"); err != nil || n != 2 {
+		t.Errorf("unexpected integer result %v, %v", n, err)
+	}
+	if _, err := (&BasicCalculator{Scope: scope}).Calculate("flip(3)"); err == nil {
+		t.Error("expected xor to fail in float mode")
+	}
+
+	result, value, err := Evaluate("area(2)", Options{Mode: ModeRational, Functions: []string{"area(r) = 3 * r^2 / 4"}})
+	if err != nil || result != 3 || value.Numerator != "3" {
+		t.Errorf("unexpected rational result %v, %+v, %v", result, value, err)
+	}
+	if _, _, err := Evaluate("1", Options{Functions: []string{"f(x) ="}}); err == nil {
+		t.Error("expected error for malformed function")
+	}
+	// Definitions are parsed in their own syntax, and Functions in that of
+	// the expression replace them.
+	definitions := []Definition{
+		{Definition: "ke(m, v) = m v^2 / 2", Mode: ModeUnits},
+		{Definition: "speed(d) = d / 2 h", Mode: ModeUnits},
+		{Definition: "g(x; y) = x * 1,5 + y", Locale: LocaleGerman},
+	}
+	if result, _, err := Evaluate("ke(2, 3) + g(2, 1)", Options{Definitions: definitions}); err != nil || result != 13 {
+		t.Errorf("unexpected float result %v, %v", result, err)
+	}
+	if _, value, err := Evaluate("ke(2 kg, 3 m/s)", Options{Mode: ModeUnits, Definitions: definitions}); err != nil || value.Literal() != "9 kg*m^2/s^2" {
+		t.Errorf("unexpected units result %+v, %v", value, err)
+	}
+	if _, _, err := Evaluate("speed(10)", Options{Definitions: definitions}); err == nil {
+		t.Error("expected a function using units to fail in float mode")
+	}
+	if result, _, err := Evaluate("ke(2, 3)", Options{Definitions: definitions, Functions: []string{"ke(x, y) = x * y"}}); err != nil || result != 6 {
+		t.Errorf("expected Functions to replace ke, got %v, %v", result, err)
+	}
+	if _, _, err := Evaluate("1", Options{Functions: []string{"ke(m, v) = m v^2 / 2"}}); err == nil {
+		t.Error("expected Functions in the units syntax to be rejected in float mode")
+	}
+	if _, _, err := Evaluate("1", Options{Definitions: []Definition{{Definition: "f(x) = x", Mode: "abacus"}}}); err == nil {
+		t.Error("expected a definition in an unknown mode to be rejected")
+	}
+}
+
+func TestPowerInExactModes(t *testing.T) {
+	d, err := NewDecimalCalculator(0, RoundHalfEven).Evaluate("1.1^2 + 2^-2")
+	if err != nil || d.String() != "1.46" {
+		t.Errorf("unexpected decimal result %v, %v", d, err)
+	}
+	if _, err := NewDecimalCalculator(0, RoundHalfEven).Evaluate("2^0.5"); err == nil {
+		t.Error("expected error for fractional decimal exponent")
+	}
+	r, err := NewRationalCalculator(0).Evaluate("(2/3)^3")
+	if err != nil || r.RatString() != "8/27" {
+		t.Errorf("unexpected rational result %v, %v", r, err)
+	}
+	if _, err := NewRationalCalculator(0).Evaluate("10^10000000"); err == nil {
+		t.Error("expected error for oversized rational power")
+	}
+	n, err := NewIntegerCalculator().Evaluate("3 ** 4 ^ 1")
+	if err != nil || n != 80 {
+		t.Errorf("unexpected integer result %v, %v", n, err)
+	}
+	if _, err := NewIntegerCalculator().Evaluate("2 ** 63"); err == nil {
+		t.Error("expected overflow for 2 ** 63")
+	}
+	z, err := NewComplexCalculator().Evaluate("i^2")
+	if err != nil || real(z) != -1 {
+		t.Errorf("unexpected complex result %v, %v", z, err)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	refs, err := References("@revenue / @cost + $12 * @cost")
+	if err != nil || len(refs) != 3 || refs[0] != "@revenue" || refs[1] != "@cost" || refs[2] != "$12" {
+		t.Fatalf("unexpected references %v, %v", refs, err)
+	}
+	refs, err = References("@deadline - 2026-10-18T09:30 > 90d")
+	if err != nil || len(refs) != 1 || refs[0] != "@deadline" {
+		t.Fatalf("expected references next to time literals, got %v, %v", refs, err)
+	}
+	refs, err = References("[[1, 2], [3, 4]] * @v .* [$3, 1]")
+	if err != nil || len(refs) != 2 || refs[0] != "@v" || refs[1] != "$3" {
+		t.Fatalf("expected references next to array literals, got %v, %v", refs, err)
+	}
+	for _, expression := range []string{"$", "$x", "$12a", "@", "@1x", "2 * @"} {
+		if _, err := References(expression); err == nil {
+			t.Errorf("expected error for expression %q", expression)
+		}
+	}
+	for _, expression := range []string{"$1 = 2; $1", "@a(x) = x; 1"} {
+		if _, err := NewBasicCalculator().Calculate(expression); err == nil {
+			t.Errorf("expected error assigning in %q", expression)
+		}
+	}
+}
+
+func TestInputs(t *testing.T) {
+	re, im := 3.0, -4.0
+	values := []*Value{
+		{Type: ModeRational, Numerator: "-1", Denominator: "3"},
+		{Type: ModeComplex, Re: &re, Im: &im},
+		{Type: ModeDecimal, Decimal: "0.1"},
+		{Type: ModeInteger, Integer: "42"},
+	}
+	expressions := []string{"-1/3", "3-4i", "0.1", "42"}
+	for i, v := range values {
+		if got := v.Literal(); got != expressions[i] {
+			t.Errorf("expected %q, got %q", expressions[i], got)
+		}
+	}
+
+	_, value, err := Evaluate("$1 * 3 + @x", Options{Mode: ModeRational, Inputs: map[string]string{"$1": "-1/3", "@x": "2"}})
+	if err != nil || value.Numerator != "1" || value.Denominator != "1" {
+		t.Errorf("unexpected rational result %+v, %v", value, err)
+	}
+	result, _, err := Evaluate("@big / 2", Options{Inputs: map[string]string{"@big": FormatFloat(1e300)}})
+	if err != nil || result != 5e299 {
+		t.Errorf("unexpected float result %v, %v", result, err)
+	}
+	if _, _, err := Evaluate("$2 + 1", Options{}); err == nil {
+		t.Error("expected error for unbound reference")
+	}
+}
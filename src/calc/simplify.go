@@ -0,0 +1,498 @@
+package calc
+
+import (
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// Simplify returns expression in a canonical form: constant
+// subexpressions are folded, products of sums are expanded, like terms and
+// like factors are combined, and terms and factors are put in a fixed
+// order. Equivalent polynomials therefore simplify to the same string, so
+// "(x+1)^2 - 2*x" and "1 + x*x" both become "x^2 + 1". The result is
+// printed with as few parentheses as possible.
+func Simplify(expression string) (string, error) {
+	return simplifyWith(expression, syntax{})
+}
+
+// simplifyWith is Simplify for an expression written in syntax s.
+func simplifyWith(expression string, s syntax) (string, error) {
+	n, err := parseExpression(expression, s)
+	if err != nil {
+		return "", err
+	}
+	return format(normalize(n)), nil
+}
+
+// maxTerms bounds the number of terms products and powers of sums are
+// expanded into; larger ones are kept as written.
+const maxTerms = 64
+
+// maxExpandedPower bounds the powers of sums that are expanded.
+const maxExpandedPower = 8
+
+// A polynomial is a sum of terms, each a rational coefficient times a
+// product of factors. Anything that is not a sum, product, quotient or
+// power, such as a function call, is a factor of its own, so every
+// expression has a polynomial form.
+type polynomial []term
+
+type term struct {
+	coef    *big.Rat
+	factors []factor
+}
+
+// factor is base^exp, with base compared by its formatted key.
+type factor struct {
+	base node
+	key  string
+	exp  *big.Rat
+}
+
+func normalize(n node) node {
+	return toPolynomial(n).node()
+}
+
+func constantPolynomial(r *big.Rat) polynomial {
+	if r.Sign() == 0 {
+		return nil
+	}
+	return polynomial{{coef: r}}
+}
+
+func atomPolynomial(n node) polynomial {
+	return polynomial{{coef: big.NewRat(1, 1), factors: []factor{newFactor(n, big.NewRat(1, 1))}}}
+}
+
+// constant returns the value of p if it has no factors.
+func (p polynomial) constant() (*big.Rat, bool) {
+	switch {
+	case len(p) == 0:
+		return new(big.Rat), true
+	case len(p) == 1 && len(p[0].factors) == 0:
+		return p[0].coef, true
+	}
+	return nil, false
+}
+
+func toPolynomial(n node) polynomial {
+	switch n := n.(type) {
+	case *numberNode:
+		if r, ok := constantValue(n); ok {
+			return constantPolynomial(r)
+		}
+	case *unaryNode:
+		x := toPolynomial(n.x)
+		switch n.op {
+		case "+":
+			return x
+		case "-":
+			return x.scale(big.NewRat(-1, 1))
+		case "!":
+			if c, ok := x.constant(); ok {
+				return constantPolynomial(boolRat(c.Sign() == 0))
+			}
+		}
+		return atomPolynomial(&unaryNode{op: n.op, x: x.node()})
+	case *binaryNode:
+		return binaryPolynomial(n)
+	case *logicalNode:
+		x, y := toPolynomial(n.x), toPolynomial(n.y)
+		a, xConst := x.constant()
+		b, yConst := y.constant()
+		if xConst && (a.Sign() != 0) == (n.op == "||") {
+			return constantPolynomial(boolRat(a.Sign() != 0))
+		}
+		if xConst && yConst {
+			return constantPolynomial(boolRat(b.Sign() != 0))
+		}
+		return atomPolynomial(&logicalNode{op: n.op, x: x.node(), y: y.node()})
+	case *conditionalNode:
+		cond := toPolynomial(n.cond)
+		if c, ok := cond.constant(); ok {
+			if c.Sign() != 0 {
+				return toPolynomial(n.then)
+			}
+			return toPolynomial(n.otherwise)
+		}
+		return atomPolynomial(&conditionalNode{cond: cond.node(), then: normalize(n.then), otherwise: normalize(n.otherwise)})
+	case *callNode:
+		args := make([]node, len(n.args))
+		for i, arg := range n.args {
+			args[i] = normalize(arg)
+		}
+		return atomPolynomial(&callNode{name: n.name, args: args})
+	}
+	return atomPolynomial(n)
+}
+
+func boolRat(b bool) *big.Rat {
+	if b {
+		return big.NewRat(1, 1)
+	}
+	return new(big.Rat)
+}
+
+func binaryPolynomial(n *binaryNode) polynomial {
+	x, y := toPolynomial(n.x), toPolynomial(n.y)
+	switch n.op {
+	case "+":
+		return x.add(y)
+	case "-":
+		return x.add(y.scale(big.NewRat(-1, 1)))
+	case "*":
+		if product, ok := x.mul(y); ok {
+			return product
+		}
+		return polynomial{x.term().mul(y.term())}.combine()
+	case "/":
+		if c, ok := y.constant(); ok && c.Sign() == 0 {
+			break
+		}
+		if inverse, ok := y.pow(big.NewRat(-1, 1)); ok {
+			if quotient, ok := x.mul(inverse); ok {
+				return quotient
+			}
+		}
+		return polynomial{x.term().mul(reciprocal(y.node()))}.combine()
+	case "**":
+		exp, ok := y.constant()
+		if !ok {
+			break
+		}
+		if power, ok := x.pow(exp); ok {
+			return power
+		}
+		if c, ok := x.constant(); ok && c.Sign() == 0 {
+			break
+		}
+		return polynomial{{coef: big.NewRat(1, 1), factors: []factor{newFactor(x.node(), exp)}}}.combine()
+	default:
+		if comparisonOperators[n.op] {
+			a, xConst := x.constant()
+			b, yConst := y.constant()
+			if xConst && yConst {
+				v, _ := compareValues(n.op, a, b, rationalDomain{})
+				return constantPolynomial(v)
+			}
+		}
+	}
+	return atomPolynomial(&binaryNode{op: n.op, x: x.node(), y: y.node()})
+}
+
+func (p polynomial) scale(r *big.Rat) polynomial {
+	result := make(polynomial, 0, len(p))
+	for _, t := range p {
+		result = append(result, term{coef: new(big.Rat).Mul(t.coef, r), factors: t.factors})
+	}
+	return result.combine()
+}
+
+func (p polynomial) add(q polynomial) polynomial {
+	return append(append(polynomial{}, p...), q...).combine()
+}
+
+// mul expands p*q, or reports false if the product has too many terms.
+// A sum is not distributed over a quotient by a sum either, so
+// (x + 1)/(x - 1) stays as written and 1/(x + 1)*(x + 1) cancels.
+func (p polynomial) mul(q polynomial) (polynomial, bool) {
+	if len(p)*len(q) > maxTerms || len(p) > 1 && q.quotient() || len(q) > 1 && p.quotient() {
+		return nil, false
+	}
+	var result polynomial
+	for _, s := range p {
+		for _, t := range q {
+			result = append(result, s.mul(t))
+		}
+	}
+	return result.combine(), true
+}
+
+// quotient reports whether p is a single term divided by a sum.
+func (p polynomial) quotient() bool {
+	if len(p) != 1 {
+		return false
+	}
+	for _, f := range p[0].factors {
+		if b, ok := f.base.(*binaryNode); ok && f.exp.Sign() < 0 && (b.op == "+" || b.op == "-") {
+			return true
+		}
+	}
+	return false
+}
+
+// term returns p as a single term, with a sum as a factor of its own.
+func (p polynomial) term() term {
+	switch len(p) {
+	case 0:
+		return term{coef: new(big.Rat)}
+	case 1:
+		return p[0]
+	}
+	return term{coef: big.NewRat(1, 1), factors: []factor{newFactor(p.node(), big.NewRat(1, 1))}}
+}
+
+func reciprocal(n node) term {
+	return term{coef: big.NewRat(1, 1), factors: []factor{newFactor(n, big.NewRat(-1, 1))}}
+}
+
+func newFactor(base node, exp *big.Rat) factor {
+	return factor{base: base, key: format(base), exp: exp}
+}
+
+// pow raises p to the constant power exp, or reports false if the result
+// is not a polynomial of reasonable size: a sum can only be raised to a
+// small natural power, and a term to a fractional power only if its
+// coefficient is 1 and its factors are rootable.
+func (p polynomial) pow(exp *big.Rat) (polynomial, bool) {
+	if exp.Sign() == 0 {
+		return constantPolynomial(big.NewRat(1, 1)), true
+	}
+	switch len(p) {
+	case 0:
+		if exp.Sign() > 0 {
+			return nil, true
+		}
+		return nil, false
+	case 1:
+		t := p[0]
+		coef := t.coef
+		if exp.IsInt() {
+			if exp.Num().CmpAbs(big.NewInt(maxFoldedExponent)) > 0 && !isUnit(coef) {
+				return nil, false
+			}
+			var err error
+			if coef, err = ratPower(coef, exp); err != nil {
+				return nil, false
+			}
+		} else if coef.Cmp(big.NewRat(1, 1)) != 0 || !rootable(t.factors) {
+			return nil, false
+		}
+		factors := make([]factor, len(t.factors))
+		for i, f := range t.factors {
+			factors[i] = factor{base: f.base, key: f.key, exp: new(big.Rat).Mul(f.exp, exp)}
+		}
+		return polynomial{{coef: coef, factors: factors}}.combine(), true
+	}
+	if !exp.IsInt() || exp.Sign() < 0 || exp.Num().Cmp(big.NewInt(maxExpandedPower)) > 0 {
+		return nil, false
+	}
+	result := constantPolynomial(big.NewRat(1, 1))
+	for i := int64(0); i < exp.Num().Int64(); i++ {
+		var ok bool
+		if result, ok = result.mul(p); !ok {
+			return nil, false
+		}
+	}
+	return result, true
+}
+
+// rootable reports whether a product of factors can be raised to a
+// fractional power factor by factor. That holds for factors with
+// non-negative numbers as bases, but of the others there may be only one,
+// with exponent 1 or -1: (x^2)^0.5 is |x| rather than x, and (x*y)^0.5 is
+// real where x^0.5*y^0.5 is not if x and y are both negative.
+func rootable(factors []factor) bool {
+	variable := false
+	for _, f := range factors {
+		if r, ok := constantValue(f.base); ok && r.Sign() >= 0 {
+			continue
+		}
+		if variable || !isUnit(f.exp) {
+			return false
+		}
+		variable = true
+	}
+	return true
+}
+
+func isUnit(r *big.Rat) bool {
+	return r.IsInt() && r.Num().CmpAbs(big.NewInt(1)) == 0
+}
+
+func (s term) mul(t term) term {
+	factors := append(append([]factor{}, s.factors...), t.factors...)
+	return term{coef: new(big.Rat).Mul(s.coef, t.coef), factors: factors}
+}
+
+// normalized merges like factors, folds powers of numbers with integer
+// exponents into the coefficient, and sorts the factors.
+func (t term) normalized() term {
+	coef := new(big.Rat).Set(t.coef)
+	exps := make(map[string]*big.Rat)
+	bases := make(map[string]node)
+	for _, f := range t.factors {
+		if e, ok := exps[f.key]; ok {
+			e.Add(e, f.exp)
+			continue
+		}
+		exps[f.key] = new(big.Rat).Set(f.exp)
+		bases[f.key] = f.base
+	}
+	var factors []factor
+	for key, exp := range exps {
+		if exp.Sign() == 0 {
+			continue
+		}
+		if r, ok := constantValue(bases[key]); ok && exp.IsInt() {
+			if power, err := ratPower(r, exp); err == nil && exp.Num().CmpAbs(big.NewInt(maxFoldedExponent)) <= 0 {
+				coef.Mul(coef, power)
+				continue
+			}
+		}
+		factors = append(factors, factor{base: bases[key], key: key, exp: exp})
+	}
+	sort.Slice(factors, func(i, j int) bool { return factorLess(factors[i], factors[j]) })
+	return term{coef: coef, factors: factors}
+}
+
+// factorLess orders numbers first, then variables, then everything else,
+// each by key.
+func factorLess(a, b factor) bool {
+	if ra, rb := factorRank(a), factorRank(b); ra != rb {
+		return ra < rb
+	}
+	return a.key < b.key
+}
+
+func factorRank(f factor) int {
+	switch f.base.(type) {
+	case *numberNode:
+		return 0
+	case *identNode:
+		return 1
+	default:
+		return 2
+	}
+}
+
+func (t term) degree() *big.Rat {
+	d := new(big.Rat)
+	for _, f := range t.factors {
+		d.Add(d, f.exp)
+	}
+	return d
+}
+
+func (t term) monomial() string {
+	parts := make([]string, len(t.factors))
+	for i, f := range t.factors {
+		parts[i] = f.key + "^" + f.exp.RatString()
+	}
+	return strings.Join(parts, "*")
+}
+
+// termLess orders terms by descending degree, then lexicographically by
+// their factors, so x^2 + x*y + y^2 + x + 1 is in order.
+func termLess(s, t term) bool {
+	if c := s.degree().Cmp(t.degree()); c != 0 {
+		return c > 0
+	}
+	for i := 0; i < len(s.factors) && i < len(t.factors); i++ {
+		a, b := s.factors[i], t.factors[i]
+		if a.key != b.key {
+			return factorLess(a, b)
+		}
+		if c := a.exp.Cmp(b.exp); c != 0 {
+			return c > 0
+		}
+	}
+	return len(s.factors) > len(t.factors)
+}
+
+// combine normalizes the terms of p, adds up like terms and sorts them.
+func (p polynomial) combine() polynomial {
+	var result polynomial
+	index := make(map[string]int)
+	for _, t := range p {
+		t = t.normalized()
+		key := t.monomial()
+		if i, ok := index[key]; ok {
+			result[i].coef = new(big.Rat).Add(result[i].coef, t.coef)
+			continue
+		}
+		index[key] = len(result)
+		result = append(result, t)
+	}
+	nonzero := result[:0]
+	for _, t := range result {
+		if t.coef.Sign() != 0 {
+			nonzero = append(nonzero, t)
+		}
+	}
+	sort.SliceStable(nonzero, func(i, j int) bool { return termLess(nonzero[i], nonzero[j]) })
+	return nonzero
+}
+
+// node converts p back to a syntax tree, with negative terms subtracted.
+func (p polynomial) node() node {
+	if len(p) == 0 {
+		return number("0")
+	}
+	var sum node
+	for _, t := range p {
+		negative := t.coef.Sign() < 0
+		n := t.node(new(big.Rat).Abs(t.coef))
+		switch {
+		case sum == nil && negative:
+			sum = neg(n)
+		case sum == nil:
+			sum = n
+		case negative:
+			sum = sub(sum, n)
+		default:
+			sum = add(sum, n)
+		}
+	}
+	return sum
+}
+
+// node writes the term with coefficient coef as a product, moving
+// factors with negative exponents and the denominator of a coefficient
+// without a finite decimal expansion below a division.
+func (t term) node(coef *big.Rat) node {
+	var numerator, denominator []node
+	if n, ok := ratNode(coef); ok {
+		if !coef.IsInt() || coef.Num().Cmp(big.NewInt(1)) != 0 || len(t.factors) == 0 {
+			numerator = append(numerator, n)
+		}
+	} else {
+		if coef.Num().Cmp(big.NewInt(1)) != 0 {
+			numerator = append(numerator, number(coef.Num().String()))
+		}
+		denominator = append(denominator, number(coef.Denom().String()))
+	}
+	for _, f := range t.factors {
+		if f.exp.Sign() < 0 {
+			denominator = append(denominator, powerNode(f.base, new(big.Rat).Neg(f.exp)))
+		} else {
+			numerator = append(numerator, powerNode(f.base, f.exp))
+		}
+	}
+	if len(numerator) == 0 {
+		numerator = append(numerator, number("1"))
+	}
+	if len(denominator) == 0 {
+		return product(numerator)
+	}
+	return div(product(numerator), product(denominator))
+}
+
+func product(factors []node) node {
+	n := factors[0]
+	for _, f := range factors[1:] {
+		n = mul(n, f)
+	}
+	return n
+}
+
+func powerNode(base node, exp *big.Rat) node {
+	if exp.Cmp(big.NewRat(1, 1)) == 0 {
+		return base
+	}
+	if n, ok := ratNode(exp); ok {
+		return pow(base, n)
+	}
+	return pow(base, div(number(exp.Num().String()), number(exp.Denom().String())))
+}
@@ -0,0 +1,103 @@
+package calc
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSimplify(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   string
+		expectErr  bool
+	}{
+		{"x*1 + 0", "x", false},
+		{"x - x", "0", false},
+		{"x*0 + y", "y", false},
+		{"2*3 + x", "x + 6", false},
+		{"y*x + x*y", "2*x*y", false},
+		{"x*x*x/x", "x^2", false},
+		{"(x + 1)^2 - 2*x", "x^2 + 1", false},
+		{"(x + y)^2", "x^2 + 2*x*y + y^2", false},
+		{"(x - 1)*(x + 1)", "x^2 - 1", false},
+		{"sin(x)*2*x", "2*x*sin(x)", false},
+		{"a - b - (a - b)", "0", false},
+		{"x/2", "0.5*x", false},
+		{"x/3*2", "2*x/3", false},
+		{"x/y/z", "x/(y*z)", false},
+		{"x^-2", "1/x^2", false},
+		{"1/(x + 1)*(x + 1)", "1", false},
+		{"(a + b)/(c + d)", "(a + b)/(c + d)", false},
+		{"x^0.5*x^0.5", "x", false},
+		{"(x^2)^0.5", "(x^2)^0.5", false},
+		{"(x^2)^(1/2)", "(x^2)^0.5", false},
+		{"(x*y)^(1/2)", "(x*y)^0.5", false},
+		{"(x^0.5)^2", "x", false},
+		{"(4*x)^0.5", "(4*x)^0.5", false},
+		{"(1/x)^0.5", "1/x^0.5", false},
+		{"0.1*x + 0.2*x", "0.3*x", false},
+		{"-x*y", "-x*y", false},
+		{"1 - x", "-x + 1", false},
+		{"sin(x + x) + cos(0*y)", "cos(0) + sin(2*x)", false},
+		{"2 < 3 ? x : y", "x", false},
+		{"0 && x > 1", "0", false},
+		{"x > 1 && 1 < 2", "x > 1 && 1", false},
+		{"x", "x", false},
+		{"x/0", "x/0", false},
+		{"(x + 1)^100", "(x + 1)^100", false},
+		{"a = 1; a", "", true},
+		{"x +", "", true},
+	}
+
+	for _, tc := range testCases {
+		result, err := Simplify(tc.expression)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("expected error simplifying %q, got %q", tc.expression, result)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("did not expect error simplifying %q, got %v", tc.expression, err)
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("expected %q simplifying %q, got %q", tc.expected, tc.expression, result)
+		}
+	}
+}
+
+func TestSimplifyEquivalent(t *testing.T) {
+	scope := NewScope()
+	for name, value := range map[string]string{"x": "1.5", "y": "-2", "z": "3"} {
+		scope.Set(name, value)
+	}
+	calculator := &BasicCalculator{Scope: scope}
+	for _, expression := range []string{
+		"(x + y)^3 - x*(y - z)/z", "(x - 1)/(y + 2.5) + 1/(y + 2.5)",
+		"exp(x)*exp(x) - x^(1/3)*x", "x > y ? (x + 1)^2 : 0", "2^x*2^x/4",
+	} {
+		simplified, err := Simplify(expression)
+		if err != nil {
+			t.Fatalf("simplify %q: %v", expression, err)
+		}
+		expected, err := calculator.Calculate(expression)
+		if err != nil {
+			t.Fatalf("evaluate %q: %v", expression, err)
+		}
+		result, err := calculator.Calculate(simplified)
+		if err != nil {
+			t.Fatalf("evaluate %q: %v", simplified, err)
+		}
+		if math.Abs(result-expected) > 1e-9*math.Max(1, math.Abs(expected)) {
+			t.Errorf("%q simplified to %q: %v != %v", expression, simplified, result, expected)
+		}
+	}
+}
+
+func TestEvaluateSimplify(t *testing.T) {
+	_, value, err := Evaluate("x + x", Options{Operation: OperationSimplify})
+	if err != nil || value == nil || value.Type != TypeExpression || value.Expression != "2*x" {
+		t.Fatalf("unexpected simplification %+v, %v", value, err)
+	}
+}
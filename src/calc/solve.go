@@ -0,0 +1,273 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	// defaultSolveRange is the bound of the range Solve searches when
+	// Options.Lower and Options.Upper are zero.
+	defaultSolveRange = 100
+	// defaultTolerance and defaultMaxIterations apply when the options
+	// leave them zero.
+	defaultTolerance     = 1e-10
+	defaultMaxIterations = 100
+	// solveSamples is the number of intervals Solve splits the range into
+	// to locate roots.
+	solveSamples = 1000
+)
+
+// Solve returns the roots of equation, such as "x^3 - 2*x = 5", in
+// variable within [opts.Lower, opts.Upper], in ascending order. An
+// expression without "=" is solved for zero. Solve samples the range,
+// refines every sign change by Newton's method safeguarded by bisection,
+// and tries Newton's method from the samples where the sides of the
+// equation touch without crossing. Roots that do not converge within
+// opts.MaxIterations are dropped, so Solve may miss roots that are closer
+// together than the samples. An equation whose sides simplify to the same
+// expression, or are equal at every sample, has no roots to list and is
+// an error. Functions and inputs in opts are in scope.
+func Solve(equation, variable string, opts Options) ([]float64, error) {
+	opts.Operation, opts.Variable = OperationSolve, variable
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	n, err := parseEquation(equation, syntax{lenient: opts.Lenient})
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := toPolynomial(n).constant(); ok && c.Sign() == 0 {
+		return nil, fmt.Errorf("equation holds for every %s", variable)
+	}
+	scope, err := opts.scope()
+	if err != nil {
+		return nil, err
+	}
+	e := newEnv[float64](floatDomain{})
+	if err := loadScope(scope, e); err != nil {
+		return nil, err
+	}
+	s := &solver{
+		f: func(x float64) (float64, error) {
+			e.vars[variable] = x
+			return evaluate(n, e)
+		},
+		variable:      variable,
+		tolerance:     opts.Tolerance,
+		maxIterations: opts.MaxIterations,
+	}
+	if d, err := derive(n, variable); err == nil {
+		s.df = func(x float64) (float64, error) {
+			e.vars[variable] = x
+			return evaluate(d, e)
+		}
+	}
+	if s.tolerance == 0 {
+		s.tolerance = defaultTolerance
+	}
+	if s.maxIterations == 0 {
+		s.maxIterations = defaultMaxIterations
+	}
+	lower, upper := opts.Lower, opts.Upper
+	if lower == 0 && upper == 0 {
+		lower, upper = -defaultSolveRange, defaultSolveRange
+	}
+	return s.roots(lower, upper)
+}
+
+// parseEquation parses "lhs = rhs", written in syntax s, into lhs - rhs,
+// or an expression without "=" as is.
+func parseEquation(equation string, s syntax) (node, error) {
+	tokens, err := tokenize(equation, s)
+	if err != nil {
+		return nil, err
+	}
+	var equals []int
+	for _, tok := range tokens {
+		if tok.kind == tokenOperator && tok.text == "=" {
+			equals = append(equals, tok.pos)
+		}
+	}
+	switch len(equals) {
+	case 0:
+		return parseExpression(equation, s)
+	case 1:
+	default:
+		return nil, errors.New("an equation has exactly one \"=\"")
+	}
+	lhs, err := parseExpression(equation[:equals[0]], s)
+	if err != nil {
+		return nil, err
+	}
+	// Pad the right side so errors report positions in equation.
+	rhs, err := parseExpression(strings.Repeat(" ", equals[0]+1)+equation[equals[0]+1:], s)
+	if err != nil {
+		return nil, err
+	}
+	return sub(lhs, rhs), nil
+}
+
+// solver finds the roots of f, using its derivative df if it is known
+// and a difference quotient otherwise.
+type solver struct {
+	f, df         func(x float64) (float64, error)
+	variable      string
+	tolerance     float64
+	maxIterations int
+}
+
+func (s *solver) roots(lower, upper float64) ([]float64, error) {
+	var xs, ys [solveSamples + 1]float64
+	var firstErr error
+	defined := false
+	for i := range xs {
+		xs[i] = lower + (upper-lower)*float64(i)/solveSamples
+		y, err := s.f(xs[i])
+		switch {
+		case err != nil:
+			if firstErr == nil {
+				firstErr = err
+			}
+			y = math.NaN()
+		case math.IsInf(y, 0):
+			y = math.NaN()
+		default:
+			defined = true
+		}
+		ys[i] = y
+	}
+	if !defined {
+		return nil, firstErr
+	}
+	identity := true
+	for _, y := range ys {
+		// Undefined samples compare false and do not count.
+		if y != 0 && !math.IsNaN(y) {
+			identity = false
+			break
+		}
+	}
+	if identity {
+		return nil, fmt.Errorf("equation holds for every %s", s.variable)
+	}
+	var roots []float64
+	for i := range xs {
+		if ys[i] == 0 {
+			roots = append(roots, xs[i])
+			continue
+		}
+		if i > 0 && ys[i-1]*ys[i] < 0 {
+			if r, ok := s.bracketed(xs[i-1], xs[i], ys[i-1], ys[i]); ok {
+				roots = append(roots, r)
+			}
+		}
+		// A comparison with NaN is false, so undefined neighbors never
+		// make a touching point.
+		if i > 0 && i < solveSamples && ys[i-1]*ys[i] > 0 && ys[i]*ys[i+1] > 0 &&
+			math.Abs(ys[i]) <= math.Abs(ys[i-1]) && math.Abs(ys[i]) <= math.Abs(ys[i+1]) {
+			if r, ok := s.newton(xs[i], ys[i], xs[i-1], xs[i+1]); ok {
+				roots = append(roots, r)
+			}
+		}
+	}
+	sort.Float64s(roots)
+	unique := roots[:0]
+	for _, r := range roots {
+		if len(unique) == 0 || !s.close(unique[len(unique)-1], r, 10) {
+			unique = append(unique, r)
+		}
+	}
+	return unique, nil
+}
+
+// close reports whether x and y differ by at most factor times the
+// tolerance, relative to their magnitude if it exceeds 1.
+func (s *solver) close(x, y, factor float64) bool {
+	return math.Abs(x-y) <= factor*s.tolerance*math.Max(1, math.Abs(x))
+}
+
+// bracketed refines the root between a and b, where f has the values fa
+// and fb of opposite signs. It takes Newton steps while they stay inside
+// the bracket and bisects otherwise. It reports false if the iterations
+// run out or the sign change turns out to be a pole.
+func (s *solver) bracketed(a, b, fa, fb float64) (float64, bool) {
+	bound := math.Min(math.Abs(fa), math.Abs(fb))
+	x := (a + b) / 2
+	for i := 0; i < s.maxIterations; i++ {
+		fx, err := s.f(x)
+		if err != nil || math.IsNaN(fx) {
+			return 0, false
+		}
+		if fx == 0 {
+			return x, true
+		}
+		if (fx < 0) == (fa < 0) {
+			a, fa = x, fx
+		} else {
+			b = x
+		}
+		next := (a + b) / 2
+		if d, ok := s.slope(x); ok && d != 0 {
+			if newton := x - fx/d; newton >= a && newton <= b {
+				next = newton
+			}
+		}
+		if s.close(x, next, 1) {
+			return next, math.Abs(fx) < bound
+		}
+		x = next
+	}
+	return 0, false
+}
+
+// newton runs Newton's method from x, where f is fx, and reports whether
+// it converges to a root within [lower, upper].
+func (s *solver) newton(x, fx, lower, upper float64) (float64, bool) {
+	start := math.Abs(fx)
+	for i := 0; i < s.maxIterations; i++ {
+		d, ok := s.slope(x)
+		if !ok || d == 0 {
+			return 0, false
+		}
+		next := x - fx/d
+		if next < lower || next > upper {
+			return 0, false
+		}
+		if s.close(x, next, 1) {
+			return next, true
+		}
+		x = next
+		var err error
+		if fx, err = s.f(x); err != nil || math.IsNaN(fx) || math.Abs(fx) > start {
+			return 0, false
+		}
+		if fx == 0 {
+			return x, true
+		}
+	}
+	return 0, false
+}
+
+// slope returns the derivative of f at x.
+func (s *solver) slope(x float64) (float64, bool) {
+	if s.df != nil {
+		if d, err := s.df(x); err == nil && !math.IsNaN(d) && !math.IsInf(d, 0) {
+			return d, true
+		}
+	}
+	h := 1e-7 * math.Max(1, math.Abs(x))
+	above, err := s.f(x + h)
+	if err != nil {
+		return 0, false
+	}
+	below, err := s.f(x - h)
+	if err != nil {
+		return 0, false
+	}
+	d := (above - below) / (2 * h)
+	return d, !math.IsNaN(d) && !math.IsInf(d, 0)
+}
@@ -0,0 +1,78 @@
+package calc
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSolve(t *testing.T) {
+	testCases := []struct {
+		equation  string
+		opts      Options
+		expected  []float64
+		expectErr bool
+	}{
+		{"x^3 - 2*x = 5", Options{}, []float64{2.0945514815423265}, false},
+		{"x^2 = 2", Options{}, []float64{-math.Sqrt2, math.Sqrt2}, false},
+		{"x^3 - x", Options{}, []float64{-1, 0, 1}, false},
+		{"(x - 1)^2", Options{}, []float64{1}, false},
+		{"sin(x)", Options{Lower: 1, Upper: 7}, []float64{math.Pi, 2 * math.Pi}, false},
+		{"ln(x) = 1", Options{}, []float64{math.E}, false},
+		{"x^2 + 1", Options{}, nil, false},
+		{"1/x", Options{}, nil, false},
+		{"x^2 = 2", Options{Lower: 0, Upper: 1}, nil, false},
+		{"f(x) = 3", Options{Functions: []string{"f(t) = 2*t"}}, []float64{1.5}, false},
+		{"x = @a + 1", Options{Inputs: map[string]string{"@a": "6"}}, []float64{7}, false},
+		{"x^3 - 2*x = 5", Options{MaxIterations: 2}, nil, false},
+		{"x = x", Options{}, nil, true},
+		{"2*(x + 1) = 2*x + 2", Options{}, nil, true},
+		{"f(x) = 2*x", Options{Functions: []string{"f(t) = t + t"}}, nil, true},
+		{"x/x = 1", Options{}, nil, true},
+		{"x + y", Options{}, nil, true},
+		{"x = 1 = 2", Options{}, nil, true},
+		{"x = ", Options{}, nil, true},
+		{"x", Options{Lower: 1, Upper: -1}, nil, true},
+		{"x", Options{Mode: ModeRational}, nil, true},
+		{"x", Options{Tolerance: -1}, nil, true},
+	}
+
+	for _, tc := range testCases {
+		roots, err := Solve(tc.equation, "x", tc.opts)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("expected error solving %q, got %v", tc.equation, roots)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("did not expect error solving %q, got %v", tc.equation, err)
+			continue
+		}
+		if len(roots) != len(tc.expected) {
+			t.Errorf("expected roots %v of %q, got %v", tc.expected, tc.equation, roots)
+			continue
+		}
+		for i, root := range roots {
+			if math.Abs(root-tc.expected[i]) > 1e-9 {
+				t.Errorf("expected roots %v of %q, got %v", tc.expected, tc.equation, roots)
+				break
+			}
+		}
+	}
+}
+
+func TestEvaluateSolve(t *testing.T) {
+	_, value, err := Evaluate("x^2 = 4", Options{Operation: OperationSolve, Variable: "x"})
+	if err != nil || value == nil || value.Type != TypeRoots || len(value.Roots) != 2 || value.IsReal() {
+		t.Fatalf("unexpected roots %+v, %v", value, err)
+	}
+	if value.Literal() != "" || value.Float64() != -2 {
+		t.Errorf("unexpected literal %q or float %v of %v", value.Literal(), value.Float64(), value.Roots)
+	}
+	if _, _, err := Evaluate("x^2 = 4", Options{Operation: OperationSolve}); err == nil {
+		t.Error("expected error solving without a variable")
+	}
+	if _, _, err := Evaluate("x = x", Options{Operation: OperationSolve, Variable: "x"}); err == nil || err.Error() != "equation holds for every x" {
+		t.Errorf("expected an identity to be reported, got %v", err)
+	}
+}
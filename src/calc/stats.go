@@ -0,0 +1,327 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+)
+
+// statistics are the statistics functions, by their number of arguments.
+// Their first argument, and the second of the regression functions, is a
+// list: a list literal such as [1, 2, 3], a dataset #name or, in matrix
+// mode, a vector. They compute in the domain of the expression, so the
+// mean of [0.1, 0.2] is exactly 0.15 in decimal mode. sum with a single
+// argument is the sum of a list rather than a series.
+var statistics = map[string]int{
+	"count": 1, "sum": 1, "mean": 1, "median": 1, "variance": 1, "stdev": 1,
+	"percentile": 2, "slope": 2, "intercept": 2, "correlation": 2,
+}
+
+// evaluateStatistic evaluates a call of one of the statistics functions.
+// variance and stdev are those of a sample, percentile interpolates
+// linearly between the closest ranks, and slope, intercept and
+// correlation fit a least-squares line to points with the x values of
+// the first list and the y values of the second.
+func evaluateStatistic[T any](n *callNode, e *env[T]) (T, error) {
+	var zero T
+	if arity := statistics[n.name]; len(n.args) != arity {
+		return zero, fmt.Errorf("%s expects %d argument(s), got %d", n.name, arity, len(n.args))
+	}
+	xs, err := list(n.name, n.args[0], e)
+	if err != nil {
+		return zero, err
+	}
+	a := &arithmetic[T]{d: e.d}
+	switch n.name {
+	case "count":
+		return a.number(len(xs)), a.err
+	case "sum":
+		return a.sum(xs), a.err
+	case "mean":
+		return a.mean(n.name, xs), a.err
+	case "median":
+		return a.median(xs), a.err
+	case "variance":
+		return a.variance(n.name, xs), a.err
+	case "stdev":
+		return a.sqrt(n.name, a.variance(n.name, xs)), a.err
+	case "percentile":
+		p, err := evaluate(n.args[1], e)
+		if err != nil {
+			return zero, err
+		}
+		return a.percentile(xs, p), a.err
+	}
+	ys, err := list(n.name, n.args[1], e)
+	if err != nil {
+		return zero, err
+	}
+	return a.regression(n.name, xs, ys), a.err
+}
+
+// list evaluates arg of the statistics function f as a list.
+func list[T any](f string, arg node, e *env[T]) ([]T, error) {
+	switch arg := arg.(type) {
+	case *arrayNode:
+		values := make([]T, len(arg.elements))
+		for i, element := range arg.elements {
+			v, err := evaluate(element, e)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return values, nil
+	case *identNode:
+		if isDataset(arg.name) {
+			values, ok := e.global.datasets[arg.name]
+			if !ok {
+				return nil, fmt.Errorf("unknown dataset: %s", arg.name)
+			}
+			return values, nil
+		}
+	}
+	x, err := evaluate(arg, e)
+	if err != nil {
+		return nil, err
+	}
+	if l, ok := e.d.(listDomain[T]); ok {
+		if values, ok := l.elements(x); ok {
+			return values, nil
+		}
+	}
+	return nil, fmt.Errorf("%s expects a list, such as [1, 2, 3] or a dataset #name", f)
+}
+
+// arithmetic computes in a domain, keeping the first error: once an
+// operation has failed, the others return their first operand.
+type arithmetic[T any] struct {
+	d   domain[T]
+	err error
+}
+
+func (a *arithmetic[T]) op(op string, x, y T) T {
+	if a.err != nil {
+		return x
+	}
+	z, err := a.d.binary(op, x, y)
+	if err != nil {
+		a.err = err
+		return x
+	}
+	return z
+}
+
+func (a *arithmetic[T]) number(n int) T {
+	v, err := a.d.number(strconv.Itoa(n))
+	if a.err == nil {
+		a.err = err
+	}
+	return v
+}
+
+func (a *arithmetic[T]) compare(x, y T) int {
+	if a.err != nil {
+		return 0
+	}
+	c, err := a.d.compare(x, y)
+	a.err = err
+	return c
+}
+
+// sqrt returns the square root of x, which the statistics function f
+// needs: decimal and rational mode have no square roots.
+func (a *arithmetic[T]) sqrt(f string, x T) T {
+	if a.err != nil {
+		return x
+	}
+	v, err := a.d.call("sqrt", []T{x})
+	if err != nil {
+		a.err = fmt.Errorf("%s requires a square root: %w", f, err)
+	}
+	return v
+}
+
+// fail records err unless an operation has already failed.
+func (a *arithmetic[T]) fail(err error) {
+	if a.err == nil {
+		a.err = err
+	}
+}
+
+func (a *arithmetic[T]) sum(xs []T) T {
+	total := a.number(0)
+	for _, x := range xs {
+		total = a.op("+", total, x)
+	}
+	return total
+}
+
+func (a *arithmetic[T]) mean(f string, xs []T) T {
+	if len(xs) == 0 {
+		a.fail(fmt.Errorf("%s of an empty list", f))
+		return a.number(0)
+	}
+	return a.op("/", a.sum(xs), a.number(len(xs)))
+}
+
+// sorted returns xs in ascending order.
+func (a *arithmetic[T]) sorted(xs []T) []T {
+	xs = slices.Clone(xs)
+	slices.SortStableFunc(xs, a.compare)
+	return xs
+}
+
+func (a *arithmetic[T]) median(xs []T) T {
+	if len(xs) == 0 {
+		a.fail(errors.New("median of an empty list"))
+		return a.number(0)
+	}
+	xs = a.sorted(xs)
+	middle := len(xs) / 2
+	if len(xs)%2 == 1 {
+		return xs[middle]
+	}
+	return a.op("/", a.op("+", xs[middle-1], xs[middle]), a.number(2))
+}
+
+func (a *arithmetic[T]) variance(f string, xs []T) T {
+	if len(xs) < 2 {
+		a.fail(fmt.Errorf("%s requires at least 2 values, got %d", f, len(xs)))
+		return a.number(0)
+	}
+	m := a.mean(f, xs)
+	squares := a.number(0)
+	for _, x := range xs {
+		d := a.op("-", x, m)
+		squares = a.op("+", squares, a.op("*", d, d))
+	}
+	return a.op("/", squares, a.number(len(xs)-1))
+}
+
+// percentile returns the p-th percentile of xs, interpolating linearly
+// between the values whose ranks enclose p/100*(len(xs)-1).
+func (a *arithmetic[T]) percentile(xs []T, p T) T {
+	if len(xs) == 0 {
+		a.fail(errors.New("percentile of an empty list"))
+		return p
+	}
+	if a.compare(p, a.number(0)) < 0 || a.compare(p, a.number(100)) > 0 {
+		a.fail(errors.New("percentile must be between 0 and 100"))
+		return p
+	}
+	xs = a.sorted(xs)
+	rank := a.op("/", a.op("*", p, a.number(len(xs)-1)), a.number(100))
+	// The integer part of rank is the largest index not above it.
+	lo, hi := 0, len(xs)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if a.compare(a.number(mid), rank) <= 0 {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	i := lo
+	if i == len(xs)-1 {
+		return xs[i]
+	}
+	fraction := a.op("-", rank, a.number(i))
+	return a.op("+", xs[i], a.op("*", a.op("-", xs[i+1], xs[i]), fraction))
+}
+
+// regression returns the slope, intercept or correlation coefficient f
+// of the least-squares line through the points (xs[i], ys[i]).
+func (a *arithmetic[T]) regression(f string, xs, ys []T) T {
+	if len(xs) != len(ys) {
+		a.fail(fmt.Errorf("%s expects lists of the same length, got %d and %d", f, len(xs), len(ys)))
+		return a.number(0)
+	}
+	if len(xs) < 2 {
+		a.fail(fmt.Errorf("%s requires at least 2 points, got %d", f, len(xs)))
+		return a.number(0)
+	}
+	mx, my := a.mean(f, xs), a.mean(f, ys)
+	sxx, sxy, syy := a.number(0), a.number(0), a.number(0)
+	for i := range xs {
+		dx, dy := a.op("-", xs[i], mx), a.op("-", ys[i], my)
+		sxx = a.op("+", sxx, a.op("*", dx, dx))
+		sxy = a.op("+", sxy, a.op("*", dx, dy))
+		syy = a.op("+", syy, a.op("*", dy, dy))
+	}
+	if a.err == nil && !a.d.truth(sxx) {
+		a.fail(fmt.Errorf("%s is undefined when all x values are equal", f))
+		return sxx
+	}
+	switch f {
+	case "slope":
+		return a.op("/", sxy, sxx)
+	case "intercept":
+		return a.op("-", my, a.op("*", a.op("/", sxy, sxx), mx))
+	}
+	if a.err == nil && !a.d.truth(syy) {
+		a.fail(fmt.Errorf("%s is undefined when all y values are equal", f))
+		return syy
+	}
+	return a.op("/", sxy, a.sqrt(f, a.op("*", sxx, syy)))
+}
+
+// minPartitionValues is the smallest number of values PartitionDataset
+// gives a part of an aggregate.
+const minPartitionValues = 10000
+
+// DatasetPart is a part of an aggregate over a dataset: Expression
+// evaluated with the dataset restricted to Count values from Offset on.
+type DatasetPart struct {
+	Expression string
+	Offset     int
+	Count      int
+}
+
+// PartitionDataset splits expression, if it is a single call of sum,
+// count or mean of a dataset #name, into up to n parts over consecutive
+// slices of the dataset, so they can be evaluated separately, map-reduce
+// style. size returns the number of values of a dataset, or -1 if it
+// cannot be split. PartitionDataset returns the parts, the name of the
+// dataset and the operator, "+", that combines their results, or no parts
+// if expression cannot be split. The part of a mean is the sum of its
+// slice divided by the size of the whole dataset. Like Partition, it only
+// splits into parts of at least 10000 values, and not in integer mode or
+// for operations other than evaluation.
+func PartitionDataset(expression string, n int, size func(name string) int, opts Options) ([]DatasetPart, string, string) {
+	if n < 2 || opts.Mode == ModeInteger || opts.Operation != "" && opts.Operation != OperationEvaluate {
+		return nil, "", ""
+	}
+	node, err := parse(expression, syntax{})
+	if err != nil {
+		return nil, "", ""
+	}
+	c, ok := node.(*callNode)
+	if !ok || len(c.args) != 1 || c.name != "sum" && c.name != "count" && c.name != "mean" {
+		return nil, "", ""
+	}
+	ref, ok := c.args[0].(*identNode)
+	if !ok || !isDataset(ref.name) {
+		return nil, "", ""
+	}
+	if opts.defines(c.name) {
+		return nil, "", ""
+	}
+	name := ref.name[1:]
+	values := size(name)
+	n = min(n, values/minPartitionValues)
+	if n < 2 {
+		return nil, "", ""
+	}
+	part := expression
+	if c.name == "mean" {
+		part = opts.Locale.written(format(call("sum", ref)) + "/" + strconv.Itoa(values))
+	}
+	var parts []DatasetPart
+	chunk := (values + n - 1) / n
+	for offset := 0; offset < values; offset += chunk {
+		parts = append(parts, DatasetPart{Expression: part, Offset: offset, Count: min(chunk, values-offset)})
+	}
+	return parts, name, "+"
+}
@@ -0,0 +1,174 @@
+package calc
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestStatistics(t *testing.T) {
+	testCases := []struct {
+		expression string
+		mode       Mode
+		expected   string
+		expectErr  bool
+	}{
+		{"count([4, 8, 15])", "", "3", false},
+		{"sum([1, 2, 3.5])", "", "6.5", false},
+		{"mean([1, 2, 3, 4])", "", "2.5", false},
+		{"mean([0.1, 0.2])", ModeDecimal, "0.15", false},
+		{"mean([1, 2, 4])", ModeRational, "7/3", false},
+		{"median([3, 1, 2])", "", "2", false},
+		{"median([4, 1, 3, 2])", "", "2.5", false},
+		{"variance([1, 2, 3, 4])", ModeRational, "5/3", false},
+		{"stdev([2, 4, 4, 4, 5, 5, 7, 9])", "", "2.138089935299395", false},
+		{"percentile([1, 2, 3, 4, 5], 25)", "", "2", false},
+		{"percentile([1, 2, 3, 4], 50)", "", "2.5", false},
+		{"percentile([10, 20], 100)", "", "20", false},
+		{"percentile([5, 1, 3], 0)", "", "1", false},
+		{"slope([1, 2, 3], [2, 4, 6])", "", "2", false},
+		{"intercept([1, 2, 3], [3, 5, 7])", "", "1", false},
+		{"correlation([1, 2, 3], [3, 2, 1])", "", "-1", false},
+		{"mean([1, 2] * 2)", ModeMatrix, "3", false},
+		{"v = [1, 2, 3]; sum(v)", ModeMatrix, "6", false},
+		{"sum(k, k, 1, 3)", "", "6", false},
+		{"mean(x) = x + 1; mean(1)", "", "2", false},
+		{"mean([1, 2], [3])", "", "", true},
+		{"mean(1)", "", "", true},
+		{"mean([])", "", "", true},
+		{"variance([1])", "", "", true},
+		{"stdev([1, 2])", ModeDecimal, "", true},
+		{"percentile([1, 2], 101)", "", "", true},
+		{"slope([1, 2], [1, 2, 3])", "", "", true},
+		{"slope([1, 1], [1, 2])", "", "", true},
+		{"correlation([1, 2], [3, 3])", "", "", true},
+		{"median([[1, 2], [3, 4]])", ModeMatrix, "", true},
+		{"[1, 2]", "", "", true},
+	}
+
+	for _, tc := range testCases {
+		result, value, err := Evaluate(tc.expression, Options{Mode: tc.mode})
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got %v", tc.expression, result)
+			}
+			continue
+		}
+		got := FormatFloat(result)
+		if value != nil {
+			got = value.Literal()
+		}
+		if err != nil || got != tc.expected {
+			t.Errorf("%s: expected %s, got %s (%v)", tc.expression, tc.expected, got, err)
+		}
+	}
+}
+
+func TestDatasets(t *testing.T) {
+	datasets := map[string][]json.Number{"prices": {"0.1", "0.2", "-0.6"}, "x": {"1", "2", "3"}}
+	testCases := []struct {
+		expression string
+		mode       Mode
+		expected   string
+		expectErr  bool
+	}{
+		{"sum(#prices)", ModeDecimal, "-0.3", false},
+		{"count(#prices) + 1", "", "4", false},
+		{"slope(#x, #x)", "", "1", false},
+		{"#x * 2", ModeMatrix, "[2, 4, 6]", false},
+		{"mean(#missing)", "", "", true},
+		{"#x + 1", "", "", true},
+	}
+
+	for _, tc := range testCases {
+		result, value, err := Evaluate(tc.expression, Options{Mode: tc.mode, Datasets: datasets})
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got %v", tc.expression, result)
+			}
+			continue
+		}
+		got := FormatFloat(result)
+		if value != nil {
+			got = value.Literal()
+		}
+		if err != nil || got != tc.expected {
+			t.Errorf("%s: expected %s, got %s (%v)", tc.expression, tc.expected, got, err)
+		}
+	}
+
+	if err := (Options{Datasets: map[string][]json.Number{"a b": {"1"}}}).Validate(); err == nil {
+		t.Error("expected an invalid dataset name to be rejected")
+	}
+	refs, err := Datasets("mean(#x) + $1 * sum(#y) - #x")
+	if err != nil || !reflect.DeepEqual(refs, []string{"x", "y"}) {
+		t.Errorf("expected the datasets x and y, got %q (%v)", refs, err)
+	}
+	refs, err = References("mean(#x) + $1")
+	if err != nil || !reflect.DeepEqual(refs, []string{"$1"}) {
+		t.Errorf("expected datasets not to be references, got %q (%v)", refs, err)
+	}
+	if _, err := Datasets("mean(#1x)"); err == nil {
+		t.Error("expected a malformed dataset reference to be rejected")
+	}
+}
+
+func TestPartitionDataset(t *testing.T) {
+	size := func(name string) int {
+		if name == "big" {
+			return 25000
+		}
+		return 15000
+	}
+	testCases := []struct {
+		expression string
+		opts       Options
+		expected   []DatasetPart
+	}{
+		{"sum(#big)", Options{}, []DatasetPart{
+			{"sum(#big)", 0, 12500}, {"sum(#big)", 12500, 12500},
+		}},
+		{"mean(#big)", Options{Mode: ModeDecimal}, []DatasetPart{
+			{"sum(#big)/25000", 0, 12500}, {"sum(#big)/25000", 12500, 12500},
+		}},
+		{"count(#big)", Options{}, []DatasetPart{
+			{"count(#big)", 0, 12500}, {"count(#big)", 12500, 12500},
+		}},
+		{"sum(#small)", Options{}, nil},
+		{"median(#big)", Options{}, nil},
+		{"mean(#big) + 1", Options{}, nil},
+		{"sum([1, 2])", Options{}, nil},
+		{"sum(#big)", Options{Mode: ModeInteger}, nil},
+		{"sum(#big)", Options{Operation: OperationSimplify}, nil},
+		{"sum(#big)", Options{Functions: []string{"sum(x) = 0"}}, nil},
+	}
+
+	for _, tc := range testCases {
+		parts, name, op := PartitionDataset(tc.expression, 4, size, tc.opts)
+		if !reflect.DeepEqual(parts, tc.expected) {
+			t.Errorf("expected %v partitioning %q, got %v", tc.expected, tc.expression, parts)
+		}
+		if parts != nil && (name != "big" || op != "+") {
+			t.Errorf("expected parts of big combined with +, got %q, %q", name, op)
+		}
+	}
+
+	// The parts of a mean add up to the mean.
+	values := make([]json.Number, 25000)
+	for i := range values {
+		values[i] = json.Number("0.1")
+	}
+	parts, _, _ := PartitionDataset("mean(#big)", 4, size, Options{})
+	total := 0.0
+	for _, part := range parts {
+		slice := map[string][]json.Number{"big": values[part.Offset : part.Offset+part.Count]}
+		result, _, err := Evaluate(part.Expression, Options{Mode: ModeRational, Datasets: slice})
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += result
+	}
+	if total != 0.1 {
+		t.Errorf("expected the parts of the mean to add up to 0.1, got %v", total)
+	}
+}
@@ -0,0 +1,549 @@
+package calc
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Derive returns the derivative of expression with respect to variable,
+// simplified and formatted as an expression, e.g. "2*x*sin(x) +
+// x^2*cos(x)" for x^2*sin(x). Identifiers other than variable are treated
+// as constants.
+func Derive(expression, variable string) (string, error) {
+	return deriveWith(expression, variable, syntax{})
+}
+
+// deriveWith is Derive for an expression written in syntax s.
+func deriveWith(expression, variable string, s syntax) (string, error) {
+	if !IsLabel(variable) {
+		return "", fmt.Errorf("invalid variable: %q", variable)
+	}
+	n, err := parseExpression(expression, s)
+	if err != nil {
+		return "", err
+	}
+	d, err := derive(n, variable)
+	if err != nil {
+		return "", err
+	}
+	return format(simplify(d)), nil
+}
+
+// parseExpression parses a single expression written in syntax s,
+// rejecting scripts, for the symbolic operations.
+func parseExpression(expression string, s syntax) (node, error) {
+	n, err := parse(expression, s)
+	if err != nil {
+		return nil, err
+	}
+	switch n.(type) {
+	case *programNode, *assignNode, *defineNode:
+		return nil, errors.New("expected a single expression, not a script")
+	}
+	return n, nil
+}
+
+func number(text string) node {
+	return &numberNode{text: text}
+}
+
+func neg(x node) node {
+	return &unaryNode{op: "-", x: x}
+}
+
+func add(x, y node) node {
+	return &binaryNode{op: "+", x: x, y: y}
+}
+
+func sub(x, y node) node {
+	return &binaryNode{op: "-", x: x, y: y}
+}
+
+func mul(x, y node) node {
+	return &binaryNode{op: "*", x: x, y: y}
+}
+
+func div(x, y node) node {
+	return &binaryNode{op: "/", x: x, y: y}
+}
+
+func pow(x, y node) node {
+	return &binaryNode{op: "**", x: x, y: y}
+}
+
+func call(name string, args ...node) node {
+	return &callNode{name: name, args: args}
+}
+
+// contains reports whether variable occurs in n.
+func contains(n node, variable string) bool {
+	switch n := n.(type) {
+	case *identNode:
+		return n.name == variable
+	case *unaryNode:
+		return contains(n.x, variable)
+	case *binaryNode:
+		return contains(n.x, variable) || contains(n.y, variable)
+	case *logicalNode:
+		return contains(n.x, variable) || contains(n.y, variable)
+	case *conditionalNode:
+		return contains(n.cond, variable) || contains(n.then, variable) || contains(n.otherwise, variable)
+	case *callNode:
+		for _, arg := range n.args {
+			if contains(arg, variable) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// derivatives gives the derivative of each differentiable built-in
+// function of one argument u, to be multiplied by the derivative of u.
+var derivatives = map[string]func(u node) node{
+	"sin":  func(u node) node { return call("cos", u) },
+	"cos":  func(u node) node { return neg(call("sin", u)) },
+	"tan":  func(u node) node { return div(number("1"), pow(call("cos", u), number("2"))) },
+	"exp":  func(u node) node { return call("exp", u) },
+	"ln":   func(u node) node { return div(number("1"), u) },
+	"sqrt": func(u node) node { return div(number("1"), mul(number("2"), call("sqrt", u))) },
+	"abs":  func(u node) node { return div(u, call("abs", u)) },
+}
+
+// derive differentiates n with respect to variable without simplifying.
+func derive(n node, variable string) (node, error) {
+	if !contains(n, variable) {
+		return number("0"), nil
+	}
+	switch n := n.(type) {
+	case *identNode:
+		return number("1"), nil
+	case *unaryNode:
+		dx, err := derive(n.x, variable)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "+":
+			return dx, nil
+		case "-":
+			return neg(dx), nil
+		}
+		return nil, fmt.Errorf("cannot differentiate operator %s", n.op)
+	case *binaryNode:
+		dx, err := derive(n.x, variable)
+		if err != nil {
+			return nil, err
+		}
+		dy, err := derive(n.y, variable)
+		if err != nil {
+			return nil, err
+		}
+		switch n.op {
+		case "+":
+			return add(dx, dy), nil
+		case "-":
+			return sub(dx, dy), nil
+		case "*":
+			return add(mul(dx, n.y), mul(n.x, dy)), nil
+		case "/":
+			return div(sub(mul(dx, n.y), mul(n.x, dy)), pow(n.y, number("2"))), nil
+		case "**":
+			switch {
+			case !contains(n.y, variable):
+				return mul(mul(n.y, pow(n.x, sub(n.y, number("1")))), dx), nil
+			case !contains(n.x, variable):
+				return mul(mul(n, call("ln", n.x)), dy), nil
+			default:
+				return mul(n, add(mul(dy, call("ln", n.x)), div(mul(n.y, dx), n.x))), nil
+			}
+		}
+		return nil, fmt.Errorf("cannot differentiate operator %s", n.op)
+	case *conditionalNode:
+		then, err := derive(n.then, variable)
+		if err != nil {
+			return nil, err
+		}
+		otherwise, err := derive(n.otherwise, variable)
+		if err != nil {
+			return nil, err
+		}
+		return &conditionalNode{cond: n.cond, then: then, otherwise: otherwise}, nil
+	case *callNode:
+		outer, ok := derivatives[n.name]
+		if !ok || len(n.args) != 1 {
+			return nil, fmt.Errorf("cannot differentiate function %s", n.name)
+		}
+		du, err := derive(n.args[0], variable)
+		if err != nil {
+			return nil, err
+		}
+		return mul(du, outer(n.args[0])), nil
+	default:
+		return nil, errors.New("cannot differentiate comparisons or boolean operators")
+	}
+}
+
+// maxSimplifyPasses bounds how often simplify rewrites a tree.
+const maxSimplifyPasses = 10
+
+// simplify applies algebraic identities and folds constants until the
+// tree stops changing.
+func simplify(n node) node {
+	text := format(n)
+	for i := 0; i < maxSimplifyPasses; i++ {
+		n = simplifyOnce(n)
+		next := format(n)
+		if next == text {
+			break
+		}
+		text = next
+	}
+	return n
+}
+
+func simplifyOnce(n node) node {
+	switch n := n.(type) {
+	case *unaryNode:
+		return simplifyUnary(n.op, simplifyOnce(n.x))
+	case *binaryNode:
+		return simplifyBinary(n.op, simplifyOnce(n.x), simplifyOnce(n.y))
+	case *logicalNode:
+		return &logicalNode{op: n.op, x: simplifyOnce(n.x), y: simplifyOnce(n.y)}
+	case *conditionalNode:
+		return &conditionalNode{cond: simplifyOnce(n.cond), then: simplifyOnce(n.then), otherwise: simplifyOnce(n.otherwise)}
+	case *callNode:
+		args := make([]node, len(n.args))
+		for i, arg := range n.args {
+			args[i] = simplifyOnce(arg)
+		}
+		return &callNode{name: n.name, args: args}
+	default:
+		return n
+	}
+}
+
+func simplifyUnary(op string, x node) node {
+	switch op {
+	case "+":
+		return x
+	case "-":
+		if isNumber(x, 0) {
+			return x
+		}
+		if inner, ok := x.(*unaryNode); ok && inner.op == "-" {
+			return inner.x
+		}
+	}
+	return &unaryNode{op: op, x: x}
+}
+
+func simplifyBinary(op string, x, y node) node {
+	if folded, ok := foldConstants(op, x, y); ok {
+		return folded
+	}
+	negX, xNegated := negated(x)
+	negY, yNegated := negated(y)
+	switch op {
+	case "+":
+		switch {
+		case isNumber(x, 0):
+			return y
+		case isNumber(y, 0):
+			return x
+		case yNegated:
+			return sub(x, negY)
+		case xNegated:
+			return sub(y, negX)
+		}
+	case "-":
+		switch {
+		case isNumber(y, 0):
+			return x
+		case isNumber(x, 0):
+			return neg(y)
+		case yNegated:
+			return add(x, negY)
+		case format(x) == format(y):
+			return number("0")
+		}
+	case "*":
+		switch {
+		case isNumber(x, 0) || isNumber(y, 0):
+			return number("0")
+		case isNumber(x, 1):
+			return y
+		case isNumber(y, 1):
+			return x
+		case xNegated:
+			return neg(mul(negX, y))
+		case yNegated:
+			return neg(mul(x, negY))
+		case isConstant(y) && !isConstant(x):
+			return mul(y, x)
+		}
+		// Cancel a divisor: 1/x*x is 1.
+		if q, ok := x.(*binaryNode); ok && q.op == "/" && format(q.y) == format(y) {
+			return q.x
+		}
+		if q, ok := y.(*binaryNode); ok && q.op == "/" && format(q.y) == format(x) {
+			return q.x
+		}
+		// Gather numeric coefficients: 2*(3*x) is 6*x.
+		if inner, ok := y.(*binaryNode); ok && inner.op == "*" && isConstant(x) && isConstant(inner.x) {
+			if coefficient, ok := foldConstants("*", x, inner.x); ok {
+				return mul(coefficient, inner.y)
+			}
+		}
+	case "/":
+		switch {
+		case isNumber(x, 0) && !isNumber(y, 0):
+			return number("0")
+		case isNumber(y, 1):
+			return x
+		case format(x) == format(y):
+			return number("1")
+		case xNegated:
+			return neg(div(negX, y))
+		case yNegated:
+			return neg(div(x, negY))
+		}
+	case "**":
+		switch {
+		case isNumber(y, 0):
+			return number("1")
+		case isNumber(y, 1):
+			return x
+		case isNumber(x, 1):
+			return number("1")
+		}
+	}
+	return &binaryNode{op: op, x: x, y: y}
+}
+
+// negated returns x if n is -x.
+func negated(n node) (node, bool) {
+	if u, ok := n.(*unaryNode); ok && u.op == "-" {
+		return u.x, true
+	}
+	return nil, false
+}
+
+// constantValue returns the value of a real number literal, or of a
+// negated one.
+func constantValue(n node) (*big.Rat, bool) {
+	if x, ok := negated(n); ok {
+		r, ok := constantValue(x)
+		if !ok {
+			return nil, false
+		}
+		return new(big.Rat).Neg(r), true
+	}
+	num, ok := n.(*numberNode)
+	if !ok || isImaginary(num.text) {
+		return nil, false
+	}
+	r, ok := new(big.Rat).SetString(num.text)
+	return r, ok
+}
+
+func isConstant(n node) bool {
+	_, ok := constantValue(n)
+	return ok
+}
+
+func isNumber(n node, value int64) bool {
+	r, ok := constantValue(n)
+	return ok && r.Cmp(big.NewRat(value, 1)) == 0
+}
+
+// maxFoldedExponent bounds the powers simplify evaluates, so 10^100000
+// stays as written.
+const maxFoldedExponent = 64
+
+// foldConstants evaluates op on two constants if the result can be
+// written exactly as a number literal.
+func foldConstants(op string, x, y node) (node, bool) {
+	a, ok := constantValue(x)
+	if !ok {
+		return nil, false
+	}
+	b, ok := constantValue(y)
+	if !ok {
+		return nil, false
+	}
+	var r *big.Rat
+	switch op {
+	case "+":
+		r = new(big.Rat).Add(a, b)
+	case "-":
+		r = new(big.Rat).Sub(a, b)
+	case "*":
+		r = new(big.Rat).Mul(a, b)
+	case "/":
+		if b.Sign() == 0 {
+			return nil, false
+		}
+		r = new(big.Rat).Quo(a, b)
+	case "**":
+		if !b.IsInt() || b.Num().CmpAbs(big.NewInt(maxFoldedExponent)) > 0 {
+			return nil, false
+		}
+		var err error
+		if r, err = ratPower(a, b); err != nil {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+	return ratNode(r)
+}
+
+// ratNode returns a number literal for r, or false if r has no finite
+// decimal expansion.
+func ratNode(r *big.Rat) (node, bool) {
+	if r.Sign() < 0 {
+		n, ok := ratNode(new(big.Rat).Neg(r))
+		if !ok {
+			return nil, false
+		}
+		return neg(n), true
+	}
+	if r.IsInt() {
+		return number(r.Num().String()), true
+	}
+	digits, ok := fractionDigits(r.Denom())
+	if !ok {
+		return nil, false
+	}
+	return number(r.FloatString(digits)), true
+}
+
+// fractionDigits returns the number of fractional digits needed to write
+// 1/denom exactly, or false if denom has prime factors other than 2 and 5.
+func fractionDigits(denom *big.Int) (int, bool) {
+	d := new(big.Int).Set(denom)
+	var twos, fives int
+	two, five, rem := big.NewInt(2), big.NewInt(5), new(big.Int)
+	for {
+		q, r := new(big.Int).QuoRem(d, two, rem)
+		if r.Sign() != 0 {
+			break
+		}
+		d, twos = q, twos+1
+	}
+	for {
+		q, r := new(big.Int).QuoRem(d, five, rem)
+		if r.Sign() != 0 {
+			break
+		}
+		d, fives = q, fives+1
+	}
+	return max(twos, fives), d.IsInt64() && d.Int64() == 1
+}
+
+// Precedences used when formatting, extending binaryOperators: unary
+// operators bind tighter than multiplication, powers tighter still.
+const (
+	conditionalPrecedence = 0
+	unaryPrecedence       = 11
+	powerPrecedence       = 12
+	atomPrecedence        = 13
+)
+
+func precedence(n node) int {
+	switch n := n.(type) {
+	case *binaryNode:
+		if n.op == "**" || n.op == ".^" {
+			return powerPrecedence
+		}
+		return binaryOperators[n.op].precedence
+	case *logicalNode:
+		return binaryOperators[n.op].precedence
+	case *unaryNode:
+		return unaryPrecedence
+	case *conditionalNode:
+		return conditionalPrecedence
+	default:
+		return atomPrecedence
+	}
+}
+
+// format writes n as an expression with as few parentheses as parse needs
+// to read it back. Additive and looser operators are surrounded by spaces,
+// multiplicative ones and powers are not, and powers are written ^.
+func format(n node) string {
+	switch n := n.(type) {
+	case *numberNode:
+		return n.text
+	case *identNode:
+		return n.name
+	case *unaryNode:
+		operand := format(n.x)
+		// -x*y reads as (-x)*y, which has the same value as -(x*y).
+		if p := precedence(n.x); p < binaryOperators["*"].precedence || p == binaryOperators["*"].precedence && n.op != "-" {
+			operand = "(" + operand + ")"
+		}
+		return n.op + operand
+	case *binaryNode:
+		return formatInfix(n.op, n.x, n.y, precedence(n))
+	case *logicalNode:
+		return formatInfix(n.op, n.x, n.y, precedence(n))
+	case *conditionalNode:
+		return formatOperand(n.cond, conditionalPrecedence+1, false) + " ? " + format(n.then) + " : " + format(n.otherwise)
+	case *callNode:
+		args := make([]string, len(n.args))
+		for i, arg := range n.args {
+			args[i] = format(arg)
+		}
+		return n.name + "(" + strings.Join(args, ", ") + ")"
+	case *arrayNode:
+		elements := make([]string, len(n.elements))
+		for i, element := range n.elements {
+			elements[i] = format(element)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	case *assignNode:
+		return n.name + " = " + format(n.x)
+	case *defineNode:
+		return n.name + "(" + strings.Join(n.params, ", ") + ") = " + format(n.body)
+	case *programNode:
+		statements := make([]string, len(n.statements))
+		for i, statement := range n.statements {
+			statements[i] = format(statement)
+		}
+		return strings.Join(statements, "; ")
+	default:
+		return fmt.Sprintf("%v", n)
+	}
+}
+
+func formatInfix(op string, x, y node, p int) string {
+	rightAssoc := op == "**" || op == ".^"
+	left := formatOperand(x, p, rightAssoc)
+	right := formatOperand(y, p, !rightAssoc)
+	// Parenthesize a negated right operand of arithmetic, as in x*(-y).
+	if _, ok := y.(*unaryNode); ok && p >= binaryOperators["+"].precedence && !strings.HasPrefix(right, "(") {
+		right = "(" + right + ")"
+	}
+	if op == "**" {
+		op = "^"
+	}
+	if p <= binaryOperators["+"].precedence {
+		return left + " " + op + " " + right
+	}
+	return left + op + right
+}
+
+// formatOperand formats an operand of an operator of precedence p,
+// parenthesized if it binds looser, or as loosely on the side where the
+// operator does not associate.
+func formatOperand(n node, p int, strictSide bool) string {
+	q := precedence(n)
+	if q < p || q == p && strictSide {
+		return "(" + format(n) + ")"
+	}
+	return format(n)
+}
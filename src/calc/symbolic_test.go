@@ -0,0 +1,81 @@
+package calc
+
+import (
+	"testing"
+)
+
+func TestDerive(t *testing.T) {
+	testCases := []struct {
+		expression string
+		variable   string
+		expected   string
+		expectErr  bool
+	}{
+		{"x^2*sin(x)", "x", "2*x*sin(x) + x^2*cos(x)", false},
+		{"3*x^3 - 2*x + 7", "x", "9*x^2 - 2", false},
+		{"a*x + b", "x", "a", false},
+		{"a*x + b", "a", "x", false},
+		{"5", "x", "0", false},
+		{"-x", "x", "-1", false},
+		{"cos(2*x)", "x", "-2*sin(2*x)", false},
+		{"1/x", "x", "-1/x^2", false},
+		{"exp(x^2)", "x", "2*x*exp(x^2)", false},
+		{"ln(x)", "x", "1/x", false},
+		{"ln(x)/x", "x", "(1 - ln(x))/x^2", false},
+		{"x*ln(x)", "x", "ln(x) + 1", false},
+		{"sqrt(x)", "x", "1/(2*sqrt(x))", false},
+		{"2^x", "x", "2^x*ln(2)", false},
+		{"x^x", "x", "x^x*(ln(x) + 1)", false},
+		{"x^0.5", "x", "0.5*x^(-0.5)", false},
+		{"x > 0 ? x^2 : -x", "x", "x > 0 ? 2*x : -1", false},
+		{"f(x)", "x", "", true},
+		{"x < 1", "x", "", true},
+		{"a = x; a", "x", "", true},
+		{"x +", "x", "", true},
+		{"x", "1x", "", true},
+	}
+
+	for _, tc := range testCases {
+		result, err := Derive(tc.expression, tc.variable)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("expected error deriving %q, got %q", tc.expression, result)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("did not expect error deriving %q, got %v", tc.expression, err)
+			continue
+		}
+		if result != tc.expected {
+			t.Errorf("expected %q deriving %q, got %q", tc.expected, tc.expression, result)
+		}
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	for _, expression := range []string{
+		"a - (b - c)", "a/(b*c)", "(a^b)^c", "a^b^c", "(-a)^2", "-a^2",
+		"a*(-b)", "-(a + b)", "(a ? b : c) + 1", "f(a, b + 1)", "a < b && !c",
+	} {
+		n, err := parseExpression(expression, syntax{})
+		if err != nil {
+			t.Fatalf("parse %q: %v", expression, err)
+		}
+		if got := format(n); got != expression {
+			t.Errorf("expected %q to format as itself, got %q", expression, got)
+		}
+	}
+}
+
+func TestEvaluateDerive(t *testing.T) {
+	_, value, err := Evaluate("x^3", Options{Operation: OperationDerive, Variable: "x"})
+	if err != nil || value == nil || value.Type != TypeExpression || value.Expression != "3*x^2" || value.IsReal() {
+		t.Fatalf("unexpected derivative %+v, %v", value, err)
+	}
+	for _, opts := range []Options{{Operation: OperationDerive}, {Operation: "integrate-ish", Variable: "x"}} {
+		if _, _, err := Evaluate("x", opts); err == nil {
+			t.Errorf("expected error for options %+v", opts)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package calc
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	// Time zones must resolve on hosts without a zoneinfo database.
+	_ "time/tzdata"
+	"unicode/utf8"
+)
+
+// TimeCalculator evaluates expressions over instants, durations and
+// numbers. Dates and times are written in ISO 8601, as in 2026-10-18,
+// 2026-10-18T09:30 or 2026-10-18T09:30:00+02:00, optionally followed by a
+// time zone in brackets, as in 2026-10-18T09:30[Europe/Berlin]; without
+// an offset or zone they are in Location. Durations are written as
+// numbers with units, as in 90d, 1h30m or 250ms, where d is 24 hours.
+// Subtracting instants gives a duration, and x in u expresses the
+// duration x as a number of u, as in (2026-12-31 - 2026-01-01) in days.
+type TimeCalculator struct {
+	// Location is the time zone of literals without an offset and of
+	// results, UTC if nil.
+	Location *time.Location
+	// Scope, if not nil, holds user-defined functions expressions can call.
+	Scope *Scope
+}
+
+func NewTimeCalculator(location *time.Location) *TimeCalculator {
+	return &TimeCalculator{Location: location}
+}
+
+// Exact implements ExactCalculator. Instants are returned as values of
+// type TypeInstant, durations of type TypeDuration and numbers of type
+// ModeFloat.
+func (c *TimeCalculator) Exact(expression string) (*Value, error) {
+	d := timeDomain{location: c.Location}
+	if d.location == nil {
+		d.location = time.UTC
+	}
+	result, err := evaluateString[moment](expression, d, c.Scope)
+	if err != nil {
+		return nil, err
+	}
+	return result.value(d.location)
+}
+
+// Calculate implements Calculator with the number of seconds of durations
+// and the Unix time of instants.
+func (c *TimeCalculator) Calculate(expression string) (float64, error) {
+	value, err := c.Exact(expression)
+	if err != nil {
+		return 0, err
+	}
+	return value.Float64(), nil
+}
+
+// TypeInstant is the Type of a Value holding a point in time, and
+// TypeDuration of one holding a length of time.
+const (
+	TypeInstant  Mode = "instant"
+	TypeDuration Mode = "duration"
+)
+
+// timeLiteral matches the date, time and duration literals of the time
+// syntax.
+var timeLiteral = regexp.MustCompile(`^(?:` +
+	`\d{4}-\d{2}-\d{2}(?:T\d{2}:\d{2}(?::\d{2}(?:\.\d+)?)?(?:Z|[+-]\d{2}:\d{2})?)?(?:\[[A-Za-z_][A-Za-z0-9_+/-]*\])?` +
+	`|(?:\d+(?:\.\d+)?(?:ms|min|w|d|h|m|s))+)`)
+
+// scanTimeLiteral returns the end of the date, time or duration literal
+// starting at start, or start if there is none.
+func scanTimeLiteral(s string, start int) int {
+	end := start + len(timeLiteral.FindString(s[start:]))
+	if end < len(s) {
+		if r, _ := utf8.DecodeRuneInString(s[end:]); r == '.' || isIdentPart(r) {
+			return start
+		}
+	}
+	return end
+}
+
+// durationUnits are the units of duration literals, and durationNames the
+// names x in u converts to.
+var (
+	durationUnits = map[string]time.Duration{
+		"w": 7 * 24 * time.Hour, "d": 24 * time.Hour, "h": time.Hour,
+		"min": time.Minute, "m": time.Minute, "s": time.Second, "ms": time.Millisecond,
+	}
+	durationNames = map[string]time.Duration{
+		"weeks": 7 * 24 * time.Hour, "days": 24 * time.Hour, "hours": time.Hour,
+		"minutes": time.Minute, "seconds": time.Second, "milliseconds": time.Millisecond,
+	}
+	durationPart = regexp.MustCompile(`(\d+(?:\.\d+)?)(ms|min|w|d|h|m|s)`)
+	dateStart    = regexp.MustCompile(`^\d{4}-`)
+)
+
+type momentKind int
+
+const (
+	kindNumber momentKind = iota
+	kindDuration
+	kindInstant
+)
+
+func (k momentKind) String() string {
+	return [...]string{"number", "duration", "instant"}[k]
+}
+
+// moment is a value of the time domain: a number, a duration or an
+// instant.
+type moment struct {
+	kind     momentKind
+	number   float64
+	duration time.Duration
+	instant  time.Time
+}
+
+func (m moment) value(location *time.Location) (*Value, error) {
+	switch m.kind {
+	case kindInstant:
+		return &Value{Type: TypeInstant, Time: m.instant.In(location).Format(time.RFC3339Nano)}, nil
+	case kindDuration:
+		return &Value{Type: TypeDuration, Duration: formatDuration(m.duration)}, nil
+	}
+	if math.IsInf(m.number, 0) || math.IsNaN(m.number) {
+		return nil, errors.New("result is not a finite number")
+	}
+	return &Value{Type: ModeFloat, Decimal: FormatFloat(m.number)}, nil
+}
+
+// formatDuration writes d as a duration literal, such as 1d2h30m or
+// 0.25s.
+func formatDuration(d time.Duration) string {
+	if d == 0 {
+		return "0s"
+	}
+	var b strings.Builder
+	if d < 0 {
+		b.WriteByte('-')
+	}
+	// The magnitude of the most negative duration overflows, so the parts
+	// are taken from the negative value.
+	parts := []struct {
+		unit string
+		size time.Duration
+	}{{"d", 24 * time.Hour}, {"h", time.Hour}, {"m", time.Minute}}
+	rest := d
+	for _, p := range parts {
+		if n := rest / p.size; n != 0 {
+			fmt.Fprintf(&b, "%d%s", max(n, -n), p.unit)
+			rest -= n * p.size
+		}
+	}
+	if rest != 0 {
+		seconds := strconv.FormatFloat(math.Abs(rest.Seconds()), 'f', -1, 64)
+		b.WriteString(seconds + "s")
+	}
+	return b.String()
+}
+
+// parseDuration parses a duration literal.
+func parseDuration(literal string) (time.Duration, error) {
+	var total float64
+	for _, part := range durationPart.FindAllStringSubmatch(literal, -1) {
+		n, err := strconv.ParseFloat(part[1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration: %s", literal)
+		}
+		total += n * float64(durationUnits[part[2]])
+	}
+	return toDuration(total)
+}
+
+// toDuration converts nanoseconds to a Duration, failing if it is out of
+// range.
+func toDuration(ns float64) (time.Duration, error) {
+	if math.IsNaN(ns) || math.Abs(ns) >= math.MaxInt64 {
+		return 0, errors.New("duration out of range")
+	}
+	return time.Duration(math.Round(ns)), nil
+}
+
+var instantLayouts = []string{"2006-01-02T15:04:05Z07:00", "2006-01-02T15:04Z07:00", "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02"}
+
+// parseInstant parses a date or time literal, in location unless it has
+// an offset or a zone of its own.
+func parseInstant(literal string, location *time.Location) (time.Time, error) {
+	if i := strings.IndexByte(literal, '['); i >= 0 {
+		zone, err := time.LoadLocation(literal[i+1 : len(literal)-1])
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unknown time zone: %s", literal[i+1:len(literal)-1])
+		}
+		literal, location = literal[:i], zone
+	}
+	for _, layout := range instantLayouts {
+		if t, err := time.ParseInLocation(layout, literal, location); err == nil {
+			return t.In(location), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date: %s", literal)
+}
+
+// timeDomain evaluates in moments, parsing expressions with the time
+// syntax.
+type timeDomain struct {
+	location *time.Location
+}
+
+func (timeDomain) timeSyntax() {}
+
+func (d timeDomain) number(literal string) (moment, error) {
+	// Number literals never start with four digits and a dash, and only
+	// imaginary ones end with a letter.
+	switch last := literal[len(literal)-1]; {
+	case dateStart.MatchString(literal):
+		t, err := parseInstant(literal, d.location)
+		return moment{kind: kindInstant, instant: t}, err
+	case last >= 'a' && last <= 'z' && last != 'i':
+		duration, err := parseDuration(literal)
+		return moment{kind: kindDuration, duration: duration}, err
+	}
+	f, err := parseFloat(literal)
+	return moment{number: f}, err
+}
+
+func (timeDomain) ident(name string) (moment, error) {
+	if unit, ok := durationNames[name]; ok {
+		return moment{kind: kindDuration, duration: unit}, nil
+	}
+	return unknownIdent[moment](name)
+}
+
+func (timeDomain) unary(op string, x moment) (moment, error) {
+	switch {
+	case op == "+" && x.kind != kindInstant:
+		return x, nil
+	case op == "-" && x.kind == kindNumber:
+		return moment{number: -x.number}, nil
+	case op == "-" && x.kind == kindDuration:
+		if x.duration == math.MinInt64 {
+			return moment{}, errors.New("duration out of range")
+		}
+		return moment{kind: kindDuration, duration: -x.duration}, nil
+	default:
+		return moment{}, fmt.Errorf("undefined operation: %s%s", op, x.kind)
+	}
+}
+
+func (d timeDomain) binary(op string, a, b moment) (moment, error) {
+	if a.kind == kindNumber && b.kind == kindNumber && op != "in" {
+		n, err := floatDomain{}.binary(op, a.number, b.number)
+		return moment{number: n}, err
+	}
+	switch {
+	case op == "+" && a.kind == kindInstant && b.kind == kindDuration:
+		return moment{kind: kindInstant, instant: a.instant.Add(b.duration)}, nil
+	case op == "+" && a.kind == kindDuration && b.kind == kindInstant:
+		return moment{kind: kindInstant, instant: b.instant.Add(a.duration)}, nil
+	case op == "-" && a.kind == kindInstant && b.kind == kindDuration:
+		if b.duration == math.MinInt64 {
+			return moment{}, errors.New("duration out of range")
+		}
+		return moment{kind: kindInstant, instant: a.instant.Add(-b.duration)}, nil
+	case op == "-" && a.kind == kindInstant && b.kind == kindInstant:
+		duration, err := toDuration(float64(a.instant.UnixNano()) - float64(b.instant.UnixNano()))
+		if err == nil {
+			duration = a.instant.Sub(b.instant)
+		}
+		return moment{kind: kindDuration, duration: duration}, err
+	case op == "+" && a.kind == kindDuration && b.kind == kindDuration:
+		if _, err := toDuration(float64(a.duration) + float64(b.duration)); err != nil {
+			return moment{}, err
+		}
+		return moment{kind: kindDuration, duration: a.duration + b.duration}, nil
+	case op == "-" && a.kind == kindDuration && b.kind == kindDuration:
+		if _, err := toDuration(float64(a.duration) - float64(b.duration)); err != nil {
+			return moment{}, err
+		}
+		return moment{kind: kindDuration, duration: a.duration - b.duration}, nil
+	case op == "*" && a.kind == kindDuration && b.kind == kindNumber:
+		duration, err := toDuration(float64(a.duration) * b.number)
+		return moment{kind: kindDuration, duration: duration}, err
+	case op == "*" && a.kind == kindNumber && b.kind == kindDuration:
+		return d.binary(op, b, a)
+	case op == "/" && a.kind == kindDuration && b.kind == kindNumber:
+		if b.number == 0 {
+			return moment{}, errors.New("division by zero")
+		}
+		duration, err := toDuration(float64(a.duration) / b.number)
+		return moment{kind: kindDuration, duration: duration}, err
+	case (op == "/" || op == "in") && a.kind == kindDuration && b.kind == kindDuration:
+		if b.duration == 0 {
+			return moment{}, errors.New("division by zero")
+		}
+		return moment{number: float64(a.duration) / float64(b.duration)}, nil
+	case op == "in":
+		return moment{}, fmt.Errorf("cannot express %s in %s: only durations convert", a.kind, b.kind)
+	default:
+		return moment{}, fmt.Errorf("undefined operation: %s %s %s", a.kind, op, b.kind)
+	}
+}
+
+// timeFunctions are the functions of the time domain that take or return
+// instants. The float functions are available for numbers too.
+var timeFunctions = map[string]func(d timeDomain, args []moment) (moment, error){
+	"now": func(d timeDomain, args []moment) (moment, error) {
+		return moment{kind: kindInstant, instant: time.Now().In(d.location)}, nil
+	},
+	"today": func(d timeDomain, args []moment) (moment, error) {
+		y, m, day := time.Now().In(d.location).Date()
+		return moment{kind: kindInstant, instant: time.Date(y, m, day, 0, 0, 0, 0, d.location)}, nil
+	},
+	"year":    instantField(func(t time.Time) int { return t.Year() }),
+	"month":   instantField(func(t time.Time) int { return int(t.Month()) }),
+	"day":     instantField(func(t time.Time) int { return t.Day() }),
+	"hour":    instantField(func(t time.Time) int { return t.Hour() }),
+	"minute":  instantField(func(t time.Time) int { return t.Minute() }),
+	"weekday": instantField(func(t time.Time) int { return (int(t.Weekday())+6)%7 + 1 }),
+}
+
+// timeArity is the number of arguments of each of timeFunctions.
+var timeArity = map[string]int{"now": 0, "today": 0}
+
+// instantField returns a function of an instant giving a field of its
+// date or time in the domain's location. weekday counts from 1 for
+// Monday.
+func instantField(field func(time.Time) int) func(d timeDomain, args []moment) (moment, error) {
+	return func(d timeDomain, args []moment) (moment, error) {
+		if args[0].kind != kindInstant {
+			return moment{}, fmt.Errorf("expected an instant, got a %s", args[0].kind)
+		}
+		return moment{number: float64(field(args[0].instant.In(d.location)))}, nil
+	}
+}
+
+func (d timeDomain) call(name string, args []moment) (moment, error) {
+	if f, ok := timeFunctions[name]; ok {
+		arity, ok := timeArity[name]
+		if !ok {
+			arity = 1
+		}
+		if len(args) != arity {
+			return moment{}, fmt.Errorf("%s expects %d argument(s), got %d", name, arity, len(args))
+		}
+		return f(d, args)
+	}
+	if name == "abs" && len(args) == 1 && args[0].kind == kindDuration {
+		if args[0].duration < 0 {
+			return d.unary("-", args[0])
+		}
+		return args[0], nil
+	}
+	numbers := make([]float64, len(args))
+	for i, arg := range args {
+		if arg.kind != kindNumber {
+			return moment{}, fmt.Errorf("%s expects numbers, got a %s", name, arg.kind)
+		}
+		numbers[i] = arg.number
+	}
+	result, err := callFunction(floatFunctions, name, numbers)
+	return moment{number: result}, err
+}
+
+func (timeDomain) compare(a, b moment) (int, error) {
+	if a.kind != b.kind {
+		return 0, fmt.Errorf("cannot compare %s and %s", a.kind, b.kind)
+	}
+	switch a.kind {
+	case kindInstant:
+		return a.instant.Compare(b.instant), nil
+	case kindDuration:
+		return cmp.Compare(a.duration, b.duration), nil
+	default:
+		return cmp.Compare(a.number, b.number), nil
+	}
+}
+
+func (d timeDomain) equal(a, b moment) bool {
+	c, err := d.compare(a, b)
+	return err == nil && c == 0
+}
+
+func (timeDomain) truth(x moment) bool {
+	switch x.kind {
+	case kindInstant:
+		return true
+	case kindDuration:
+		return x.duration != 0
+	default:
+		return x.number != 0
+	}
+}
+
+func (timeDomain) fromBool(b bool) moment {
+	if b {
+		return moment{number: 1}
+	}
+	return moment{}
+}
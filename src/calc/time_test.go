@@ -0,0 +1,117 @@
+package calc
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTimeCalculator(t *testing.T) {
+	testCases := []struct {
+		expression string
+		typ        Mode
+		expected   string
+		expectErr  bool
+	}{
+		{"2026-10-18 + 90d", TypeInstant, "2027-01-16T00:00:00Z", false},
+		{"90d + 2026-10-18", TypeInstant, "2027-01-16T00:00:00Z", false},
+		{"(2026-12-31 - 2026-01-01) in days", ModeFloat, "364", false},
+		{"2026-12-31 - 2026-01-01", TypeDuration, "364d", false},
+		{"2026-10-18T09:30 + 1h30m", TypeInstant, "2026-10-18T11:00:00Z", false},
+		{"2026-10-18T09:30:15.5 - 15.5s", TypeInstant, "2026-10-18T09:30:00Z", false},
+		{"2026-10-18T09:30:00+02:00", TypeInstant, "2026-10-18T07:30:00Z", false},
+		{"2026-10-18T09:30[America/New_York]", TypeInstant, "2026-10-18T13:30:00Z", false},
+		{"3h in minutes", ModeFloat, "180", false},
+		{"2w / 4", TypeDuration, "3d12h", false},
+		{"1.5h * 2", TypeDuration, "3h", false},
+		{"1d - 1ms", TypeDuration, "23h59m59.999s", false},
+		{"-90min", TypeDuration, "-1h30m", false},
+		{"abs(-90min)", TypeDuration, "1h30m", false},
+		{"250ms", TypeDuration, "0.25s", false},
+		{"4h / 30min", ModeFloat, "8", false},
+		{"weekday(2026-10-18)", ModeFloat, "7", false},
+		{"year(2026-10-18 + 90d)", ModeFloat, "2027", false},
+		{"2026-10-18 < 2026-10-19", ModeFloat, "1", false},
+		{"deadline = 2026-10-18T17:00 + 3 * 8h; deadline > 2026-10-19T00:00 ? deadline : 0", TypeInstant, "2026-10-19T17:00:00Z", false},
+		{"2 + 3", ModeFloat, "5", false},
+		{"123e-10000", ModeFloat, "0", false},
+		{"2026-02-30", "", "", true},
+		{"2026-10-18[Mars/Olympus]", "", "", true},
+		{"2026-10-18 + 2026-10-18", "", "", true},
+		{"2026-10-18 - 10", "", "", true},
+		{"2026-10-18 < 1h", "", "", true},
+		{"2026-10-18 in days", "", "", true},
+		{"1h30 + 2", "", "", true},
+		{"1h / 0", "", "", true},
+		{"300000d", "", "", true},
+		{"sin(1h)", "", "", true},
+	}
+
+	calc := NewTimeCalculator(nil)
+	for _, tc := range testCases {
+		value, err := calc.Exact(tc.expression)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got %+v", tc.expression, value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.expression, err)
+			continue
+		}
+		if value.Type != tc.typ || value.Literal() != tc.expected {
+			t.Errorf("%s: expected %s %s, got %s %s", tc.expression, tc.typ, tc.expected, value.Type, value.Literal())
+		}
+	}
+}
+
+func TestTimeZone(t *testing.T) {
+	// Berlin switches from summer to winter time on 2026-10-25, and a day
+	// is always 24 hours.
+	_, value, err := Evaluate("2026-10-24T12:00 + 1d", Options{Mode: ModeTime, TimeZone: "Europe/Berlin"})
+	if err != nil || value.Time != "2026-10-25T11:00:00+01:00" {
+		t.Fatalf("expected 2026-10-25T11:00:00+01:00, got %+v (%v)", value, err)
+	}
+	_, value, err = Evaluate("2026-10-18T09:30Z", Options{Mode: ModeTime, TimeZone: "Asia/Tokyo"})
+	if err != nil || value.Time != "2026-10-18T18:30:00+09:00" {
+		t.Fatalf("expected 2026-10-18T18:30:00+09:00, got %+v (%v)", value, err)
+	}
+	for _, opts := range []Options{
+		{Mode: ModeTime, TimeZone: "Mars/Olympus"},
+		{TimeZone: "Europe/Berlin"},
+	} {
+		if err := opts.Validate(); err == nil {
+			t.Errorf("%+v: expected error", opts)
+		}
+	}
+}
+
+func TestNow(t *testing.T) {
+	before := time.Now()
+	result, value, err := Evaluate("now() + 3h", Options{Mode: ModeTime})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := time.Parse(time.RFC3339Nano, value.Time)
+	if err != nil || got.Before(before.Add(3*time.Hour)) || got.After(time.Now().Add(3*time.Hour)) {
+		t.Fatalf("expected three hours from now, got %s (%v)", value.Time, err)
+	}
+	if value.IsReal() || result != value.Float64() {
+		t.Fatalf("expected the Unix time as result of a non-real value, got %v", result)
+	}
+}
+
+func TestTimeLiteralsRoundTrip(t *testing.T) {
+	calc := NewTimeCalculator(time.FixedZone("", -5*3600))
+	for _, expression := range []string{"2026-10-18T09:30:15.25", "-1d2h3m4.5s", "2026-10-18 - 2025-03-01T12:00"} {
+		value, err := calc.Exact(expression)
+		if err != nil {
+			t.Fatalf("%s: %v", expression, err)
+		}
+		again, err := calc.Exact(value.Literal())
+		if err != nil || !reflect.DeepEqual(again, value) {
+			t.Fatalf("%s: literal %s evaluates to %+v (%v)", expression, value.Literal(), again, err)
+		}
+	}
+}
@@ -0,0 +1,457 @@
+package calc
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// UnitsCalculator evaluates expressions over physical quantities. Names
+// that are not variables are units, and a unit directly after a number
+// multiplies it, so 5 km / 2 h is 2.5 km/h. Sums are expressed in the
+// unit of their left operand, 3 m + 20 cm is 3.2 m, and x to u converts
+// x to the unit u, as in 60 km/h to mph. Adding, comparing or converting
+// quantities of different dimensions, such as m + s, is an error.
+type UnitsCalculator struct {
+	// Scope, if not nil, holds user-defined functions expressions can call.
+	Scope *Scope
+}
+
+func NewUnitsCalculator() *UnitsCalculator {
+	return &UnitsCalculator{}
+}
+
+// Quantity is a number of some unit, such as 2.5 km/h. A dimensionless
+// quantity has no Unit.
+type Quantity struct {
+	Value float64
+	Unit  string
+}
+
+// Evaluate returns the quantity expression evaluates to.
+func (c *UnitsCalculator) Evaluate(expression string) (Quantity, error) {
+	result, err := evaluateString[quantity](expression, unitsDomain{}, c.Scope)
+	if err != nil {
+		return Quantity{}, err
+	}
+	if math.IsInf(result.value, 0) || math.IsNaN(result.value) {
+		return Quantity{}, errors.New("result is not a finite number")
+	}
+	return Quantity{Value: result.value, Unit: result.unit()}, nil
+}
+
+// Exact implements ExactCalculator.
+func (c *UnitsCalculator) Exact(expression string) (*Value, error) {
+	result, err := c.Evaluate(expression)
+	if err != nil {
+		return nil, err
+	}
+	return quantityValue(result), nil
+}
+
+// Calculate implements Calculator with the number of units of the result.
+func (c *UnitsCalculator) Calculate(expression string) (float64, error) {
+	result, err := c.Evaluate(expression)
+	return result.Value, err
+}
+
+func quantityValue(q Quantity) *Value {
+	return &Value{Type: ModeUnits, Decimal: FormatFloat(q.Value), Unit: q.Unit}
+}
+
+// dimension holds the exponents of the base dimensions length, mass,
+// time, current, temperature, amount of substance, luminous intensity
+// and information.
+type dimension [8]int
+
+var dimensionNames = [8]string{
+	"length", "mass", "time", "current", "temperature", "amount", "luminosity", "information",
+}
+
+func (d dimension) times(n int) dimension {
+	for i := range d {
+		d[i] *= n
+	}
+	return d
+}
+
+func (d dimension) plus(e dimension) dimension {
+	for i := range d {
+		d[i] += e[i]
+	}
+	return d
+}
+
+func (d dimension) String() string {
+	var factors []unitFactor
+	for i, exp := range d {
+		if exp != 0 {
+			factors = append(factors, unitFactor{symbol: dimensionNames[i], exp: exp})
+		}
+	}
+	if len(factors) == 0 {
+		return "dimensionless"
+	}
+	return formatUnit(factors)
+}
+
+// unitFactor is a unit raised to a power, such as s^-2 in m/s^2. scale
+// is the size of the unit in SI base units.
+type unitFactor struct {
+	symbol string
+	scale  float64
+	dim    dimension
+	exp    int
+}
+
+// quantity is a number of the product of its units, which are kept in
+// the order they were first used.
+type quantity struct {
+	value float64
+	units []unitFactor
+}
+
+func (q quantity) dim() dimension {
+	var d dimension
+	for _, f := range q.units {
+		d = d.plus(f.dim.times(f.exp))
+	}
+	return d
+}
+
+// scale returns the size of the unit of q in SI base units.
+func (q quantity) scale() float64 {
+	scale := 1.0
+	for _, f := range q.units {
+		scale *= math.Pow(f.scale, float64(f.exp))
+	}
+	return scale
+}
+
+func (q quantity) unit() string {
+	return formatUnit(q.units)
+}
+
+// in returns the number of units of u that q amounts to. q and u must
+// have the same dimension.
+func (q quantity) in(u quantity) float64 {
+	if len(q.units) == 0 && len(u.units) == 0 {
+		return q.value
+	}
+	return q.value * q.scale() / u.scale()
+}
+
+// formatUnit writes factors as a unit such as kg*m^2/s^2, or s^-1 if all
+// their exponents are negative.
+func formatUnit(factors []unitFactor) string {
+	var numerator, denominator []string
+	for _, f := range factors {
+		exp := f.exp
+		if exp < 0 {
+			exp = -exp
+		}
+		s := f.symbol
+		if exp != 1 {
+			s += "^" + strconv.Itoa(exp)
+		}
+		if f.exp > 0 {
+			numerator = append(numerator, s)
+		} else {
+			denominator = append(denominator, s)
+		}
+	}
+	switch {
+	case len(numerator) == 0 && len(denominator) == 0:
+		return ""
+	case len(numerator) == 0:
+		parts := make([]string, len(factors))
+		for i, f := range factors {
+			parts[i] = f.symbol + "^" + strconv.Itoa(f.exp)
+		}
+		return strings.Join(parts, "*")
+	case len(denominator) == 0:
+		return strings.Join(numerator, "*")
+	case len(denominator) == 1:
+		return strings.Join(numerator, "*") + "/" + denominator[0]
+	default:
+		return strings.Join(numerator, "*") + "/(" + strings.Join(denominator, "*") + ")"
+	}
+}
+
+// unitDefinition is a unit of the registry. prefixed units take the SI
+// prefixes, as in km, and binary ones also the binary prefixes, as in
+// KiB.
+type unitDefinition struct {
+	scale    float64
+	dim      dimension
+	prefixed bool
+	binary   bool
+}
+
+var (
+	dimLength      = dimension{0: 1}
+	dimMass        = dimension{1: 1}
+	dimDuration    = dimension{2: 1}
+	dimCurrent     = dimension{3: 1}
+	dimTemperature = dimension{4: 1}
+	dimAmount      = dimension{5: 1}
+	dimLuminosity  = dimension{6: 1}
+	dimInformation = dimension{7: 1}
+	dimFrequency   = dimension{2: -1}
+	dimForce       = dimension{0: 1, 1: 1, 2: -2}
+	dimPressure    = dimension{0: -1, 1: 1, 2: -2}
+	dimEnergy      = dimension{0: 2, 1: 1, 2: -2}
+	dimPower       = dimension{0: 2, 1: 1, 2: -3}
+	dimCharge      = dimension{2: 1, 3: 1}
+	dimVoltage     = dimension{0: 2, 1: 1, 2: -3, 3: -1}
+	dimResistance  = dimension{0: 2, 1: 1, 2: -3, 3: -2}
+	dimCapacitance = dimension{0: -2, 1: -1, 2: 4, 3: 2}
+	dimInductance  = dimension{0: 2, 1: 1, 2: -2, 3: -2}
+	dimVolume      = dimension{0: 3}
+	dimVelocity    = dimension{0: 1, 2: -1}
+)
+
+// unitRegistry holds the units expressions can use, by symbol.
+var unitRegistry = map[string]unitDefinition{
+	"m":   {1, dimLength, true, false},
+	"g":   {1e-3, dimMass, true, false},
+	"s":   {1, dimDuration, true, false},
+	"A":   {1, dimCurrent, true, false},
+	"K":   {1, dimTemperature, true, false},
+	"mol": {1, dimAmount, true, false},
+	"cd":  {1, dimLuminosity, true, false},
+	"Hz":  {1, dimFrequency, true, false},
+	"N":   {1, dimForce, true, false},
+	"Pa":  {1, dimPressure, true, false},
+	"J":   {1, dimEnergy, true, false},
+	"W":   {1, dimPower, true, false},
+	"C":   {1, dimCharge, true, false},
+	"V":   {1, dimVoltage, true, false},
+	"ohm": {1, dimResistance, true, false},
+	"Ω":   {1, dimResistance, true, false},
+	"F":   {1, dimCapacitance, true, false},
+	"H":   {1, dimInductance, true, false},
+	"L":   {1e-3, dimVolume, true, false},
+	"Wh":  {3600, dimEnergy, true, false},
+	"Ah":  {3600, dimCharge, true, false},
+	"eV":  {1.602176634e-19, dimEnergy, true, false},
+	"bar": {1e5, dimPressure, true, false},
+	"bit": {1, dimInformation, true, true},
+	"B":   {8, dimInformation, true, true},
+	"min": {60, dimDuration, false, false},
+	"h":   {3600, dimDuration, false, false},
+	"d":   {86400, dimDuration, false, false},
+	"in":  {0.0254, dimLength, false, false},
+	"ft":  {0.3048, dimLength, false, false},
+	"yd":  {0.9144, dimLength, false, false},
+	"mi":  {1609.344, dimLength, false, false},
+	"mph": {0.44704, dimVelocity, false, false},
+	"lb":  {0.45359237, dimMass, false, false},
+	"oz":  {0.028349523125, dimMass, false, false},
+	"t":   {1000, dimMass, false, false},
+	"atm": {101325, dimPressure, false, false},
+	"psi": {6894.757293168361, dimPressure, false, false},
+}
+
+// unitPrefixes are the SI prefixes, and binaryPrefixes the binary ones,
+// longest first so that dam is a decametre.
+var (
+	unitPrefixes = []struct {
+		symbol string
+		scale  float64
+	}{
+		{"da", 1e1}, {"P", 1e15}, {"T", 1e12}, {"G", 1e9}, {"M", 1e6}, {"k", 1e3}, {"h", 1e2},
+		{"d", 1e-1}, {"c", 1e-2}, {"m", 1e-3}, {"u", 1e-6}, {"µ", 1e-6}, {"n", 1e-9}, {"p", 1e-12}, {"f", 1e-15},
+	}
+	binaryPrefixes = []struct {
+		symbol string
+		scale  float64
+	}{
+		{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+	}
+)
+
+// lookupUnit returns the unit called name, which is a unit of the
+// registry, possibly with a prefix.
+func lookupUnit(name string) (unitFactor, bool) {
+	if u, ok := unitRegistry[name]; ok {
+		return unitFactor{symbol: name, scale: u.scale, dim: u.dim, exp: 1}, true
+	}
+	for _, p := range binaryPrefixes {
+		if u, ok := unitRegistry[strings.TrimPrefix(name, p.symbol)]; ok && u.binary && strings.HasPrefix(name, p.symbol) {
+			return unitFactor{symbol: name, scale: p.scale * u.scale, dim: u.dim, exp: 1}, true
+		}
+	}
+	for _, p := range unitPrefixes {
+		if u, ok := unitRegistry[strings.TrimPrefix(name, p.symbol)]; ok && u.prefixed && strings.HasPrefix(name, p.symbol) {
+			return unitFactor{symbol: name, scale: p.scale * u.scale, dim: u.dim, exp: 1}, true
+		}
+	}
+	return unitFactor{}, false
+}
+
+// unitsDomain evaluates in quantities, parsing expressions with the units
+// syntax.
+type unitsDomain struct{}
+
+func (unitsDomain) unitSyntax() {}
+
+func (unitsDomain) number(literal string) (quantity, error) {
+	f, err := parseFloat(literal)
+	return quantity{value: f}, err
+}
+
+func (unitsDomain) ident(name string) (quantity, error) {
+	if u, ok := lookupUnit(name); ok {
+		return quantity{value: 1, units: []unitFactor{u}}, nil
+	}
+	return unknownIdent[quantity](name)
+}
+
+func (unitsDomain) unary(op string, x quantity) (quantity, error) {
+	switch op {
+	case "+":
+		return x, nil
+	case "-":
+		x.value = -x.value
+		return x, nil
+	default:
+		return undefinedOperator[quantity](op)
+	}
+}
+
+func (unitsDomain) binary(op string, a, b quantity) (quantity, error) {
+	switch op {
+	case "+", "-":
+		if a.dim() != b.dim() {
+			return quantity{}, fmt.Errorf("dimension mismatch: %s %s %s", a.dim(), op, b.dim())
+		}
+		if op == "-" {
+			return quantity{value: a.value - b.in(a), units: a.units}, nil
+		}
+		return quantity{value: a.value + b.in(a), units: a.units}, nil
+	case "*":
+		return multiply(a, b), nil
+	case "/":
+		if b.value == 0 {
+			return quantity{}, errors.New("division by zero")
+		}
+		return multiply(a, raise(b, -1)), nil
+	case "**":
+		if b.dim() != (dimension{}) {
+			return quantity{}, fmt.Errorf("exponent must be dimensionless, got %s", b.dim())
+		}
+		exp := b.in(quantity{value: 1})
+		result := quantity{value: math.Pow(a.value, exp)}
+		for _, f := range a.units {
+			e := float64(f.exp) * exp
+			if e != math.Trunc(e) || math.Abs(e) > math.MaxInt32 {
+				return quantity{}, fmt.Errorf("cannot raise %s to the power %s", a.unit(), FormatFloat(exp))
+			}
+			f.exp = int(e)
+			result.units = append(result.units, f)
+		}
+		return result, nil
+	case "to":
+		if b.value != 1 {
+			return quantity{}, fmt.Errorf("cannot convert to %s: not a unit", FormatFloat(b.value)+" "+b.unit())
+		}
+		if a.dim() != b.dim() {
+			return quantity{}, fmt.Errorf("cannot convert %s to %s", a.dim(), b.dim())
+		}
+		return quantity{value: a.in(b), units: b.units}, nil
+	default:
+		return undefinedOperator[quantity](op)
+	}
+}
+
+// raise returns q with the exponents of its units multiplied by n, and
+// its value raised to the power n.
+func raise(q quantity, n int) quantity {
+	result := quantity{value: math.Pow(q.value, float64(n))}
+	for _, f := range q.units {
+		f.exp *= n
+		result.units = append(result.units, f)
+	}
+	return result
+}
+
+// multiply returns a times b. The units of b are expressed in those of a
+// where they measure the same dimension, so that m * cm is in m^2 and
+// km/h * h in km, and units whose exponents cancel are dropped.
+func multiply(a, b quantity) quantity {
+	result := quantity{value: a.value * b.value, units: append([]unitFactor(nil), a.units...)}
+	for _, g := range b.units {
+		merged := false
+		for i, f := range result.units {
+			if f.symbol == g.symbol || f.dim == g.dim {
+				result.value *= math.Pow(g.scale/f.scale, float64(g.exp))
+				result.units[i].exp += g.exp
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			result.units = append(result.units, g)
+		}
+	}
+	units := result.units[:0]
+	for _, f := range result.units {
+		if f.exp != 0 {
+			units = append(units, f)
+		}
+	}
+	result.units = units
+	return result
+}
+
+func (d unitsDomain) call(name string, args []quantity) (quantity, error) {
+	switch name {
+	case "abs":
+		if len(args) == 1 {
+			args[0].value = math.Abs(args[0].value)
+			return args[0], nil
+		}
+	case "sqrt":
+		if len(args) == 1 {
+			if args[0].value < 0 {
+				return quantity{}, errors.New("square root of a negative number requires complex mode")
+			}
+			return d.binary("**", args[0], quantity{value: 0.5})
+		}
+	}
+	numbers := make([]float64, len(args))
+	for i, arg := range args {
+		if arg.dim() != (dimension{}) {
+			return quantity{}, fmt.Errorf("%s expects a dimensionless argument, got %s", name, arg.dim())
+		}
+		numbers[i] = arg.in(quantity{value: 1})
+	}
+	result, err := callFunction(floatFunctions, name, numbers)
+	return quantity{value: result}, err
+}
+
+func (unitsDomain) compare(a, b quantity) (int, error) {
+	if a.dim() != b.dim() {
+		return 0, fmt.Errorf("cannot compare %s and %s", a.dim(), b.dim())
+	}
+	return cmp.Compare(a.value, b.in(a)), nil
+}
+
+func (unitsDomain) equal(a, b quantity) bool {
+	return a.dim() == b.dim() && a.value == b.in(a)
+}
+
+func (unitsDomain) truth(x quantity) bool {
+	return x.value != 0
+}
+
+func (unitsDomain) fromBool(b bool) quantity {
+	if b {
+		return quantity{value: 1}
+	}
+	return quantity{}
+}
@@ -0,0 +1,109 @@
+package calc
+
+import (
+	"math"
+	"testing"
+)
+
+func TestUnitsCalculator(t *testing.T) {
+	testCases := []struct {
+		expression string
+		expected   float64
+		unit       string
+		expectErr  bool
+	}{
+		{"5 km / 2 h", 2.5, "km/h", false},
+		{"3 m + 20 cm", 3.2, "m", false},
+		{"20 cm + 3 m", 320, "cm", false},
+		{"60 km/h to mph", 37.28227153424004, "mph", false},
+		{"1 mi to km", 1.609344, "km", false},
+		{"100 W * 3 h to kWh", 0.3, "kWh", false},
+		{"10 V / 2 kohm to mA", 5, "mA", false},
+		{"2 KiB to bit", 16384, "bit", false},
+		{"2 kg m^2 s^-2", 2, "kg*m^2/s^2", false},
+		{"2 kg m^2 s^-2 to J", 2, "J", false},
+		{"3 kg/(m*s)", 3, "kg/(m*s)", false},
+		{"5 m * 3 s^-1", 15, "m/s", false},
+		{"4 / 2 s", 2, "s^-1", false},
+		{"1 km / 1 m", 1000, "", false},
+		{"3 m * 20 cm", 0.6, "m^2", false},
+		{"sqrt(9 m^2)", 3, "m", false},
+		{"abs(-2 V)", 2, "V", false},
+		{"-2 m", -2, "m", false},
+		{"x = 2 m; x^2", 4, "m^2", false},
+		{"f(t) = 9.81 m/s^2 * t^2 / 2; f(2 s)", 19.62, "m", false},
+		{"3 m > 20 cm", 1, "", false},
+		{"1 km == 1000 m", 1, "", false},
+		{"x > 1 ? 2 m : 3 m", 0, "", true},
+		{"2 > 1 ? 150 cm to m : 0 m", 1.5, "m", false},
+		{"1 h to min to s", 3600, "s", false},
+		{"m + s", 0, "", true},
+		{"3 m + 2", 0, "", true},
+		{"3 m > 2 s", 0, "", true},
+		{"5 m to s", 0, "", true},
+		{"5 m to 2 km", 0, "", true},
+		{"sin(3 m)", 0, "", true},
+		{"sqrt(2 m)", 0, "", true},
+		{"2 m ^ (1 s)", 0, "", true},
+		{"2 m ^ 1 s", 2, "m*s", false},
+		{"3 parsec", 0, "", true},
+		{"1 m / 0", 0, "", true},
+	}
+
+	calc := NewUnitsCalculator()
+	for _, tc := range testCases {
+		result, err := calc.Evaluate(tc.expression)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got %v %s", tc.expression, result.Value, result.Unit)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.expression, err)
+			continue
+		}
+		if math.Abs(result.Value-tc.expected) > 1e-9*math.Max(1, math.Abs(tc.expected)) || result.Unit != tc.unit {
+			t.Errorf("%s: expected %v %s, got %v %s", tc.expression, tc.expected, tc.unit, result.Value, result.Unit)
+		}
+	}
+}
+
+func TestUnitPrefixes(t *testing.T) {
+	for name, scale := range map[string]float64{
+		"km": 1000, "mm": 1e-3, "µs": 1e-6, "us": 1e-6, "kWh": 3.6e6, "mAh": 3.6,
+		"hPa": 100, "dam": 10, "min": 60, "mi": 1609.344, "GiB": 8 << 30, "MB": 8e6,
+	} {
+		u, ok := lookupUnit(name)
+		if !ok || math.Abs(u.scale-scale) > 1e-12*scale {
+			t.Errorf("%s: expected scale %v, got %v (%v)", name, scale, u.scale, ok)
+		}
+	}
+	for _, name := range []string{"kmin", "Kim", "kft", "to", "x"} {
+		if _, ok := lookupUnit(name); ok {
+			t.Errorf("%s: expected no unit", name)
+		}
+	}
+}
+
+func TestEvaluateUnits(t *testing.T) {
+	result, value, err := Evaluate("5 km / 2 h", Options{Mode: ModeUnits})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != 2.5 || value.Type != ModeUnits || value.Decimal != "2.5" || value.Unit != "km/h" || value.Literal() != "2.5 km/h" {
+		t.Fatalf("expected 2.5 km/h, got %v, %+v", result, value)
+	}
+	// The literal of a result evaluates back to it.
+	_, again, err := Evaluate(value.Literal()+" to m/s", Options{Mode: ModeUnits})
+	if err != nil || math.Abs(again.Float64()-2.5/3.6) > 1e-12 {
+		t.Fatalf("expected %s to be 2.5/3.6 m/s, got %+v (%v)", value.Literal(), again, err)
+	}
+	_, value, err = Evaluate("v = 2 m/s; ke(v) * 1 kg", Options{Mode: ModeUnits, Functions: []string{"ke(v) = v^2 / 2"}})
+	if err != nil || value.Literal() != "2 m^2*kg/s^2" {
+		t.Fatalf("expected 2 m^2*kg/s^2, got %+v (%v)", value, err)
+	}
+	if _, _, err := Evaluate("2 m", Options{}); err == nil {
+		t.Fatal("expected units to require units mode")
+	}
+}
@@ -0,0 +1,38 @@
+// Package logging configures the structured logger shared by the GoCalc
+// services.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// New returns a logger writing to w. level is one of debug, info, warn or
+// error, and format is either text or json; empty values default to info
+// and text.
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q", level)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+// FromEnv builds a logger from LOG_LEVEL and LOG_FORMAT and writes to
+// stderr.
+func FromEnv() (*slog.Logger, error) {
+	return New(os.Stderr, os.Getenv("LOG_LEVEL"), os.Getenv("LOG_FORMAT"))
+}
@@ -0,0 +1,33 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestNewJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(&buf, "warn", "json")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger.Info("dropped")
+	logger.Warn("kept", "task_id", 7)
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a single JSON line, got %q: %v", buf.String(), err)
+	}
+	if line["msg"] != "kept" || line["task_id"] != float64(7) {
+		t.Fatalf("unexpected log line: %v", line)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	if _, err := New(&bytes.Buffer{}, "loud", "text"); err == nil {
+		t.Error("expected error for invalid level")
+	}
+	if _, err := New(&bytes.Buffer{}, "info", "xml"); err == nil {
+		t.Error("expected error for invalid format")
+	}
+}
@@ -0,0 +1,269 @@
+// Package metrics is a small Prometheus-compatible metrics registry. It
+// supports counters, gauges and histograms with labels and serves them in
+// the Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets in seconds, suited to request and
+// task latencies.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+	hooks      []func()
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// OnScrape registers fn to run before every scrape. It is meant for gauges
+// whose value is cheaper to compute on demand than to keep up to date.
+func (r *Registry) OnScrape(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, fn)
+}
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Write writes all metrics in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	hooks := append([]func(){}, r.hooks...)
+	collectors := append([]collector{}, r.collectors...)
+	r.mu.Unlock()
+	for _, hook := range hooks {
+		hook()
+	}
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+func (r *Registry) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, `{"error":"Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	writer.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(writer)
+}
+
+// family holds the series of one metric, keyed by their label values.
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+func newFamily(name, help, kind string, labels []string) *family {
+	return &family{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*series)}
+}
+
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (f *family) sorted() []*series {
+	list := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.Join(list[i].labelValues, "\xff") < strings.Join(list[j].labelValues, "\xff")
+	})
+	return list
+}
+
+func (f *family) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+func (f *family) write(w io.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.header(w)
+	for _, s := range f.sorted() {
+		fmt.Fprintf(w, "%s%s %s\n", f.name, formatLabels(f.labels, s.labelValues, "", ""), formatValue(s.value))
+	}
+}
+
+// Counter is a monotonically increasing value.
+type Counter struct {
+	*family
+}
+
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{newFamily(name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.mu.Lock()
+	c.get(labelValues).value += v
+	c.mu.Unlock()
+}
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	*family
+}
+
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{newFamily(name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.mu.Lock()
+	g.get(labelValues).value = v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.mu.Lock()
+	g.get(labelValues).value += v
+	g.mu.Unlock()
+}
+
+// Histogram counts observations into cumulative buckets.
+type Histogram struct {
+	*family
+	buckets []float64
+}
+
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &Histogram{family: newFamily(name, help, "histogram", labels), buckets: buckets}
+	r.register(h)
+	return h
+}
+
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.get(labelValues)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	for i, bound := range h.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, s := range h.sorted() {
+		for i, bound := range h.buckets {
+			var count uint64
+			if s.counts != nil {
+				count = s.counts[i]
+			}
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "le", formatValue(bound)), count)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, s.labelValues, "", ""), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", name, escapeLabel(values[i]))
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"", extraName, extraValue)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
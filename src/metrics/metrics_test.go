@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistryTextFormat(t *testing.T) {
+	reg := NewRegistry()
+	counter := reg.NewCounter("jobs_total", "Jobs processed.", "worker")
+	gauge := reg.NewGauge("queue_depth", "Tasks waiting.")
+	hist := reg.NewHistogram("latency_seconds", "Latency.", []float64{0.1, 1})
+
+	counter.Inc("1")
+	counter.Add(2, "2")
+	reg.OnScrape(func() { gauge.Set(7) })
+	hist.Observe(0.05)
+	hist.Observe(0.5)
+	hist.Observe(3)
+
+	var b strings.Builder
+	reg.Write(&b)
+	out := b.String()
+
+	expected := []string{
+		"# TYPE jobs_total counter",
+		`jobs_total{worker="1"} 1`,
+		`jobs_total{worker="2"} 2`,
+		"# TYPE queue_depth gauge",
+		"queue_depth 7",
+		"# TYPE latency_seconds histogram",
+		`latency_seconds_bucket{le="0.1"} 1`,
+		`latency_seconds_bucket{le="1"} 2`,
+		`latency_seconds_bucket{le="+Inf"} 3`,
+		"latency_seconds_sum 3.55",
+		"latency_seconds_count 3",
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("expected line %q in output:\n%s", line, out)
+		}
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewGauge("g", "Gauge.", "path").Set(1, "a\"b\\c\nd")
+	var b strings.Builder
+	reg.Write(&b)
+	if !strings.Contains(b.String(), `g{path="a\"b\\c\nd"} 1`) {
+		t.Fatalf("label not escaped: %s", b.String())
+	}
+}
+
+func TestRegistryServeHTTP(t *testing.T) {
+	reg := NewRegistry()
+	reg.NewCounter("hits_total", "Hits.").Inc()
+	w := httptest.NewRecorder()
+	reg.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	res := w.Result()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	if !strings.HasPrefix(res.Header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("unexpected content type %q", res.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(w.Body.String(), "hits_total 1") {
+		t.Fatalf("unexpected body: %s", w.Body.String())
+	}
+}
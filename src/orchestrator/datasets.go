@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/m4tveevm/GoCalc/calc"
+)
+
+// datasets holds the uploaded datasets by name. Datasets cannot be
+// replaced or deleted, since queued tasks and parts of aggregates may
+// still refer to them.
+var datasets = make(map[string][]json.Number)
+
+type DatasetRequest struct {
+	Name   string        `json:"name"`
+	Values []json.Number `json:"values"`
+}
+
+// Dataset describes an uploaded dataset. Values are only listed when the
+// dataset is requested on its own.
+type Dataset struct {
+	Name   string        `json:"name"`
+	Count  int           `json:"count"`
+	Values []json.Number `json:"values,omitempty"`
+}
+
+// handleDatasets lists (GET) and uploads (POST) datasets, which
+// expressions reference as #name.
+func handleDatasets(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case http.MethodGet:
+		mu.Lock()
+		list := []Dataset{}
+		for name, values := range datasets {
+			list = append(list, Dataset{Name: name, Count: len(values)})
+		}
+		mu.Unlock()
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(map[string][]Dataset{"datasets": list})
+	case http.MethodPost:
+		var req DatasetRequest
+		if err := json.NewDecoder(request.Body).Decode(&req); err != nil || len(req.Values) == 0 {
+			http.Error(writer, `{"error":"Invalid dataset"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		if !calc.IsLabel(req.Name) {
+			http.Error(writer, `{"error":"Invalid dataset name"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		mu.Lock()
+		if _, exists := datasets[req.Name]; exists {
+			mu.Unlock()
+			http.Error(writer, `{"error":"Dataset already exists"}`, http.StatusConflict)
+			return
+		}
+		datasets[req.Name] = req.Values
+		mu.Unlock()
+		slog.Info("dataset uploaded", "dataset", req.Name, "count", len(req.Values))
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusCreated)
+		json.NewEncoder(writer).Encode(Dataset{Name: req.Name, Count: len(req.Values)})
+	default:
+		http.Error(writer, `{"error":"Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// handleDataset returns one dataset with its values.
+func handleDataset(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, `{"error":"Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(request.URL.Path, "/api/v1/datasets/")
+	if name == "" {
+		http.Error(writer, `{"error":"Name not provided"}`, http.StatusBadRequest)
+		return
+	}
+	mu.Lock()
+	values, exists := datasets[name]
+	mu.Unlock()
+	if !exists {
+		http.Error(writer, `{"error":"Not found"}`, http.StatusNotFound)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(Dataset{Name: name, Count: len(values), Values: values})
+}
+
+// referencedDatasets returns the names of the datasets expression and
+// the functions in opts reference.
+func referencedDatasets(expression string, opts calc.Options) []string {
+	names, _ := calc.Datasets(expression)
+	definitions := slices.Clone(opts.Functions)
+	for _, d := range opts.Definitions {
+		definitions = append(definitions, d.Definition)
+	}
+	for _, definition := range definitions {
+		refs, _ := calc.Datasets(definition)
+		for _, name := range refs {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// checkDatasets reports a dataset expression references that is neither
+// given inline in opts nor uploaded. The caller must hold mu.
+func checkDatasets(expression string, opts calc.Options) error {
+	for _, name := range referencedDatasets(expression, opts) {
+		if _, inline := opts.Datasets[name]; !inline && datasets[name] == nil {
+			return fmt.Errorf("unknown dataset #%s", name)
+		}
+	}
+	return nil
+}
+
+// datasetSize returns the number of values of the uploaded dataset name,
+// or -1 if opts gives a dataset of that name inline, which is never
+// split. The caller must hold mu.
+func datasetSize(name string, opts calc.Options) int {
+	if _, inline := opts.Datasets[name]; inline {
+		return -1
+	}
+	return len(datasets[name])
+}
+
+// taskOptions returns the options task is evaluated with: its own, with
+// the datasets it references given inline, so the agent has their
+// values. A part of an aggregate over a dataset only gets its slice of
+// it. The caller must hold mu.
+func taskOptions(task *Calculation) calc.Options {
+	opts := task.Options
+	names := referencedDatasets(task.Expression, task.Options)
+	if len(names) == 0 {
+		return opts
+	}
+	opts.Datasets = make(map[string][]json.Number, len(names))
+	for _, name := range names {
+		values, inline := task.Options.Datasets[name]
+		if !inline {
+			values = datasets[name]
+			if name == task.Dataset {
+				values = values[task.Offset : task.Offset+task.Count]
+			}
+		}
+		if values != nil {
+			opts.Datasets[name] = values
+		}
+	}
+	return opts
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/m4tveevm/GoCalc/calc"
+)
+
+func upload(t *testing.T, body string) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handleDatasets(w, httptest.NewRequest(http.MethodPost, "/api/v1/datasets", bytes.NewBufferString(body)))
+	return w.Result().StatusCode
+}
+
+func TestUploadDataset(t *testing.T) {
+	resetGlobals()
+	if status := upload(t, `{"name": "prices", "values": [0.1, 0.2, 3]}`); status != http.StatusCreated {
+		t.Fatalf("expected %d, got %d", http.StatusCreated, status)
+	}
+	if status := upload(t, `{"name": "prices", "values": [1]}`); status != http.StatusConflict {
+		t.Fatalf("expected an existing dataset to be kept, got %d", status)
+	}
+	for _, body := range []string{`{"name": "empty", "values": []}`, `{"name": "2x", "values": [1]}`, `{"name": "words", "values": ["a"]}`} {
+		if status := upload(t, body); status != http.StatusUnprocessableEntity {
+			t.Fatalf("expected %s to be rejected, got %d", body, status)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleDatasets(w, httptest.NewRequest(http.MethodGet, "/api/v1/datasets", nil))
+	var list map[string][]Dataset
+	json.NewDecoder(w.Result().Body).Decode(&list)
+	if len(list["datasets"]) != 1 || list["datasets"][0].Count != 3 || list["datasets"][0].Values != nil {
+		t.Fatalf("expected prices to be listed without its values, got %+v", list)
+	}
+	w = httptest.NewRecorder()
+	handleDataset(w, httptest.NewRequest(http.MethodGet, "/api/v1/datasets/prices", nil))
+	var dataset Dataset
+	json.NewDecoder(w.Result().Body).Decode(&dataset)
+	if len(dataset.Values) != 3 || dataset.Values[0] != "0.1" {
+		t.Fatalf("expected the values of prices, got %+v", dataset)
+	}
+	w = httptest.NewRecorder()
+	handleDataset(w, httptest.NewRequest(http.MethodGet, "/api/v1/datasets/missing", nil))
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Fatalf("expected %d, got %d", http.StatusNotFound, w.Result().StatusCode)
+	}
+}
+
+func TestDatasetIsSentWithTask(t *testing.T) {
+	resetGlobals()
+	upload(t, `{"name": "prices", "values": [0.1, 0.2, 0.6]}`)
+	if status, _ := submit(t, `{"expression": "mean(#missing)"}`); status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected an unknown dataset to be rejected, got %d", status)
+	}
+	submit(t, `{"expression": "mean(#prices) + 1", "mode": "decimal"}`)
+	submit(t, `{"expression": "sum(#prices)", "datasets": {"prices": [1, 2]}}`)
+	submit(t, `{"expression": "avg()", "functions": ["avg() = mean(#prices)"]}`)
+
+	resp := dispatch(t)
+	_, value, err := calc.Evaluate(resp.Task.Expression, resp.Task.Options)
+	if err != nil || value.Literal() != "1.3" {
+		t.Fatalf("expected the uploaded dataset to be sent, got %+v (%v)", value, err)
+	}
+	if tasks[1].Datasets != nil {
+		t.Fatalf("expected the task to keep its own options, got %+v", tasks[1].Datasets)
+	}
+	if resp := dispatch(t); len(resp.Task.Datasets["prices"]) != 2 {
+		t.Fatalf("expected an inline dataset to take precedence, got %+v", resp.Task.Datasets)
+	}
+	if resp := dispatch(t); len(resp.Task.Datasets["prices"]) != 3 {
+		t.Fatalf("expected datasets referenced by functions to be sent, got %+v", resp.Task.Datasets)
+	}
+}
+
+func TestDatasetAggregateIsSplitAcrossAgents(t *testing.T) {
+	resetGlobals()
+	values := make([]string, 40000)
+	for i := range values {
+		values[i] = "0.5"
+	}
+	values[0] = "20000.5"
+	upload(t, `{"name": "samples", "values": [`+strings.Join(values, ",")+`]}`)
+	submit(t, `{"expression": "mean(#samples)", "mode": "decimal"}`)
+	parent := tasks[1]
+	if parent.Status != "waiting" || len(parent.Parts) != partitions || parent.Combine != "+" {
+		t.Fatalf("expected task to be split into %d parts, got %+v", partitions, parent)
+	}
+
+	for range parent.Parts {
+		resp := dispatch(t)
+		part := tasks[resp.Task.ID]
+		if part.Parent != 1 || len(resp.Task.Datasets["samples"]) != 10000 || resp.Task.Count != 0 {
+			t.Fatalf("expected a slice of 10000 values, got %d for %+v", len(resp.Task.Datasets["samples"]), part)
+		}
+		_, value, err := calc.Evaluate(resp.Task.Expression, resp.Task.Options)
+		if err != nil {
+			t.Fatalf("evaluating part %q: %v", resp.Task.Expression, err)
+		}
+		report(t, ResultPayload{ID: resp.Task.ID, Result: value.Float64(), Value: value})
+	}
+	if parent.Status != "done" || parent.Value == nil || parent.Value.Decimal != "1" {
+		t.Fatalf("expected the mean 1, got %+v", parent)
+	}
+}
+
+func TestSmallDatasetIsNotSplit(t *testing.T) {
+	resetGlobals()
+	upload(t, `{"name": "xs", "values": [1, 2, 3]}`)
+	submit(t, `{"expression": "sum(#xs)"}`)
+	if resp := dispatch(t); resp.Task.ID != 1 || len(tasks) != 1 {
+		t.Fatalf("expected the task to be dispatched whole, got %+v", resp.Task)
+	}
+}
+
+func TestDatasetsAreSaved(t *testing.T) {
+	resetGlobals()
+	upload(t, `{"name": "xs", "values": [1, 2, 3]}`)
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := saveState(path); err != nil {
+		t.Fatal(err)
+	}
+	resetGlobals()
+	if err := loadState(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(datasets["xs"]) != 3 {
+		t.Fatalf("expected the dataset to be restored, got %+v", datasets)
+	}
+}
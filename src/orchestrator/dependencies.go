@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m4tveevm/GoCalc/calc"
+)
+
+var (
+	// labels maps the label of a calculation to its ID, so expressions can
+	// refer to it as @label.
+	labels = make(map[string]int)
+	// dependents maps the ID of a task to the waiting tasks that refer to
+	// it or are combined from it, and unresolved maps a label that is not
+	// defined yet to the waiting tasks that refer to it.
+	dependents = make(map[int][]int)
+	unresolved = make(map[string][]int)
+)
+
+// labelTimeout is how long a task waits for a label that is never defined
+// before it fails.
+var labelTimeout = 10 * time.Minute
+
+// resolveReference returns the calculation a $id or @label reference
+// names, or nil if there is none yet. The caller must hold mu.
+func resolveReference(ref string) *Calculation {
+	if strings.HasPrefix(ref, "$") {
+		id, _ := strconv.Atoi(ref[1:])
+		return tasks[id]
+	}
+	if id, ok := labels[strings.TrimPrefix(ref, "@")]; ok {
+		return tasks[id]
+	}
+	return nil
+}
+
+// reference returns the name other expressions can use for task.
+func reference(task *Calculation) string {
+	if task.Label != "" {
+		return "@" + task.Label
+	}
+	return "$" + strconv.Itoa(task.ID)
+}
+
+// resultExpression returns the result of a finished task as an expression
+// a dependent task can evaluate in its own mode.
+func resultExpression(task *Calculation) string {
+	if task.Value != nil {
+		return task.Value.Literal()
+	}
+	if task.Result != nil {
+		return calc.FormatFloat(*task.Result)
+	}
+	return ""
+}
+
+// schedule moves a waiting task on once its inputs allow: it fails as
+// soon as one of them has failed and is queued once all of them are done,
+// with their results as inputs. A task that was split is combined from
+// its parts instead. It reports whether the task's status changed. The
+// caller must hold mu.
+func schedule(task *Calculation) bool {
+	if len(task.Parts) > 0 {
+		return combine(task)
+	}
+	inputs := make(map[string]string, len(task.References))
+	ready := true
+	for _, ref := range task.References {
+		dep := resolveReference(ref)
+		switch {
+		case dep == nil:
+			ready = false
+		case dep.Status == "error":
+			task.Status = "error"
+			task.Error = fmt.Sprintf("dependency %s failed", ref)
+			observeFinished(task)
+			return true
+		case dep.Status == "done":
+			inputs[ref] = resultExpression(dep)
+		default:
+			ready = false
+		}
+	}
+	if !ready {
+		return false
+	}
+	if len(inputs) > 0 {
+		task.Inputs = inputs
+	}
+	task.Status = "pending"
+	queue = append(queue, task.ID)
+	return true
+}
+
+// track records what a waiting task waits for, so it is scheduled as soon
+// as one of those tasks finishes. The caller must hold mu.
+func track(task *Calculation) {
+	task.waitingSince = time.Now()
+	for _, id := range task.Parts {
+		dependents[id] = append(dependents[id], task.ID)
+	}
+	for _, ref := range task.References {
+		if dep := resolveReference(ref); dep != nil {
+			dependents[dep.ID] = append(dependents[dep.ID], task.ID)
+		} else {
+			label := strings.TrimPrefix(ref, "@")
+			unresolved[label] = append(unresolved[label], task.ID)
+		}
+	}
+}
+
+// define makes the tasks waiting for the label of task wait for task. The
+// caller must hold mu.
+func define(task *Calculation) {
+	if task.Label == "" {
+		return
+	}
+	dependents[task.ID] = append(dependents[task.ID], unresolved[task.Label]...)
+	delete(unresolved, task.Label)
+}
+
+// finished reports whether task is done or has failed.
+func finished(task *Calculation) bool {
+	return task.Status == "done" || task.Status == "error"
+}
+
+// resume schedules a waiting task and settles its dependents if that
+// finishes it. The caller must hold mu.
+func resume(task *Calculation) {
+	if task.Status == "waiting" && schedule(task) && finished(task) {
+		settle(task.ID)
+	}
+}
+
+// settle schedules the waiting dependents of the finished tasks with ids,
+// and those of every dependent that finishes in turn, so a failure
+// propagates through all its transitive dependents. The caller must hold
+// mu.
+func settle(ids ...int) {
+	for len(ids) > 0 {
+		id := ids[len(ids)-1]
+		ids = ids[:len(ids)-1]
+		for _, dep := range dependents[id] {
+			if task, ok := tasks[dep]; ok && task.Status == "waiting" && schedule(task) && finished(task) {
+				ids = append(ids, dep)
+			}
+		}
+		delete(dependents, id)
+	}
+}
+
+// expireLabels fails the tasks that have waited longer than labelTimeout
+// for a label that was never defined. The caller must hold mu.
+func expireLabels(now time.Time) {
+	for label, ids := range unresolved {
+		var waiting []int
+		for _, id := range ids {
+			task, ok := tasks[id]
+			if !ok || task.Status != "waiting" {
+				continue
+			}
+			if now.Sub(task.waitingSince) < labelTimeout {
+				waiting = append(waiting, id)
+				continue
+			}
+			task.Status = "error"
+			task.Error = fmt.Sprintf("label @%s was never defined", label)
+			observeFinished(task)
+			settle(id)
+		}
+		if len(waiting) > 0 {
+			unresolved[label] = waiting
+		} else {
+			delete(unresolved, label)
+		}
+	}
+}
+
+// watchLabels expires the tasks waiting for labels that were never defined
+// every interval.
+func watchLabels(interval time.Duration) {
+	for now := range time.Tick(interval) {
+		mu.Lock()
+		expireLabels(now)
+		mu.Unlock()
+	}
+}
+
+// findCycle returns the references leading from task back to itself, or
+// nil if task does not depend on itself. Cycles can only arise through
+// references to labels that did not exist when the referring expression
+// was submitted. The caller must hold mu.
+func findCycle(task *Calculation) []string {
+	var path []string
+	visited := make(map[int]bool)
+	var visit func(t *Calculation) bool
+	visit = func(t *Calculation) bool {
+		for _, ref := range t.References {
+			dep := resolveReference(ref)
+			if dep == nil {
+				continue
+			}
+			path = append(path, ref)
+			if dep == task {
+				return true
+			}
+			if !visited[dep.ID] {
+				visited[dep.ID] = true
+				if visit(dep) {
+					return true
+				}
+			}
+			path = path[:len(path)-1]
+		}
+		return false
+	}
+	if visit(task) {
+		return path
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func submit(t *testing.T, body string) (int, int) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handleCalculate(w, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBufferString(body)))
+	var out map[string]int
+	json.NewDecoder(w.Result().Body).Decode(&out)
+	return w.Result().StatusCode, out["id"]
+}
+
+func dispatch(t *testing.T) TaskResponse {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handleInternalTask(w, httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	var resp TaskResponse
+	json.NewDecoder(w.Result().Body).Decode(&resp)
+	return resp
+}
+
+func report(t *testing.T, res ResultPayload) {
+	t.Helper()
+	data, _ := json.Marshal(res)
+	handleInternalTask(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBuffer(data)))
+}
+
+func TestDependentDispatch(t *testing.T) {
+	resetGlobals()
+	submit(t, `{"expression": "100", "label": "revenue"}`)
+	if status, id := submit(t, `{"expression": "@revenue / @cost"}`); status != http.StatusCreated || id != 2 {
+		t.Fatalf("expected forward reference to be accepted, got %d", status)
+	}
+	submit(t, `{"expression": "40", "label": "cost"}`)
+	if tasks[2].Status != "waiting" {
+		t.Fatalf("expected dependent task to wait, got %s", tasks[2].Status)
+	}
+
+	for _, want := range []int{1, 3} {
+		if resp := dispatch(t); resp.Task.ID != want {
+			t.Fatalf("expected task %d to be dispatched, got %d", want, resp.Task.ID)
+		}
+	}
+	report(t, ResultPayload{ID: 1, Result: 100})
+	if tasks[2].Status != "waiting" {
+		t.Fatalf("expected task to wait for @cost, got %s", tasks[2].Status)
+	}
+	report(t, ResultPayload{ID: 3, Result: 40})
+
+	resp := dispatch(t)
+	if resp.Task.ID != 2 || resp.Task.Inputs["@revenue"] != "100" || resp.Task.Inputs["@cost"] != "40" {
+		t.Fatalf("expected task 2 with inputs, got %+v", resp.Task)
+	}
+}
+
+func TestDependencyFailurePropagates(t *testing.T) {
+	resetGlobals()
+	submit(t, `{"expression": "1/0"}`)
+	submit(t, `{"expression": "$1 * 2"}`)
+	submit(t, `{"expression": "$2 + 1", "label": "total"}`)
+	dispatch(t)
+	report(t, ResultPayload{ID: 1, Error: "division by zero"})
+	if tasks[1].Status != "error" || tasks[1].Error != "division by zero" {
+		t.Fatalf("expected task 1 to fail, got %+v", tasks[1])
+	}
+	for _, id := range []int{2, 3} {
+		if tasks[id].Status != "error" {
+			t.Fatalf("expected task %d to fail, got %s", id, tasks[id].Status)
+		}
+	}
+	if status, _ := submit(t, `{"expression": "@total"}`); status != http.StatusCreated || tasks[4].Status != "error" {
+		t.Fatalf("expected new dependent of a failed task to fail, got %d, %s", status, tasks[4].Status)
+	}
+	if len(queue) != 0 {
+		t.Fatalf("expected no tasks queued, got %v", queue)
+	}
+}
+
+func TestDependencyRejections(t *testing.T) {
+	resetGlobals()
+	testCases := []struct {
+		body   string
+		status int
+	}{
+		{`{"expression": "$9 + 1"}`, http.StatusUnprocessableEntity},
+		{`{"expression": "$ + 1"}`, http.StatusUnprocessableEntity},
+		{`{"expression": "1", "label": "2x"}`, http.StatusUnprocessableEntity},
+		{`{"expression": "@z + 1", "label": "z"}`, http.StatusUnprocessableEntity},
+		{`{"expression": "@y + 1", "label": "x"}`, http.StatusCreated},
+		{`{"expression": "@x * 2", "label": "y"}`, http.StatusUnprocessableEntity},
+		{`{"expression": "1", "label": "x"}`, http.StatusConflict},
+	}
+	for _, tc := range testCases {
+		if status, _ := submit(t, tc.body); status != tc.status {
+			t.Errorf("expected %d for %s, got %d", tc.status, tc.body, status)
+		}
+	}
+	if len(tasks) != 1 || nextID != 2 {
+		t.Fatalf("expected only task x to be stored, got %d tasks, next id %d", len(tasks), nextID)
+	}
+}
+
+func TestUndefinedLabelExpires(t *testing.T) {
+	resetGlobals()
+	submit(t, `{"expression": "@missing * 2"}`)
+	submit(t, `{"expression": "$1 + 1"}`)
+	submit(t, `{"expression": "@late + 1"}`)
+	mu.Lock()
+	defer mu.Unlock()
+	expireLabels(time.Now())
+	if tasks[1].Status != "waiting" {
+		t.Fatalf("expected task 1 to wait before the timeout, got %s", tasks[1].Status)
+	}
+	tasks[1].waitingSince = time.Now().Add(-labelTimeout)
+	expireLabels(time.Now())
+	if tasks[1].Status != "error" || tasks[1].Error != "label @missing was never defined" {
+		t.Fatalf("expected task 1 to fail, got %+v", tasks[1])
+	}
+	if tasks[2].Status != "error" || tasks[2].Error != "dependency $1 failed" {
+		t.Fatalf("expected dependent task 2 to fail, got %+v", tasks[2])
+	}
+	if tasks[3].Status != "waiting" || len(unresolved) != 1 {
+		t.Fatalf("expected task 3 to keep waiting, got %s, unresolved %v", tasks[3].Status, unresolved)
+	}
+}
+
+func TestWaitingTasksRestored(t *testing.T) {
+	resetGlobals()
+	submit(t, `{"expression": "@later * 2"}`)
+	submit(t, `{"expression": "3"}`)
+	submit(t, `{"expression": "$2 + 1"}`)
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := saveState(path); err != nil {
+		t.Fatal(err)
+	}
+	resetGlobals()
+	if err := loadState(path); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := submit(t, `{"expression": "5", "label": "later"}`); status != http.StatusCreated {
+		t.Fatalf("expected label to be accepted, got %d", status)
+	}
+	for _, id := range []int{2, 4} {
+		dispatch(t)
+		report(t, ResultPayload{ID: id, Result: float64(id) + 1})
+	}
+	for _, id := range []int{1, 3} {
+		if tasks[id].Status != "pending" {
+			t.Fatalf("expected restored task %d to be queued, got %s", id, tasks[id].Status)
+		}
+	}
+}
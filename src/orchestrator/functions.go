@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/m4tveevm/GoCalc/calc"
+)
+
+// userFunctions holds the functions each user has saved, by user and
+// function name, with the syntax they are written in. Users are
+// identified by the X-User-ID header.
+var userFunctions = make(map[string]map[string]calc.Definition)
+
+// FunctionRequest is a definition to save, written in the syntax its mode,
+// dialect, lenient and locale select, like an expression passed to
+// /api/v1/calculate with those options.
+type FunctionRequest = calc.Definition
+
+type SavedFunction struct {
+	Name string `json:"name"`
+	calc.Definition
+}
+
+// handleFunctions lists (GET) and saves (POST) the functions of the user
+// named in X-User-ID.
+func handleFunctions(writer http.ResponseWriter, request *http.Request) {
+	user := request.Header.Get("X-User-ID")
+	if user == "" {
+		http.Error(writer, `{"error":"X-User-ID header required"}`, http.StatusBadRequest)
+		return
+	}
+	switch request.Method {
+	case http.MethodGet:
+		mu.Lock()
+		list := []SavedFunction{}
+		for name, d := range userFunctions[user] {
+			list = append(list, SavedFunction{Name: name, Definition: d})
+		}
+		mu.Unlock()
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(map[string][]SavedFunction{"functions": list})
+	case http.MethodPost:
+		var req FunctionRequest
+		if err := json.NewDecoder(request.Body).Decode(&req); err != nil || req.Definition == "" {
+			http.Error(writer, `{"error":"Invalid definition"}`, http.StatusUnprocessableEntity)
+			return
+		}
+		name, err := req.Parse()
+		if err != nil {
+			http.Error(writer, errorJSON(err.Error()), http.StatusUnprocessableEntity)
+			return
+		}
+		mu.Lock()
+		if userFunctions[user] == nil {
+			userFunctions[user] = make(map[string]calc.Definition)
+		}
+		userFunctions[user][name] = req
+		mu.Unlock()
+		slog.Info("function saved", "user", user, "function", name)
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusCreated)
+		json.NewEncoder(writer).Encode(SavedFunction{Name: name, Definition: req})
+	default:
+		http.Error(writer, `{"error":"Method not allowed"}`, http.StatusMethodNotAllowed)
+	}
+}
+
+// handleFunction returns (GET) or deletes (DELETE) one saved function of
+// the user named in X-User-ID.
+func handleFunction(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodDelete {
+		http.Error(writer, `{"error":"Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	user := request.Header.Get("X-User-ID")
+	if user == "" {
+		http.Error(writer, `{"error":"X-User-ID header required"}`, http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimPrefix(request.URL.Path, "/api/v1/functions/")
+	if name == "" {
+		http.Error(writer, `{"error":"Name not provided"}`, http.StatusBadRequest)
+		return
+	}
+	mu.Lock()
+	d, exists := userFunctions[user][name]
+	if exists && request.Method == http.MethodDelete {
+		delete(userFunctions[user], name)
+	}
+	mu.Unlock()
+	if !exists {
+		http.Error(writer, `{"error":"Not found"}`, http.StatusNotFound)
+		return
+	}
+	if request.Method == http.MethodDelete {
+		slog.Info("function deleted", "user", user, "function", name)
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(SavedFunction{Name: name, Definition: d})
+}
+
+// scopeDefinitions returns the definitions an expression submitted by
+// user can call besides its own functions: the user's saved functions,
+// ordered by name, followed by definitions. The caller must hold mu.
+func scopeDefinitions(user string, definitions []calc.Definition) []calc.Definition {
+	saved := userFunctions[user]
+	names := make([]string, 0, len(saved))
+	for name := range saved {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var result []calc.Definition
+	for _, name := range names {
+		result = append(result, saved[name])
+	}
+	return append(result, definitions...)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/m4tveevm/GoCalc/calc"
+)
+
+func functionRequest(method, path, user, body string) *http.Request {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	if user != "" {
+		req.Header.Set("X-User-ID", user)
+	}
+	return req
+}
+
+func TestSavedFunctions(t *testing.T) {
+	resetGlobals()
+	w := httptest.NewRecorder()
+	handleFunctions(w, functionRequest(http.MethodPost, "/api/v1/functions", "alice", `{"definition": "f(x) = x^2 + 1"}`))
+	if w.Result().StatusCode != http.StatusCreated {
+		t.Fatalf("expected %d, got %d", http.StatusCreated, w.Result().StatusCode)
+	}
+	var saved SavedFunction
+	json.NewDecoder(w.Result().Body).Decode(&saved)
+	if saved.Name != "f" {
+		t.Fatalf("expected function f, got %+v", saved)
+	}
+
+	w = httptest.NewRecorder()
+	handleFunctions(w, functionRequest(http.MethodGet, "/api/v1/functions", "bob", ""))
+	var list map[string][]SavedFunction
+	json.NewDecoder(w.Result().Body).Decode(&list)
+	if len(list["functions"]) != 0 {
+		t.Fatalf("expected bob to have no functions, got %+v", list)
+	}
+
+	w = httptest.NewRecorder()
+	handleCalculate(w, functionRequest(http.MethodPost, "/api/v1/calculate", "alice", `{"expression": "f(3) * 2"}`))
+	if w.Result().StatusCode != http.StatusCreated {
+		t.Fatalf("expected %d, got %d", http.StatusCreated, w.Result().StatusCode)
+	}
+	wTask := httptest.NewRecorder()
+	handleInternalTask(wTask, httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	var taskResp TaskResponse
+	json.NewDecoder(wTask.Result().Body).Decode(&taskResp)
+	if len(taskResp.Task.Definitions) != 1 || taskResp.Task.Definitions[0] != (calc.Definition{Definition: "f(x) = x^2 + 1"}) {
+		t.Fatalf("saved function not dispatched: %+v", taskResp.Task)
+	}
+
+	w = httptest.NewRecorder()
+	handleFunction(w, functionRequest(http.MethodDelete, "/api/v1/functions/f", "alice", ""))
+	if w.Result().StatusCode != http.StatusNoContent {
+		t.Fatalf("expected %d, got %d", http.StatusNoContent, w.Result().StatusCode)
+	}
+	w = httptest.NewRecorder()
+	handleFunction(w, functionRequest(http.MethodGet, "/api/v1/functions/f", "alice", ""))
+	if w.Result().StatusCode != http.StatusNotFound {
+		t.Fatalf("expected %d, got %d", http.StatusNotFound, w.Result().StatusCode)
+	}
+}
+
+func TestSavedFunctionsInvalid(t *testing.T) {
+	resetGlobals()
+	w := httptest.NewRecorder()
+	handleFunctions(w, functionRequest(http.MethodGet, "/api/v1/functions", "", ""))
+	if w.Result().StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected %d without user, got %d", http.StatusBadRequest, w.Result().StatusCode)
+	}
+	for _, body := range []string{`{"definition": "x + 1"}`, `{"definition": "f(x) ="}`, `{}`} {
+		w = httptest.NewRecorder()
+		handleFunctions(w, functionRequest(http.MethodPost, "/api/v1/functions", "alice", body))
+		if w.Result().StatusCode != http.StatusUnprocessableEntity {
+			t.Fatalf("expected %d for %s, got %d", http.StatusUnprocessableEntity, body, w.Result().StatusCode)
+		}
+	}
+}
+
+func TestSavedFunctionsOfOtherSyntaxes(t *testing.T) {
+	resetGlobals()
+	for _, body := range []string{
+		`{"definition": "sq(x) = x^2"}`,
+		`{"definition": "ke(m, v) = m v^2 / 2", "mode": "units"}`,
+		`{"definition": "speed(d) = d / 2 h", "mode": "units"}`,
+		`{"definition": "half(x) = x * 0,5", "locale": "de"}`,
+	} {
+		w := httptest.NewRecorder()
+		handleFunctions(w, functionRequest(http.MethodPost, "/api/v1/functions", "alice", body))
+		if w.Result().StatusCode != http.StatusCreated {
+			t.Fatalf("expected %d for %s, got %d", http.StatusCreated, body, w.Result().StatusCode)
+		}
+	}
+	// A definition is only accepted in the syntax it is saved with.
+	w := httptest.NewRecorder()
+	handleFunctions(w, functionRequest(http.MethodPost, "/api/v1/functions", "alice", `{"definition": "ke(m, v) = m v^2 / 2"}`))
+	if w.Result().StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected %d for the units syntax in float mode, got %d", http.StatusUnprocessableEntity, w.Result().StatusCode)
+	}
+	for _, body := range []string{
+		`{"expression": "sq(3)", "mode": "integer"}`,
+		`{"expression": "ke(2 kg, 3 m/s)", "mode": "units"}`,
+		`{"expression": "ke(2, 3) + half(3)"}`,
+		`{"expression": "speed(10)"}`,
+	} {
+		handleCalculate(httptest.NewRecorder(), functionRequest(http.MethodPost, "/api/v1/calculate", "alice", body))
+	}
+	for range 4 {
+		resp := dispatch(t)
+		result, value, err := calc.Evaluate(resp.Task.Expression, resp.Task.Options)
+		payload := ResultPayload{ID: resp.Task.ID, Result: result, Value: value}
+		if err != nil {
+			payload = ResultPayload{ID: resp.Task.ID, Error: err.Error()}
+		}
+		report(t, payload)
+	}
+	if task := tasks[1]; task.Value == nil || task.Value.Integer != "9" {
+		t.Fatalf("expected sq to square in integer mode, got %+v", task)
+	}
+	if task := tasks[2]; task.Value == nil || task.Value.Literal() != "9 kg*m^2/s^2" {
+		t.Fatalf("expected ke in units mode, got %+v", task)
+	}
+	if task := tasks[3]; task.Result == nil || *task.Result != 10.5 {
+		t.Fatalf("expected ke and half in float mode, got %+v", task)
+	}
+	if task := tasks[4]; task.Status != "error" {
+		t.Fatalf("expected a function using units to fail in float mode, got %+v", task)
+	}
+}
+
+func TestSavedFunctionsPersisted(t *testing.T) {
+	resetGlobals()
+	handleFunctions(httptest.NewRecorder(), functionRequest(http.MethodPost, "/api/v1/functions", "alice", `{"definition": "g(x) = 2 * x", "mode": "integer"}`))
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := saveState(path); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+	resetGlobals()
+	if err := loadState(path); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if userFunctions["alice"]["g"] != (calc.Definition{Definition: "g(x) = 2 * x", Mode: calc.ModeInteger}) {
+		t.Fatalf("expected saved function to be restored, got %v", userFunctions)
+	}
+
+	// Snapshots from before functions kept their syntax are read in that
+	// of float mode.
+	if err := os.WriteFile(path, []byte(`{"next_id": 1, "functions": {"bob": {"sq": "sq(x) = x^2"}}}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	resetGlobals()
+	if err := loadState(path); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if userFunctions["bob"]["sq"] != (calc.Definition{Definition: "sq(x) = x^2"}) {
+		t.Fatalf("expected a function of an old snapshot to be restored, got %v", userFunctions)
+	}
+}
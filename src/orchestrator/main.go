@@ -1,20 +1,65 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
-	"log"
+	"errors"
+	"io"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+
+	"github.com/m4tveevm/GoCalc/calc"
+	"github.com/m4tveevm/GoCalc/logging"
+	"github.com/m4tveevm/GoCalc/tracing"
 )
 
 type Calculation struct {
-	ID         int      `json:"id"`
-	Expression string   `json:"expression"`
-	Status     string   `json:"status"`
-	Result     *float64 `json:"result,omitempty"`
+	ID         int         `json:"id"`
+	Expression string      `json:"expression"`
+	Label      string      `json:"label,omitempty"`
+	Status     string      `json:"status"`
+	Result     *float64    `json:"result,omitempty"`
+	Value      *calc.Value `json:"value,omitempty"`
+	// Unit is the unit of Result in units mode.
+	Unit string `json:"unit,omitempty"`
+	// Formatted is the result written with the separators of the task's
+	// locale, if it has one.
+	Formatted string `json:"formatted,omitempty"`
+	Error     string `json:"error,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+	// References are the $id and @label references in Expression. The
+	// task waits until all of them are done.
+	References []string `json:"references,omitempty"`
+	// Parts are the tasks computing consecutive parts of the range of an
+	// integrate, sum or prod, blocks of rows of a matrix product, or
+	// slices of a dataset, whose results are combined with the operator or
+	// function Combine. Parent is the task a part belongs to.
+	Parts   []int  `json:"parts,omitempty"`
+	Combine string `json:"combine,omitempty"`
+	Parent  int    `json:"parent,omitempty"`
+	// Sweep is the sweep a chunk of Count points from Offset on belongs
+	// to, and Samples are the outcomes at those points. Dataset is the
+	// uploaded dataset a part covers Count values of from Offset on.
+	Sweep   int           `json:"sweep,omitempty"`
+	Dataset string        `json:"dataset,omitempty"`
+	Offset  int           `json:"offset,omitempty"`
+	Count   int           `json:"count,omitempty"`
+	Samples []calc.Sample `json:"samples,omitempty"`
+	calc.Options
+
+	submittedAt  time.Time
+	dispatchedAt time.Time
+	waitingSince time.Time
+	spanContext  tracing.SpanContext
 }
 
 var (
@@ -22,22 +67,70 @@ var (
 	nextID = 1
 	tasks  = make(map[int]*Calculation)
 	queue  []int
+
+	// draining stops handing out tasks to agents; shuttingDown also
+	// rejects new submissions while the server is going away.
+	draining     bool
+	shuttingDown bool
+
+	stateFile string
+
+	tracer = tracing.NewTracer("orchestrator", nil)
 )
 
+const retryAfterSeconds = "5"
+
+// shutdownDrain is how long the orchestrator keeps serving after SIGTERM,
+// answering submissions with 503 and failing /readyz, before it stops
+// accepting connections.
+var shutdownDrain = 5 * time.Second
+
 type CalcRequest struct {
 	Expression string `json:"expression"`
+	Label      string `json:"label,omitempty"`
+	calc.Options
 }
 
 type TaskResponse struct {
 	Task struct {
 		ID         int    `json:"id"`
 		Expression string `json:"expression"`
+		RequestID  string `json:"request_id,omitempty"`
+		// Grid, Offset and Count are set for a chunk of a sweep.
+		Grid   calc.Grid `json:"grid,omitempty"`
+		Offset int       `json:"offset,omitempty"`
+		Count  int       `json:"count,omitempty"`
+		calc.Options
 	} `json:"task"`
 }
 
 type ResultPayload struct {
-	ID     int     `json:"id"`
-	Result float64 `json:"result"`
+	ID     int         `json:"id"`
+	Result float64     `json:"result"`
+	Value  *calc.Value `json:"value,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	// Samples are the outcomes at the points of a chunk of a sweep.
+	Samples []calc.Sample `json:"samples,omitempty"`
+}
+
+// errorJSON formats msg as the JSON error body used by all handlers.
+func errorJSON(msg string) string {
+	data, _ := json.Marshal(map[string]string{"error": msg})
+	return string(data)
+}
+
+// newRequestID returns a random identifier used to correlate the log
+// lines of one expression across the orchestrator and the agents.
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// agentLogger returns a logger tagged with the agent and worker
+// identifiers an agent sends with its requests.
+func agentLogger(request *http.Request) *slog.Logger {
+	return slog.With("agent_id", request.Header.Get("X-Agent-ID"), "worker_id", request.Header.Get("X-Worker-ID"))
 }
 
 func handleCalculate(writer http.ResponseWriter, request *http.Request) {
@@ -45,23 +138,108 @@ func handleCalculate(writer http.ResponseWriter, request *http.Request) {
 		http.Error(writer, `{"error":"Method not allowed"}`, http.StatusMethodNotAllowed)
 		return
 	}
+	_, span := tracer.Start(tracing.Extract(request.Context(), request.Header), "calculate", tracing.WithKind(tracing.KindServer))
+	defer span.End()
 	var req CalcRequest
 	if err := json.NewDecoder(request.Body).Decode(&req); err != nil || req.Expression == "" {
+		span.RecordError(errors.New("invalid expression"))
 		http.Error(writer, `{"error":"Invalid expression"}`, http.StatusUnprocessableEntity)
 		return
 	}
+	if err := req.Options.Validate(); err != nil {
+		span.RecordError(err)
+		http.Error(writer, errorJSON(err.Error()), http.StatusUnprocessableEntity)
+		return
+	}
+	refs, err := calc.References(req.Expression)
+	if err != nil {
+		span.RecordError(err)
+		http.Error(writer, errorJSON(err.Error()), http.StatusUnprocessableEntity)
+		return
+	}
+	if req.Label != "" && !calc.IsLabel(req.Label) {
+		span.RecordError(errors.New("invalid label"))
+		http.Error(writer, `{"error":"Invalid label"}`, http.StatusUnprocessableEntity)
+		return
+	}
 	mu.Lock()
+	if shuttingDown {
+		mu.Unlock()
+		writer.Header().Set("Retry-After", retryAfterSeconds)
+		http.Error(writer, `{"error":"Server is shutting down"}`, http.StatusServiceUnavailable)
+		return
+	}
+	req.Definitions = scopeDefinitions(request.Header.Get("X-User-ID"), req.Definitions)
+	if err := checkDatasets(req.Expression, req.Options); err != nil {
+		mu.Unlock()
+		span.RecordError(err)
+		http.Error(writer, errorJSON(err.Error()), http.StatusUnprocessableEntity)
+		return
+	}
+	if _, exists := labels[req.Label]; exists {
+		mu.Unlock()
+		http.Error(writer, `{"error":"Label already in use"}`, http.StatusConflict)
+		return
+	}
+	for _, ref := range refs {
+		if strings.HasPrefix(ref, "$") && resolveReference(ref) == nil {
+			mu.Unlock()
+			http.Error(writer, errorJSON("unknown reference "+ref), http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	requestID := request.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = newRequestID()
+	}
 	id := nextID
 	nextID++
 	task := &Calculation{
 		ID:         id,
 		Expression: req.Expression,
+		Label:      req.Label,
 		Status:     "pending",
+		RequestID:  requestID,
+		References: refs,
+		Options:    req.Options,
+
+		submittedAt: time.Now(),
+		spanContext: span.Context(),
 	}
 	tasks[id] = task
-	queue = append(queue, id)
+	if task.Label != "" {
+		labels[task.Label] = id
+	}
+	if cycle := findCycle(task); cycle != nil {
+		delete(tasks, id)
+		delete(labels, task.Label)
+		nextID--
+		mu.Unlock()
+		msg := "dependency cycle: " + reference(task) + " -> " + strings.Join(cycle, " -> ")
+		span.RecordError(errors.New(msg))
+		http.Error(writer, errorJSON(msg), http.StatusUnprocessableEntity)
+		return
+	}
+	define(task)
+	if len(refs) > 0 {
+		task.Status = "waiting"
+		track(task)
+		resume(task)
+	} else if parts, op := calc.Partition(task.Expression, partitions, task.Options); parts != nil {
+		split(task, parts, op)
+	} else if parts, name, op := calc.PartitionDataset(task.Expression, partitions, func(name string) int {
+		return datasetSize(name, task.Options)
+	}, task.Options); parts != nil {
+		splitDataset(task, name, parts, op)
+	} else {
+		queue = append(queue, id)
+	}
 	mu.Unlock()
+	submissionsTotal.Inc()
+	span.SetAttributes("task_id", id, "request_id", requestID)
+	slog.Info("expression accepted", "task_id", id, "request_id", requestID, "expression", req.Expression)
 
+	writer.Header().Set("X-Request-ID", requestID)
 	writer.Header().Set("Content-Type", "application/json")
 	writer.WriteHeader(http.StatusCreated)
 	json.NewEncoder(writer).Encode(map[string]int{"id": id})
@@ -75,7 +253,8 @@ func handleListExpressions(writer http.ResponseWriter, request *http.Request) {
 	mu.Lock()
 	var list []*Calculation
 	for i := 1; i < nextID; i++ {
-		if task, ok := tasks[i]; ok {
+		// Chunks of sweeps are listed with their sweep.
+		if task, ok := tasks[i]; ok && task.Sweep == 0 {
 			list = append(list, task)
 		}
 	}
@@ -113,6 +292,13 @@ func handleGetExpression(writer http.ResponseWriter, request *http.Request) {
 func handleInternalTask(writer http.ResponseWriter, request *http.Request) {
 	if request.Method == http.MethodGet {
 		mu.Lock()
+		if draining || shuttingDown {
+			mu.Unlock()
+			writer.Header().Set("Retry-After", retryAfterSeconds)
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(writer).Encode(map[string]string{"error": "Dispatch is paused"})
+			return
+		}
 		if len(queue) == 0 {
 			mu.Unlock()
 			writer.WriteHeader(http.StatusNotFound)
@@ -123,29 +309,71 @@ func handleInternalTask(writer http.ResponseWriter, request *http.Request) {
 		queue = queue[1:]
 		task := tasks[id]
 		task.Status = "in_progress"
+		task.dispatchedAt = time.Now()
+		var grid calc.Grid
+		if sweep, ok := sweeps[task.Sweep]; ok {
+			grid = sweep.Variables
+		}
+		opts := taskOptions(task)
 		mu.Unlock()
+		observeSince(dispatchWait, task.submittedAt)
+		traceDispatch(writer, request, task)
+		agentLogger(request).Info("task dispatched", "task_id", task.ID, "request_id", task.RequestID)
 
 		writer.Header().Set("Content-Type", "application/json")
 		var resp TaskResponse
 		resp.Task.ID = task.ID
 		resp.Task.Expression = task.Expression
+		resp.Task.RequestID = task.RequestID
+		if task.Sweep != 0 {
+			resp.Task.Grid = grid
+			resp.Task.Offset = task.Offset
+			resp.Task.Count = task.Count
+		}
+		resp.Task.Options = opts
 		json.NewEncoder(writer).Encode(resp)
 	} else if request.Method == http.MethodPost {
+		_, span := tracer.Start(tracing.Extract(request.Context(), request.Header), "result", tracing.WithKind(tracing.KindServer))
+		defer span.End()
 		var res ResultPayload
 		if err := json.NewDecoder(request.Body).Decode(&res); err != nil {
+			span.RecordError(err)
 			http.Error(writer, `{"error":"Invalid data"}`, http.StatusUnprocessableEntity)
 			return
 		}
+		span.SetAttributes("task_id", res.ID)
 		mu.Lock()
 		task, exists := tasks[res.ID]
 		if !exists {
 			mu.Unlock()
+			agentLogger(request).Warn("result for unknown task", "task_id", res.ID)
 			http.Error(writer, `{"error":"Task not found"}`, http.StatusNotFound)
 			return
 		}
-		task.Result = &res.Result
-		task.Status = "done"
+		reported := task.Status == "done" || task.Status == "error"
+		if !reported {
+			observeSince(resultWait, task.dispatchedAt)
+		}
+		switch {
+		case res.Error != "":
+			task.Status = "error"
+			task.Error = res.Error
+		case task.Sweep != 0:
+			task.Status = "done"
+			task.Samples = res.Samples
+		default:
+			complete(task, res.Result, res.Value)
+		}
+		if !reported {
+			observeFinished(task)
+		}
+		if task.Sweep != 0 && !reported {
+			recordChunk(task)
+		}
+		settle(task.ID)
+		requestID := task.RequestID
 		mu.Unlock()
+		agentLogger(request).Info("result accepted", "task_id", res.ID, "request_id", requestID, "error", res.Error)
 		writer.WriteHeader(http.StatusOK)
 		json.NewEncoder(writer).Encode(map[string]string{"status": "result accepted"})
 	} else {
@@ -153,12 +381,127 @@ func handleInternalTask(writer http.ResponseWriter, request *http.Request) {
 	}
 }
 
+// traceDispatch records the time task spent queued and its dispatch as
+// children of the submission span, and passes the dispatch span to the
+// agent in the traceparent response header.
+func traceDispatch(writer http.ResponseWriter, request *http.Request, task *Calculation) {
+	parent := tracing.ContextWithSpanContext(request.Context(), task.spanContext)
+	if !task.submittedAt.IsZero() {
+		_, queued := tracer.Start(parent, "queue", tracing.WithStartTime(task.submittedAt))
+		queued.SetAttributes("task_id", task.ID)
+		queued.End()
+	}
+	ctx, span := tracer.Start(parent, "dispatch", tracing.WithKind(tracing.KindServer))
+	span.SetAttributes("task_id", task.ID, "agent_id", request.Header.Get("X-Agent-ID"))
+	tracing.Inject(ctx, writer.Header())
+	span.End()
+}
+
+// handleDrain pauses (POST) or resumes (DELETE) dispatching tasks to
+// agents. Submissions are still accepted while drained, so agents can be
+// rolled without losing work.
+func handleDrain(writer http.ResponseWriter, request *http.Request) {
+	mu.Lock()
+	switch request.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		draining = true
+	case http.MethodDelete:
+		draining = false
+	default:
+		mu.Unlock()
+		http.Error(writer, `{"error":"Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	state := draining
+	mu.Unlock()
+	slog.Info("drain state", "draining", state)
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(map[string]bool{"draining": state})
+}
+
+func handleHealthz(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(map[string]string{"status": "ok"})
+}
+
+// handleReadyz reports whether the orchestrator should receive traffic:
+// its storage must be writable and it must not be draining or shutting down.
+func handleReadyz(writer http.ResponseWriter, request *http.Request) {
+	mu.Lock()
+	paused := draining || shuttingDown
+	mu.Unlock()
+	writer.Header().Set("Content-Type", "application/json")
+	if err := checkStorage(stateFile); err != nil {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(writer).Encode(map[string]string{"status": "storage unavailable", "error": err.Error()})
+		return
+	}
+	if paused {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(writer).Encode(map[string]string{"status": "draining"})
+		return
+	}
+	json.NewEncoder(writer).Encode(map[string]string{"status": "ready"})
+}
+
 func main() {
+	logger, err := logging.FromEnv()
+	if err != nil {
+		slog.Error("invalid logging configuration", "error", err)
+		os.Exit(1)
+	}
+	slog.SetDefault(logger)
+
+	var closeTraces io.Closer
+	tracer, closeTraces, err = tracing.FromEnv("orchestrator")
+	if err != nil {
+		slog.Error("invalid tracing configuration", "error", err)
+		os.Exit(1)
+	}
+	defer closeTraces.Close()
+
+	stateFile = os.Getenv("STATE_FILE")
+	if val := os.Getenv("RANGE_PARTITIONS"); val != "" {
+		if n, err := strconv.Atoi(val); err == nil && n > 0 {
+			partitions = n
+		}
+	}
+	if val := os.Getenv("LABEL_TIMEOUT"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil && d > 0 {
+			labelTimeout = d
+		}
+	}
+	if val := os.Getenv("SNAPSHOT_INTERVAL"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil && d > 0 {
+			snapshotInterval = d
+		}
+	}
+	if val := os.Getenv("SHUTDOWN_DRAIN"); val != "" {
+		if d, err := time.ParseDuration(val); err == nil && d >= 0 {
+			shutdownDrain = d
+		}
+	}
+	if err := loadState(stateFile); err != nil {
+		slog.Error("error loading state", "path", stateFile, "error", err)
+		os.Exit(1)
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/v1/calculate", handleCalculate)
 	mux.HandleFunc("/api/v1/expressions", handleListExpressions)
 	mux.HandleFunc("/api/v1/expressions/", handleGetExpression)
+	mux.HandleFunc("/api/v1/functions", handleFunctions)
+	mux.HandleFunc("/api/v1/functions/", handleFunction)
+	mux.HandleFunc("/api/v1/datasets", handleDatasets)
+	mux.HandleFunc("/api/v1/datasets/", handleDataset)
+	mux.HandleFunc("/api/v1/sweeps", handleSweeps)
+	mux.HandleFunc("/api/v1/sweeps/", handleSweep)
 	mux.HandleFunc("/internal/task", handleInternalTask)
+	mux.HandleFunc("/admin/drain", handleDrain)
+	mux.Handle("/metrics", registry)
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz)
 
 	srv := &http.Server{
 		Addr:         ":8080",
@@ -166,6 +509,34 @@ func main() {
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
 	}
-	log.Println("Orchestrator running on :8080")
-	log.Fatal(srv.ListenAndServe())
+
+	go watchLabels(min(labelTimeout, time.Minute))
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	go snapshotState(ctx, snapshotInterval)
+	go func() {
+		slog.Info("orchestrator running", "addr", srv.Addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+	<-ctx.Done()
+
+	slog.Info("shutting down orchestrator", "drain", shutdownDrain)
+	mu.Lock()
+	shuttingDown = true
+	mu.Unlock()
+	// Clients get a 503 with Retry-After instead of a refused connection
+	// while load balancers notice /readyz failing.
+	time.Sleep(shutdownDrain)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("error shutting down server", "error", err)
+	}
+	if err := saveState(stateFile); err != nil {
+		slog.Error("error saving state", "path", stateFile, "error", err)
+	}
 }
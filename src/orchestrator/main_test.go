@@ -3,9 +3,15 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+
+	"github.com/m4tveevm/GoCalc/calc"
+	"github.com/m4tveevm/GoCalc/tracing"
 )
 
 func resetGlobals() {
@@ -14,6 +20,16 @@ func resetGlobals() {
 	nextID = 1
 	tasks = make(map[int]*Calculation)
 	queue = []int{}
+	userFunctions = make(map[string]map[string]calc.Definition)
+	datasets = make(map[string][]json.Number)
+	labels = make(map[string]int)
+	dependents = make(map[int][]int)
+	unresolved = make(map[string][]int)
+	sweeps = make(map[int]*Sweep)
+	nextSweepID = 1
+	draining = false
+	shuttingDown = false
+	stateFile = ""
 }
 
 func TestHandleCalculate(t *testing.T) {
@@ -189,3 +205,491 @@ func TestHandleListExpressionsExtra(t *testing.T) {
 		t.Fatalf("expected id sum 6, got %d", idSum)
 	}
 }
+
+func TestHandleCalculateShuttingDown(t *testing.T) {
+	resetGlobals()
+	mu.Lock()
+	shuttingDown = true
+	mu.Unlock()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBufferString(`{"expression":"1+1"}`))
+	w := httptest.NewRecorder()
+	handleCalculate(w, req)
+	res := w.Result()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected %d, got %d", http.StatusServiceUnavailable, res.StatusCode)
+	}
+	if res.Header.Get("Retry-After") == "" {
+		t.Fatal("expected Retry-After header")
+	}
+}
+
+func TestHandleDrain(t *testing.T) {
+	resetGlobals()
+	body := bytes.NewBufferString(`{"expression": "1+1"}`)
+	handleCalculate(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/calculate", body))
+
+	w := httptest.NewRecorder()
+	handleDrain(w, httptest.NewRequest(http.MethodPost, "/admin/drain", nil))
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, w.Result().StatusCode)
+	}
+	wTask := httptest.NewRecorder()
+	handleInternalTask(wTask, httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	if wTask.Result().StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected %d while draining, got %d", http.StatusServiceUnavailable, wTask.Result().StatusCode)
+	}
+
+	handleDrain(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/admin/drain", nil))
+	wTask = httptest.NewRecorder()
+	handleInternalTask(wTask, httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	if wTask.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected %d after resume, got %d", http.StatusOK, wTask.Result().StatusCode)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	resetGlobals()
+	body := bytes.NewBufferString(`{"expression": "1+1"}`)
+	handleCalculate(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/calculate", body))
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := w.Body.String()
+	for _, line := range []string{"gocalc_queue_depth 1", `gocalc_tasks{status="pending"} 1`, `gocalc_tasks{status="done"} 0`} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("expected %q in metrics output:\n%s", line, out)
+		}
+	}
+}
+
+// histogramCount returns the number of observations of the histogram
+// name.
+func histogramCount(t *testing.T, name string) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	registry.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if count, ok := strings.CutPrefix(line, name+"_count "); ok {
+			n, err := strconv.Atoi(count)
+			if err != nil {
+				t.Fatalf("invalid count of %s: %v", name, err)
+			}
+			return n
+		}
+	}
+	t.Fatalf("no count of %s in the metrics output", name)
+	return 0
+}
+
+func TestTimeToResult(t *testing.T) {
+	resetGlobals()
+	before := histogramCount(t, "gocalc_time_to_result_seconds")
+	_, id := submit(t, `{"expression": "1+1"}`)
+	task := dispatch(t)
+	if task.Task.ID != id {
+		t.Fatalf("expected task %d to be dispatched, got %d", id, task.Task.ID)
+	}
+	report(t, ResultPayload{ID: id, Result: 2})
+	report(t, ResultPayload{ID: id, Result: 2})
+	if after := histogramCount(t, "gocalc_time_to_result_seconds"); after != before+1 {
+		t.Fatalf("expected %d observations after the result, got %d", before+1, after)
+	}
+}
+
+func TestEndToEndLatency(t *testing.T) {
+	resetGlobals()
+	before := histogramCount(t, "gocalc_end_to_end_latency_seconds")
+	// A failure and the dependent it fails are observed once each, however
+	// often the failure is reported.
+	_, failing := submit(t, `{"expression": "1/0"}`)
+	submit(t, `{"expression": "$1 * 2"}`)
+	report(t, ResultPayload{ID: failing, Error: "division by zero"})
+	report(t, ResultPayload{ID: failing, Error: "division by zero"})
+	if after := histogramCount(t, "gocalc_end_to_end_latency_seconds"); after != before+2 {
+		t.Fatalf("expected %d observations after the failure, got %d", before+2, after)
+	}
+	// A split task is observed once, not once per part.
+	_, id := submit(t, `{"expression": "sum(k/3, k, 1, 40000)", "mode": "rational"}`)
+	for _, part := range tasks[id].Parts {
+		report(t, ResultPayload{ID: part, Result: 1, Value: &calc.Value{Type: calc.ModeRational, Numerator: "1", Denominator: "1"}})
+	}
+	if tasks[id].Status != "done" {
+		t.Fatalf("expected the split task to be done, got %+v", tasks[id])
+	}
+	if after := histogramCount(t, "gocalc_end_to_end_latency_seconds"); after != before+3 {
+		t.Fatalf("expected %d observations after the split task, got %d", before+3, after)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleHealthz(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, w.Result().StatusCode)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	resetGlobals()
+	w := httptest.NewRecorder()
+	handleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, w.Result().StatusCode)
+	}
+
+	mu.Lock()
+	draining = true
+	mu.Unlock()
+	w = httptest.NewRecorder()
+	handleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Result().StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected %d while draining, got %d", http.StatusServiceUnavailable, w.Result().StatusCode)
+	}
+
+	resetGlobals()
+	stateFile = "/nonexistent-dir/state.json"
+	w = httptest.NewRecorder()
+	handleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Result().StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected %d with unreachable storage, got %d", http.StatusServiceUnavailable, w.Result().StatusCode)
+	}
+	resetGlobals()
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	resetGlobals()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBufferString(`{"expression": "1+1"}`))
+	req.Header.Set("X-Request-ID", "req-123")
+	w := httptest.NewRecorder()
+	handleCalculate(w, req)
+	if got := w.Result().Header.Get("X-Request-ID"); got != "req-123" {
+		t.Fatalf("expected X-Request-ID req-123, got %q", got)
+	}
+	wTask := httptest.NewRecorder()
+	handleInternalTask(wTask, httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	var taskResp TaskResponse
+	json.NewDecoder(wTask.Result().Body).Decode(&taskResp)
+	if taskResp.Task.RequestID != "req-123" {
+		t.Fatalf("expected request id req-123 in task, got %q", taskResp.Task.RequestID)
+	}
+
+	resetGlobals()
+	w = httptest.NewRecorder()
+	handleCalculate(w, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBufferString(`{"expression": "1+1"}`)))
+	if w.Result().Header.Get("X-Request-ID") == "" {
+		t.Fatal("expected a generated request id")
+	}
+}
+
+func TestTraceparentPropagation(t *testing.T) {
+	resetGlobals()
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBufferString(`{"expression": "1+1"}`))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	handleCalculate(httptest.NewRecorder(), req)
+	w := httptest.NewRecorder()
+	handleInternalTask(w, httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	sc, err := tracing.ParseTraceparent(w.Result().Header.Get("traceparent"))
+	if err != nil {
+		t.Fatalf("expected traceparent on dispatch: %v", err)
+	}
+	if got := sc.Traceparent()[3:35]; got != traceID {
+		t.Fatalf("expected trace id %s, got %s", traceID, got)
+	}
+}
+
+func TestDecimalModeRoundTrip(t *testing.T) {
+	resetGlobals()
+	body := bytes.NewBufferString(`{"expression": "0.1+0.2", "mode": "decimal", "precision": 20, "rounding": "half_up"}`)
+	w := httptest.NewRecorder()
+	handleCalculate(w, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", body))
+	if w.Result().StatusCode != http.StatusCreated {
+		t.Fatalf("expected %d, got %d", http.StatusCreated, w.Result().StatusCode)
+	}
+	wTask := httptest.NewRecorder()
+	handleInternalTask(wTask, httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	var taskResp TaskResponse
+	json.NewDecoder(wTask.Result().Body).Decode(&taskResp)
+	if taskResp.Task.Mode != calc.ModeDecimal || taskResp.Task.Precision != 20 || taskResp.Task.Rounding != "half_up" {
+		t.Fatalf("options not dispatched: %+v", taskResp.Task)
+	}
+	data, _ := json.Marshal(ResultPayload{ID: 1, Result: 0.3, Value: &calc.Value{Type: calc.ModeDecimal, Decimal: "0.3"}})
+	handleInternalTask(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBuffer(data)))
+
+	wGet := httptest.NewRecorder()
+	handleGetExpression(wGet, httptest.NewRequest(http.MethodGet, "/api/v1/expressions/1", nil))
+	var out map[string]*Calculation
+	json.NewDecoder(wGet.Result().Body).Decode(&out)
+	if out["expression"].Value == nil || out["expression"].Value.Decimal != "0.3" {
+		t.Fatalf("expected exact decimal value, got %+v", out["expression"])
+	}
+}
+
+func TestDecimalBeyondFloat64(t *testing.T) {
+	resetGlobals()
+	_, id := submit(t, `{"expression": "10^400", "mode": "decimal"}`)
+	dispatch(t)
+	large := "1" + strings.Repeat("0", 400)
+	report(t, ResultPayload{ID: id, Value: &calc.Value{Type: calc.ModeDecimal, Decimal: large}})
+	if task := tasks[id]; task.Status != "done" || task.Result != nil || task.Value.Decimal != large {
+		t.Fatalf("expected the exact value without a float64 result, got %+v", task)
+	}
+}
+
+func TestHandleCalculateInvalidOptions(t *testing.T) {
+	resetGlobals()
+	for _, body := range []string{
+		`{"expression": "1+1", "mode": "hex"}`,
+		`{"expression": "1+1", "mode": "decimal", "rounding": "sideways"}`,
+	} {
+		w := httptest.NewRecorder()
+		handleCalculate(w, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBufferString(body)))
+		if w.Result().StatusCode != http.StatusUnprocessableEntity {
+			t.Fatalf("expected %d for %s, got %d", http.StatusUnprocessableEntity, body, w.Result().StatusCode)
+		}
+	}
+}
+
+func TestComplexResultOmitsFloat(t *testing.T) {
+	resetGlobals()
+	body := bytes.NewBufferString(`{"expression": "3+4i", "mode": "complex"}`)
+	handleCalculate(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/calculate", body))
+	handleInternalTask(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	re, im := 3.0, 4.0
+	data, _ := json.Marshal(ResultPayload{ID: 1, Result: re, Value: &calc.Value{Type: calc.ModeComplex, Re: &re, Im: &im}})
+	handleInternalTask(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBuffer(data)))
+	mu.Lock()
+	task := tasks[1]
+	mu.Unlock()
+	if task.Result != nil {
+		t.Fatalf("expected no float result for a complex value, got %v", *task.Result)
+	}
+	if task.Value == nil || *task.Value.Im != 4 {
+		t.Fatalf("expected complex value, got %+v", task.Value)
+	}
+}
+
+func TestDeriveOperation(t *testing.T) {
+	resetGlobals()
+	body := bytes.NewBufferString(`{"expression": "x^2*sin(x)", "operation": "derive", "variable": "x"}`)
+	w := httptest.NewRecorder()
+	handleCalculate(w, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", body))
+	if w.Result().StatusCode != http.StatusCreated {
+		t.Fatalf("expected %d, got %d", http.StatusCreated, w.Result().StatusCode)
+	}
+	wTask := httptest.NewRecorder()
+	handleInternalTask(wTask, httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	var taskResp TaskResponse
+	json.NewDecoder(wTask.Result().Body).Decode(&taskResp)
+	if taskResp.Task.Operation != calc.OperationDerive || taskResp.Task.Variable != "x" {
+		t.Fatalf("operation not dispatched: %+v", taskResp.Task)
+	}
+	data, _ := json.Marshal(ResultPayload{ID: 1, Value: &calc.Value{Type: calc.TypeExpression, Expression: "2*x*sin(x) + x^2*cos(x)"}})
+	handleInternalTask(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBuffer(data)))
+	mu.Lock()
+	task := tasks[1]
+	mu.Unlock()
+	if task.Result != nil || task.Value == nil || task.Value.Expression != "2*x*sin(x) + x^2*cos(x)" {
+		t.Fatalf("expected symbolic result only, got %+v", task)
+	}
+
+	w = httptest.NewRecorder()
+	handleCalculate(w, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBufferString(`{"expression": "x^2", "operation": "derive"}`)))
+	if w.Result().StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected %d without variable, got %d", http.StatusUnprocessableEntity, w.Result().StatusCode)
+	}
+}
+
+func TestSolveOperation(t *testing.T) {
+	resetGlobals()
+	body := bytes.NewBufferString(`{"expression": "x^3 - 2*x = 5", "operation": "solve", "variable": "x", "lower": 0, "upper": 10, "tolerance": 1e-12}`)
+	w := httptest.NewRecorder()
+	handleCalculate(w, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", body))
+	if w.Result().StatusCode != http.StatusCreated {
+		t.Fatalf("expected %d, got %d", http.StatusCreated, w.Result().StatusCode)
+	}
+	wTask := httptest.NewRecorder()
+	handleInternalTask(wTask, httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	var taskResp TaskResponse
+	json.NewDecoder(wTask.Result().Body).Decode(&taskResp)
+	opts := taskResp.Task.Options
+	if opts.Operation != calc.OperationSolve || opts.Upper != 10 || opts.Tolerance != 1e-12 {
+		t.Fatalf("solve options not dispatched: %+v", opts)
+	}
+	_, value, err := calc.Evaluate(taskResp.Task.Expression, opts)
+	if err != nil {
+		t.Fatalf("agent-side solve failed: %v", err)
+	}
+	data, _ := json.Marshal(ResultPayload{ID: 1, Value: value})
+	handleInternalTask(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/internal/task", bytes.NewBuffer(data)))
+	mu.Lock()
+	task := tasks[1]
+	mu.Unlock()
+	if task.Result != nil || task.Value == nil || task.Value.Type != calc.TypeRoots || len(task.Value.Roots) != 1 {
+		t.Fatalf("expected roots only, got %+v", task)
+	}
+
+	w = httptest.NewRecorder()
+	handleCalculate(w, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", bytes.NewBufferString(`{"expression": "x = 1", "operation": "solve", "variable": "x", "lower": 5, "upper": 1}`)))
+	if w.Result().StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("expected %d for an empty range, got %d", http.StatusUnprocessableEntity, w.Result().StatusCode)
+	}
+}
+
+func TestUnitsResult(t *testing.T) {
+	resetGlobals()
+	submit(t, `{"expression": "5 km / 2 h", "mode": "units", "label": "speed"}`)
+	submit(t, `{"expression": "@speed to m/s", "mode": "units"}`)
+	for range 2 {
+		resp := dispatch(t)
+		result, value, err := calc.Evaluate(resp.Task.Expression, resp.Task.Options)
+		if err != nil {
+			t.Fatalf("evaluating %q: %v", resp.Task.Expression, err)
+		}
+		report(t, ResultPayload{ID: resp.Task.ID, Result: result, Value: value})
+	}
+	if task := tasks[1]; task.Result == nil || *task.Result != 2.5 || task.Unit != "km/h" {
+		t.Fatalf("expected 2.5 km/h, got %+v", task)
+	}
+	if task := tasks[2]; task.Result == nil || math.Abs(*task.Result-2.5/3.6) > 1e-12 || task.Unit != "m/s" {
+		t.Fatalf("expected the speed in m/s, got %+v", task)
+	}
+
+	if status, _ := submit(t, `{"expression": "3 m + 2 s", "mode": "units"}`); status != http.StatusCreated {
+		t.Fatalf("expected dimension errors to be reported by the agent, got %d", status)
+	}
+}
+
+func TestTimeResult(t *testing.T) {
+	resetGlobals()
+	submit(t, `{"expression": "2026-10-18T17:00 + 90d", "mode": "time", "timezone": "Europe/Berlin", "label": "deadline"}`)
+	submit(t, `{"expression": "(@deadline - 2026-10-18) in days", "mode": "time"}`)
+	for range 2 {
+		resp := dispatch(t)
+		result, value, err := calc.Evaluate(resp.Task.Expression, resp.Task.Options)
+		if err != nil {
+			t.Fatalf("evaluating %q: %v", resp.Task.Expression, err)
+		}
+		report(t, ResultPayload{ID: resp.Task.ID, Result: result, Value: value})
+	}
+	if task := tasks[1]; task.Result != nil || task.Value == nil || task.Value.Type != calc.TypeInstant || task.Value.Time != "2027-01-16T16:00:00+01:00" {
+		t.Fatalf("expected an instant without a float result, got %+v", task)
+	}
+	if task := tasks[2]; task.Result == nil || *task.Result != 90.625 {
+		t.Fatalf("expected the days until the deadline, got %+v", task)
+	}
+
+	if status, _ := submit(t, `{"expression": "1", "mode": "time", "timezone": "Nowhere/Special"}`); status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected %d for an unknown time zone, got %d", http.StatusUnprocessableEntity, status)
+	}
+}
+
+func TestDeskDialect(t *testing.T) {
+	resetGlobals()
+	submit(t, `{"expression": "200 + 10%", "dialect": "desk", "label": "total"}`)
+	submit(t, `{"expression": "@total % 7", "mode": "integer"}`)
+	for range 2 {
+		resp := dispatch(t)
+		result, value, err := calc.Evaluate(resp.Task.Expression, resp.Task.Options)
+		if err != nil {
+			t.Fatalf("evaluating %q: %v", resp.Task.Expression, err)
+		}
+		report(t, ResultPayload{ID: resp.Task.ID, Result: result, Value: value})
+	}
+	if task := tasks[1]; task.Result == nil || *task.Result != 220 {
+		t.Fatalf("expected 10%% to be a percentage in the desk dialect, got %+v", task)
+	}
+	if task := tasks[2]; task.Result == nil || *task.Result != 3 {
+		t.Fatalf("expected %% to stay the remainder in the programming dialect, got %+v", task)
+	}
+
+	if status, _ := submit(t, `{"expression": "1", "dialect": "pocket"}`); status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected %d for an unknown dialect, got %d", http.StatusUnprocessableEntity, status)
+	}
+}
+
+func TestLenientParsing(t *testing.T) {
+	resetGlobals()
+	submit(t, `{"expression": "2π", "lenient": true, "label": "tau"}`)
+	if status, _ := submit(t, `{"expression": "@tau × $1", "lenient": true}`); status != http.StatusCreated {
+		t.Fatalf("expected a lenient expression with references to be accepted, got %d", status)
+	}
+	resp := dispatch(t)
+	result, value, err := calc.Evaluate(resp.Task.Expression, resp.Task.Options)
+	if err != nil {
+		t.Fatalf("evaluating %q: %v", resp.Task.Expression, err)
+	}
+	report(t, ResultPayload{ID: resp.Task.ID, Result: result, Value: value})
+	if task := tasks[1]; task.Result == nil || *task.Result != 2*math.Pi {
+		t.Fatalf("expected 2π, got %+v", task)
+	}
+	if status, _ := submit(t, `{"expression": "x^3 - 2x = 5", "lenient": true, "operation": "solve", "variable": "x"}`); status != http.StatusCreated {
+		t.Fatalf("expected a lenient equation to be accepted, got %d", status)
+	}
+}
+
+func TestLocale(t *testing.T) {
+	resetGlobals()
+	submit(t, `{"expression": "1.234,5 * 2", "mode": "decimal", "locale": "de", "label": "total"}`)
+	submit(t, `{"expression": "@total + 0,5", "mode": "decimal", "locale": "de"}`)
+	for range 2 {
+		resp := dispatch(t)
+		result, value, err := calc.Evaluate(resp.Task.Expression, resp.Task.Options)
+		if err != nil {
+			t.Fatalf("evaluating %q: %v", resp.Task.Expression, err)
+		}
+		report(t, ResultPayload{ID: resp.Task.ID, Result: result, Value: value})
+	}
+	if task := tasks[1]; task.Value == nil || task.Value.Decimal != "2469" || task.Formatted != "2.469" {
+		t.Fatalf("expected 2469 formatted as 2.469, got %+v", task)
+	}
+	if task := tasks[2]; task.Formatted != "2.469,5" {
+		t.Fatalf("expected the result to be read as an input in the locale, got %+v", task)
+	}
+
+	submit(t, `{"expression": "sum(k/3; k; 1; 40000)", "mode": "rational", "locale": "de"}`)
+	parent := tasks[3]
+	if len(parent.Parts) != partitions {
+		t.Fatalf("expected the task to be split into %d parts, got %+v", partitions, parent)
+	}
+	for range parent.Parts {
+		resp := dispatch(t)
+		_, value, err := calc.Evaluate(resp.Task.Expression, resp.Task.Options)
+		if err != nil {
+			t.Fatalf("evaluating part %q: %v", resp.Task.Expression, err)
+		}
+		report(t, ResultPayload{ID: resp.Task.ID, Result: value.Float64(), Value: value})
+	}
+	if parent.Status != "done" || parent.Formatted != "800.020.000/3" {
+		t.Fatalf("expected the parts to be combined and formatted, got %+v", parent)
+	}
+
+	// Parts keep the locale, so functions written in it still parse.
+	values := strings.TrimSuffix(strings.Repeat("0.5,", 40000), ",")
+	upload(t, `{"name": "samples", "values": [`+values+`]}`)
+	functions := `"functions": ["f(x; y) = x * 1,5 + y"], "mode": "rational", "locale": "de"`
+	_, sum := submit(t, `{"expression": "sum(f(k; 1); k; 1; 40000)", `+functions+`}`)
+	_, mean := submit(t, `{"expression": "mean(#samples)", `+functions+`}`)
+	for _, id := range []int{sum, mean} {
+		if len(tasks[id].Parts) != partitions {
+			t.Fatalf("expected task %d to be split, got %+v", id, tasks[id])
+		}
+		for range tasks[id].Parts {
+			resp := dispatch(t)
+			_, value, err := calc.Evaluate(resp.Task.Expression, resp.Task.Options)
+			if err != nil {
+				t.Fatalf("evaluating part %q: %v", resp.Task.Expression, err)
+			}
+			report(t, ResultPayload{ID: resp.Task.ID, Result: value.Float64(), Value: value})
+		}
+	}
+	if task := tasks[sum]; task.Status != "done" || task.Formatted != "1.200.070.000" {
+		t.Fatalf("expected the parts calling f to be combined, got %+v", task)
+	}
+	if task := tasks[mean]; task.Status != "done" || task.Formatted != "1/2" {
+		t.Fatalf("expected the parts of the mean to be combined, got %+v", task)
+	}
+
+	if status, _ := submit(t, `{"expression": "1", "locale": "xx"}`); status != http.StatusUnprocessableEntity {
+		t.Fatalf("expected %d for an unknown locale, got %d", http.StatusUnprocessableEntity, status)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"time"
+
+	"github.com/m4tveevm/GoCalc/metrics"
+)
+
+var (
+	registry = metrics.NewRegistry()
+
+	queueDepth = registry.NewGauge("gocalc_queue_depth",
+		"Number of tasks waiting to be dispatched to an agent.")
+	tasksByStatus = registry.NewGauge("gocalc_tasks",
+		"Number of known tasks by status.", "status")
+	submissionsTotal = registry.NewCounter("gocalc_submissions_total",
+		"Expressions accepted through /api/v1/calculate.")
+	dispatchWait = registry.NewHistogram("gocalc_time_to_dispatch_seconds",
+		"Time a task waits in the queue before an agent picks it up.", nil)
+	resultWait = registry.NewHistogram("gocalc_time_to_result_seconds",
+		"Time from dispatch until the agent reports the result.", nil)
+	endToEndLatency = registry.NewHistogram("gocalc_end_to_end_latency_seconds",
+		"Time from submission until the result is reported.", nil)
+)
+
+var taskStatuses = []string{"waiting", "pending", "in_progress", "done", "error"}
+
+func init() {
+	registry.OnScrape(func() {
+		counts := make(map[string]int)
+		mu.Lock()
+		queueDepth.Set(float64(len(queue)))
+		for _, task := range tasks {
+			counts[task.Status]++
+		}
+		mu.Unlock()
+		for _, status := range taskStatuses {
+			tasksByStatus.Set(float64(counts[status]), status)
+		}
+	})
+}
+
+// observeFinished records the end-to-end latency of task, which has just
+// become done or failed, unless it is a part of another task or a chunk
+// of a sweep, so every submitted expression is counted once.
+func observeFinished(task *Calculation) {
+	if task.Parent == 0 && task.Sweep == 0 {
+		observeSince(endToEndLatency, task.submittedAt)
+	}
+}
+
+// observeSince records the time elapsed since start, skipping tasks
+// restored from a snapshot that have no timestamps.
+func observeSince(h *metrics.Histogram, start time.Time) {
+	if start.IsZero() {
+		return
+	}
+	h.Observe(time.Since(start).Seconds())
+}
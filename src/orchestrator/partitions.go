@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/m4tveevm/GoCalc/calc"
+)
+
+// partitions is the number of tasks the range of a large integrate, sum
+// or prod, the rows of a large matrix product, or a large dataset
+// aggregated by sum, count or mean, are split into, so that agents
+// compute its parts in parallel.
+var partitions = 4
+
+// split queues a task for every part of task's range and makes task wait
+// for them. The caller must hold mu.
+func split(task *Calculation, parts []string, op string) {
+	task.Status = "waiting"
+	task.Combine = op
+	for _, expression := range parts {
+		addPart(task, expression)
+	}
+	track(task)
+}
+
+// splitDataset queues a task for every part of an aggregate over the
+// dataset name, each covering a slice of it, and makes task wait for
+// them. The caller must hold mu.
+func splitDataset(task *Calculation, name string, parts []calc.DatasetPart, op string) {
+	task.Status = "waiting"
+	task.Combine = op
+	for _, p := range parts {
+		part := addPart(task, p.Expression)
+		part.Dataset = name
+		part.Offset = p.Offset
+		part.Count = p.Count
+	}
+	track(task)
+}
+
+// addPart queues a task evaluating expression as a part of task. The
+// caller must hold mu.
+func addPart(task *Calculation, expression string) *Calculation {
+	part := &Calculation{
+		ID:         nextID,
+		Expression: expression,
+		Status:     "pending",
+		RequestID:  task.RequestID,
+		Parent:     task.ID,
+		Options:    task.Options,
+
+		submittedAt: task.submittedAt,
+		spanContext: task.spanContext,
+	}
+	nextID++
+	tasks[part.ID] = part
+	task.Parts = append(task.Parts, part.ID)
+	queue = append(queue, part.ID)
+	return part
+}
+
+// combine finishes a task that was split once all its parts are done,
+// combining their exact results in the task's mode. It fails the task as
+// soon as one of them has failed, and reports whether the task's status
+// changed. The caller must hold mu.
+func combine(task *Calculation) bool {
+	results := make([]string, 0, len(task.Parts))
+	ready := true
+	for _, id := range task.Parts {
+		part := tasks[id]
+		switch {
+		case part == nil:
+			ready = false
+		case part.Status == "error":
+			task.Status = "error"
+			task.Error = fmt.Sprintf("part $%d failed: %s", id, part.Error)
+			observeFinished(task)
+			return true
+		case part.Status == "done":
+			results = append(results, "("+resultExpression(part)+")")
+		default:
+			ready = false
+		}
+	}
+	if !ready {
+		return false
+	}
+	expression := strings.Join(results, " "+task.Combine+" ")
+	if calc.IsLabel(task.Combine) {
+		expression = task.Combine + "(" + strings.Join(results, ", ") + ")"
+	}
+	opts := task.Options
+	opts.Functions = nil
+	opts.Definitions = nil
+	opts.Datasets = nil
+	// The results of the parts are canonical literals in every locale.
+	opts.Locale = ""
+	result, value, err := calc.Evaluate(expression, opts)
+	if err != nil {
+		task.Status = "error"
+		task.Error = err.Error()
+		observeFinished(task)
+		return true
+	}
+	complete(task, result, value)
+	observeFinished(task)
+	return true
+}
+
+// complete records the result of task. Only real results within the range
+// of float64 are reported as a float64. Tasks with a locale also get the
+// result formatted in it. The caller must hold mu.
+func complete(task *Calculation, result float64, value *calc.Value) {
+	if value == nil || value.HasFloat64() {
+		task.Result = &result
+	}
+	task.Value = value
+	if value != nil {
+		task.Unit = value.Unit
+	}
+	if task.Locale != "" {
+		task.Formatted = task.Locale.Format(result, value)
+	}
+	task.Status = "done"
+}
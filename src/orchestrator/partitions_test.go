@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/m4tveevm/GoCalc/calc"
+)
+
+func TestRangeIsSplitAcrossAgents(t *testing.T) {
+	resetGlobals()
+	if status, id := submit(t, `{"expression": "sum(k/3, k, 1, 40000)", "mode": "rational", "label": "h"}`); status != http.StatusCreated || id != 1 {
+		t.Fatalf("expected task 1 to be created, got %d, %d", status, id)
+	}
+	submit(t, `{"expression": "@h * 2", "mode": "rational"}`)
+	parent := tasks[1]
+	if parent.Status != "waiting" || len(parent.Parts) != partitions || parent.Combine != "+" {
+		t.Fatalf("expected task to be split into %d parts, got %+v", partitions, parent)
+	}
+
+	for range parent.Parts {
+		resp := dispatch(t)
+		if resp.Task.ID == 1 || tasks[resp.Task.ID].Parent != 1 || resp.Task.Mode != calc.ModeRational {
+			t.Fatalf("expected a part of task 1 to be dispatched, got %+v", resp.Task)
+		}
+		_, value, err := calc.Evaluate(resp.Task.Expression, resp.Task.Options)
+		if err != nil {
+			t.Fatalf("evaluating part %q: %v", resp.Task.Expression, err)
+		}
+		if parent.Status != "waiting" {
+			t.Fatalf("expected task to wait for all parts, got %s", parent.Status)
+		}
+		report(t, ResultPayload{ID: resp.Task.ID, Result: value.Float64(), Value: value})
+	}
+
+	_, whole, err := calc.Evaluate("sum(k/3, k, 1, 40000)", calc.Options{Mode: calc.ModeRational})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent.Status != "done" || parent.Value == nil || parent.Value.Numerator != whole.Numerator || parent.Value.Denominator != whole.Denominator {
+		t.Fatalf("expected the exact sum, got %+v", parent)
+	}
+	if resp := dispatch(t); resp.Task.ID != 2+partitions || resp.Task.Inputs["@h"] != whole.Literal() {
+		t.Fatalf("expected the dependent to get the combined result, got %+v", resp.Task)
+	}
+}
+
+func TestSmallIntegralIsNotSplit(t *testing.T) {
+	resetGlobals()
+	submit(t, `{"expression": "integrate(x, x, 0, 1)"}`)
+	if task := tasks[1]; task.Status != "pending" || len(task.Parts) != 0 || len(queue) != 1 {
+		t.Fatalf("expected a small integral to be queued whole, got %+v and queue %v", task, queue)
+	}
+}
+
+func TestFailedPartFailsTask(t *testing.T) {
+	resetGlobals()
+	submit(t, `{"expression": "integrate(1/(x - 3), x, 0, 400)"}`)
+	parts := tasks[1].Parts
+	report(t, ResultPayload{ID: parts[0], Result: 1})
+	report(t, ResultPayload{ID: parts[3], Error: "division by zero"})
+	if tasks[1].Status != "error" || tasks[1].Error != "part $5 failed: division by zero" {
+		t.Fatalf("expected the task to fail with its part, got %+v", tasks[1])
+	}
+}
+
+func TestSmallRangeIsNotSplit(t *testing.T) {
+	resetGlobals()
+	submit(t, `{"expression": "sum(k, k, 1, 100)"}`)
+	if resp := dispatch(t); resp.Task.ID != 1 || len(tasks) != 1 {
+		t.Fatalf("expected the task to be dispatched whole, got %+v", resp.Task)
+	}
+}
+
+func TestMatrixProductIsSplitAcrossAgents(t *testing.T) {
+	resetGlobals()
+	matrix := func(rows, cols int) string {
+		literals := make([]string, rows)
+		for i := range literals {
+			elements := make([]string, cols)
+			for j := range elements {
+				elements[j] = strconv.Itoa((i + 2*j) % 7)
+			}
+			literals[i] = "[" + strings.Join(elements, ", ") + "]"
+		}
+		return "[" + strings.Join(literals, ", ") + "]"
+	}
+	expression := matrix(200, 100) + " * " + matrix(100, 100)
+	submit(t, `{"expression": "`+expression+`", "mode": "matrix"}`)
+	parent := tasks[1]
+	if parent.Status != "waiting" || len(parent.Parts) != 2 || parent.Combine != "vstack" {
+		t.Fatalf("expected the product to be split into 2 blocks of rows, got %s, %d parts, %q", parent.Status, len(parent.Parts), parent.Combine)
+	}
+	for range parent.Parts {
+		resp := dispatch(t)
+		_, value, err := calc.Evaluate(resp.Task.Expression, resp.Task.Options)
+		if err != nil {
+			t.Fatalf("evaluating part $%d: %v", resp.Task.ID, err)
+		}
+		if len(value.Matrix) != 100 {
+			t.Fatalf("expected a block of 100 rows, got %d", len(value.Matrix))
+		}
+		report(t, ResultPayload{ID: resp.Task.ID, Value: value})
+	}
+
+	_, whole, err := calc.Evaluate(expression, calc.Options{Mode: calc.ModeMatrix})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent.Status != "done" || parent.Result != nil || !reflect.DeepEqual(parent.Value, whole) {
+		t.Fatalf("expected the whole product, got %s: %v", parent.Status, parent.Error)
+	}
+}
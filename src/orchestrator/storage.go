@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/m4tveevm/GoCalc/calc"
+)
+
+var (
+	// snapshotInterval is how often the state is written while running,
+	// which bounds what a crash loses.
+	snapshotInterval = 30 * time.Second
+	// saving serializes writing snapshots, so an older one never replaces
+	// a newer one.
+	saving sync.Mutex
+)
+
+type snapshot struct {
+	NextID int            `json:"next_id"`
+	Tasks  []*Calculation `json:"tasks"`
+	// SavedFunctions are the saved functions by user and name.
+	SavedFunctions map[string]map[string]calc.Definition `json:"saved_functions,omitempty"`
+	// Functions are saved functions of snapshots taken before they kept
+	// their syntax, which was that of float mode for all of them.
+	Functions map[string]map[string]string `json:"functions,omitempty"`
+	// Datasets are the uploaded datasets by name.
+	Datasets map[string][]json.Number `json:"datasets,omitempty"`
+	// Sweeps are the sweeps, whose chunks are among Tasks.
+	Sweeps      []*Sweep `json:"sweeps,omitempty"`
+	NextSweepID int      `json:"next_sweep_id,omitempty"`
+}
+
+// loadState restores tasks, sweeps, saved functions and datasets from the
+// snapshot at path. Tasks that were in progress when the snapshot was
+// taken are put back into the queue, since the agent that held them is
+// not going to report back.
+func loadState(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return err
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	tasks = make(map[int]*Calculation)
+	labels = make(map[string]int)
+	dependents = make(map[int][]int)
+	unresolved = make(map[string][]int)
+	queue = nil
+	nextID = snap.NextID
+	userFunctions = snap.SavedFunctions
+	if userFunctions == nil {
+		userFunctions = make(map[string]map[string]calc.Definition)
+	}
+	for user, functions := range snap.Functions {
+		if userFunctions[user] == nil {
+			userFunctions[user] = make(map[string]calc.Definition)
+		}
+		for name, definition := range functions {
+			if _, ok := userFunctions[user][name]; !ok {
+				userFunctions[user][name] = calc.Definition{Definition: definition}
+			}
+		}
+	}
+	datasets = snap.Datasets
+	if datasets == nil {
+		datasets = make(map[string][]json.Number)
+	}
+	sweeps = make(map[int]*Sweep)
+	nextSweepID = max(snap.NextSweepID, 1)
+	for _, sweep := range snap.Sweeps {
+		sweeps[sweep.ID] = sweep
+		if sweep.ID >= nextSweepID {
+			nextSweepID = sweep.ID + 1
+		}
+	}
+	for _, task := range snap.Tasks {
+		tasks[task.ID] = task
+		if task.Label != "" {
+			labels[task.Label] = task.ID
+		}
+		if task.ID >= nextID {
+			nextID = task.ID + 1
+		}
+		if task.Status == "pending" || task.Status == "in_progress" {
+			task.Status = "pending"
+			queue = append(queue, task.ID)
+		}
+	}
+	sort.Ints(queue)
+	for i := 1; i < nextID; i++ {
+		if task, ok := tasks[i]; ok && task.Status == "waiting" {
+			track(task)
+		}
+	}
+	for i := 1; i < nextID; i++ {
+		if task, ok := tasks[i]; ok {
+			resume(task)
+		}
+	}
+	return nil
+}
+
+// saveState writes all tasks, sweeps, saved functions and datasets to
+// path. The snapshot is written to a temporary file first and renamed, so
+// a crash never leaves a torn file.
+func saveState(path string) error {
+	if path == "" {
+		return nil
+	}
+	saving.Lock()
+	defer saving.Unlock()
+	mu.Lock()
+	snap := snapshot{NextID: nextID, SavedFunctions: userFunctions, Datasets: datasets, NextSweepID: nextSweepID}
+	for i := 1; i < nextID; i++ {
+		if task, ok := tasks[i]; ok {
+			snap.Tasks = append(snap.Tasks, task)
+		}
+	}
+	for i := 1; i < nextSweepID; i++ {
+		if sweep, ok := sweeps[i]; ok {
+			snap.Sweeps = append(snap.Sweeps, sweep)
+		}
+	}
+	data, err := json.Marshal(snap)
+	mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".state-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// snapshotState writes the state to stateFile every interval until ctx is
+// done.
+func snapshotState(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := saveState(stateFile); err != nil {
+				slog.Error("error saving state", "path", stateFile, "error", err)
+			}
+		}
+	}
+}
+
+// checkStorage verifies that a snapshot could be written next to path.
+func checkStorage(path string) error {
+	if path == "" {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".probe-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
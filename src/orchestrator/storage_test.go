@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveAndLoadState(t *testing.T) {
+	resetGlobals()
+	for _, expr := range []string{"1+1", "2+2"} {
+		body := bytes.NewBufferString(`{"expression": "` + expr + `"}`)
+		handleCalculate(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/v1/calculate", body))
+	}
+	handleInternalTask(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := saveState(path); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+	resetGlobals()
+	if err := loadState(path); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(tasks) != 2 || nextID != 3 {
+		t.Fatalf("expected 2 tasks and next id 3, got %d tasks and next id %d", len(tasks), nextID)
+	}
+	if len(queue) != 2 || queue[0] != 1 {
+		t.Fatalf("expected in-progress task to be requeued, got queue %v", queue)
+	}
+	if tasks[1].Status != "pending" {
+		t.Fatalf("expected requeued task to be pending, got %s", tasks[1].Status)
+	}
+}
+
+func TestLoadStateMissingFile(t *testing.T) {
+	resetGlobals()
+	if err := loadState(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Fatalf("expected no error for missing file, got %v", err)
+	}
+}
+
+func TestSnapshotState(t *testing.T) {
+	resetGlobals()
+	stateFile = filepath.Join(t.TempDir(), "state.json")
+	submit(t, `{"expression": "1+1"}`)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	snapshotState(ctx, 10*time.Millisecond)
+
+	path := stateFile
+	resetGlobals()
+	if err := loadState(path); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(tasks) != 1 || tasks[1].Expression != "1+1" {
+		t.Fatalf("expected the submitted task in the snapshot, got %v", tasks)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/m4tveevm/GoCalc/calc"
+)
+
+// Sweep evaluates an expression at every point of a grid of values of its
+// variables. The points are split into chunks of sweepChunkSize, each
+// evaluated by an agent as a task of its own.
+type Sweep struct {
+	ID         int       `json:"id"`
+	Expression string    `json:"expression"`
+	Variables  calc.Grid `json:"variables"`
+	Status     string    `json:"status"`
+	Points     int       `json:"points"`
+	// Evaluated counts the points of the chunks that have finished.
+	Evaluated int    `json:"evaluated"`
+	RequestID string `json:"request_id,omitempty"`
+	// Chunks are the IDs of the tasks evaluating the points, in order.
+	Chunks []int `json:"chunks"`
+	calc.Options
+}
+
+type SweepRequest struct {
+	Expression string    `json:"expression"`
+	Variables  calc.Grid `json:"variables"`
+	calc.Options
+}
+
+// SweepRow is a point of a sweep with the outcome of evaluating the
+// expression there, as streamed in JSON.
+type SweepRow struct {
+	Point map[string]json.Number `json:"point"`
+	calc.Sample
+}
+
+const sweepChunkSize = 1000
+
+var (
+	sweeps      = make(map[int]*Sweep)
+	nextSweepID = 1
+	// sweepProgress is closed and replaced whenever a chunk finishes,
+	// waking the result streams waiting for it.
+	sweepProgress = make(chan struct{})
+)
+
+// handleSweeps creates a sweep and queues its chunks.
+func handleSweeps(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodPost {
+		http.Error(writer, `{"error":"Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	var req SweepRequest
+	if err := json.NewDecoder(request.Body).Decode(&req); err != nil || req.Expression == "" {
+		http.Error(writer, `{"error":"Invalid sweep"}`, http.StatusUnprocessableEntity)
+		return
+	}
+	points, err := req.Variables.Size()
+	if err != nil {
+		http.Error(writer, errorJSON(err.Error()), http.StatusUnprocessableEntity)
+		return
+	}
+	if refs, err := calc.References(req.Expression); err != nil || len(refs) > 0 {
+		http.Error(writer, `{"error":"Sweeps cannot reference other results"}`, http.StatusUnprocessableEntity)
+		return
+	}
+	mu.Lock()
+	if shuttingDown {
+		mu.Unlock()
+		writer.Header().Set("Retry-After", retryAfterSeconds)
+		http.Error(writer, `{"error":"Server is shutting down"}`, http.StatusServiceUnavailable)
+		return
+	}
+	req.Definitions = scopeDefinitions(request.Header.Get("X-User-ID"), req.Definitions)
+	err = checkDatasets(req.Expression, req.Options)
+	if err == nil {
+		_, err = calc.Compile(req.Expression, req.Options)
+	}
+	if err != nil {
+		mu.Unlock()
+		http.Error(writer, errorJSON(err.Error()), http.StatusUnprocessableEntity)
+		return
+	}
+	requestID := request.Header.Get("X-Request-ID")
+	if requestID == "" {
+		requestID = newRequestID()
+	}
+	sweep := &Sweep{
+		ID:         nextSweepID,
+		Expression: req.Expression,
+		Variables:  req.Variables,
+		Status:     "pending",
+		Points:     points,
+		RequestID:  requestID,
+		Options:    req.Options,
+	}
+	nextSweepID++
+	sweeps[sweep.ID] = sweep
+	now := time.Now()
+	for offset := 0; offset < points; offset += sweepChunkSize {
+		chunk := &Calculation{
+			ID:         nextID,
+			Expression: sweep.Expression,
+			Status:     "pending",
+			RequestID:  requestID,
+			Sweep:      sweep.ID,
+			Offset:     offset,
+			Count:      min(sweepChunkSize, points-offset),
+			Options:    sweep.Options,
+
+			submittedAt: now,
+		}
+		nextID++
+		tasks[chunk.ID] = chunk
+		queue = append(queue, chunk.ID)
+		sweep.Chunks = append(sweep.Chunks, chunk.ID)
+	}
+	mu.Unlock()
+	slog.Info("sweep accepted", "sweep_id", sweep.ID, "request_id", requestID, "points", points, "chunks", len(sweep.Chunks))
+
+	writer.Header().Set("X-Request-ID", requestID)
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusCreated)
+	json.NewEncoder(writer).Encode(map[string]int{"id": sweep.ID})
+}
+
+// handleSweep returns a sweep (GET /api/v1/sweeps/{id}) or streams its
+// results (GET /api/v1/sweeps/{id}/results).
+func handleSweep(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		http.Error(writer, `{"error":"Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.TrimPrefix(request.URL.Path, "/api/v1/sweeps/")
+	path, results := strings.CutSuffix(path, "/results")
+	id, err := strconv.Atoi(path)
+	if err != nil {
+		http.Error(writer, `{"error":"Invalid ID"}`, http.StatusBadRequest)
+		return
+	}
+	mu.Lock()
+	sweep, exists := sweeps[id]
+	var data []byte
+	if exists && !results {
+		data, _ = json.Marshal(map[string]*Sweep{"sweep": sweep})
+	}
+	mu.Unlock()
+	if !exists {
+		http.Error(writer, `{"error":"Not found"}`, http.StatusNotFound)
+		return
+	}
+	if !results {
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write(append(data, '\n'))
+		return
+	}
+	streamSweep(writer, request, sweep)
+}
+
+// streamSweep writes the results of sweep in the order of its points as
+// the chunks finish, as CSV with ?format=csv and as a JSON array
+// otherwise.
+func streamSweep(writer http.ResponseWriter, request *http.Request, sweep *Sweep) {
+	format := request.URL.Query().Get("format")
+	var out sweepWriter
+	switch format {
+	case "", "json":
+		writer.Header().Set("Content-Type", "application/json")
+		out = &jsonSweepWriter{writer: writer}
+	case "csv":
+		writer.Header().Set("Content-Type", "text/csv")
+		out = &csvSweepWriter{writer: csv.NewWriter(writer)}
+	default:
+		http.Error(writer, `{"error":"Unknown format"}`, http.StatusBadRequest)
+		return
+	}
+	// A large sweep streams for longer than the server's write timeout.
+	controller := http.NewResponseController(writer)
+	controller.SetWriteDeadline(time.Time{})
+	names := sweep.Variables.Names()
+	if err := out.header(names); err != nil {
+		return
+	}
+	for _, id := range sweep.Chunks {
+		chunk, err := waitForChunk(request, id)
+		if err != nil {
+			return
+		}
+		points, err := sweep.Variables.Points(chunk.Offset, chunk.Count)
+		if err != nil {
+			return
+		}
+		for i, point := range points {
+			var sample calc.Sample
+			switch {
+			case chunk.Status == "error":
+				sample.Error = chunk.Error
+			case i < len(chunk.Samples):
+				sample = chunk.Samples[i]
+			default:
+				sample.Error = "missing result"
+			}
+			if err := out.row(names, point, sample); err != nil {
+				return
+			}
+		}
+		if err := out.flush(); err != nil {
+			return
+		}
+		controller.Flush()
+	}
+	out.close()
+}
+
+// waitForChunk returns a copy of the task with id once it is done or has
+// failed.
+func waitForChunk(request *http.Request, id int) (*Calculation, error) {
+	for {
+		mu.Lock()
+		task, ok := tasks[id]
+		var chunk Calculation
+		if ok {
+			chunk = *task
+		}
+		progress := sweepProgress
+		mu.Unlock()
+		if !ok {
+			return nil, errors.New("chunk not found")
+		}
+		if chunk.Status == "done" || chunk.Status == "error" {
+			return &chunk, nil
+		}
+		select {
+		case <-progress:
+		case <-request.Context().Done():
+			return nil, request.Context().Err()
+		}
+	}
+}
+
+// recordChunk counts a finished chunk towards its sweep and wakes the
+// result streams. The caller must hold mu.
+func recordChunk(chunk *Calculation) {
+	sweep, ok := sweeps[chunk.Sweep]
+	if !ok {
+		return
+	}
+	sweep.Evaluated += chunk.Count
+	sweep.Status = "in_progress"
+	if sweep.Evaluated >= sweep.Points {
+		sweep.Status = "done"
+	}
+	close(sweepProgress)
+	sweepProgress = make(chan struct{})
+}
+
+type sweepWriter interface {
+	header(names []string) error
+	row(names, point []string, sample calc.Sample) error
+	flush() error
+	close() error
+}
+
+type csvSweepWriter struct {
+	writer *csv.Writer
+}
+
+func (w *csvSweepWriter) header(names []string) error {
+	return w.writer.Write(append(append([]string{}, names...), "result", "error"))
+}
+
+func (w *csvSweepWriter) row(names, point []string, sample calc.Sample) error {
+	result := ""
+	switch {
+	case sample.Value != nil:
+		result = sample.Value.Literal()
+	case sample.Result != nil:
+		result = calc.FormatFloat(*sample.Result)
+	}
+	return w.writer.Write(append(append([]string{}, point...), result, sample.Error))
+}
+
+func (w *csvSweepWriter) flush() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+func (w *csvSweepWriter) close() error {
+	return w.flush()
+}
+
+type jsonSweepWriter struct {
+	writer http.ResponseWriter
+	rows   int
+}
+
+func (w *jsonSweepWriter) header([]string) error {
+	_, err := w.writer.Write([]byte("["))
+	return err
+}
+
+func (w *jsonSweepWriter) row(names, point []string, sample calc.Sample) error {
+	row := SweepRow{Point: make(map[string]json.Number, len(names)), Sample: sample}
+	for i, name := range names {
+		row.Point[name] = json.Number(point[i])
+	}
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	separator := ",\n"
+	if w.rows == 0 {
+		separator = "\n"
+	}
+	w.rows++
+	_, err = w.writer.Write(append([]byte(separator), data...))
+	return err
+}
+
+func (w *jsonSweepWriter) flush() error {
+	return nil
+}
+
+func (w *jsonSweepWriter) close() error {
+	_, err := w.writer.Write([]byte("\n]\n"))
+	return err
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/m4tveevm/GoCalc/calc"
+)
+
+func submitSweep(t *testing.T, body string) (int, int) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handleSweeps(w, httptest.NewRequest(http.MethodPost, "/api/v1/sweeps", bytes.NewBufferString(body)))
+	var out map[string]int
+	json.NewDecoder(w.Result().Body).Decode(&out)
+	return w.Result().StatusCode, out["id"]
+}
+
+// evaluateChunk evaluates a dispatched chunk as an agent would.
+func evaluateChunk(t *testing.T, resp TaskResponse) ResultPayload {
+	t.Helper()
+	c, err := calc.Compile(resp.Task.Expression, resp.Task.Options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples, err := c.Sweep(resp.Task.Grid, resp.Task.Offset, resp.Task.Count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ResultPayload{ID: resp.Task.ID, Samples: samples}
+}
+
+func TestSweepIsStreamedAsCSV(t *testing.T) {
+	resetGlobals()
+	status, id := submitSweep(t, `{"expression": "x * y", "mode": "decimal", "variables": [
+		{"name": "x", "from": 0, "to": 100, "step": 0.1},
+		{"name": "y", "values": [1, -2]}
+	]}`)
+	if status != http.StatusCreated || id != 1 {
+		t.Fatalf("expected sweep 1 to be created, got %d, %d", status, id)
+	}
+	sweep := sweeps[1]
+	if sweep.Points != 2002 || len(sweep.Chunks) != 3 {
+		t.Fatalf("expected 2002 points in 3 chunks, got %+v", sweep)
+	}
+
+	// The stream waits for the chunks, which finish out of order.
+	w := httptest.NewRecorder()
+	streamed := make(chan struct{})
+	go func() {
+		handleSweep(w, httptest.NewRequest(http.MethodGet, "/api/v1/sweeps/1/results?format=csv", nil))
+		close(streamed)
+	}()
+	var results []ResultPayload
+	for range sweep.Chunks {
+		results = append(results, evaluateChunk(t, dispatch(t)))
+	}
+	for i := len(results) - 1; i >= 0; i-- {
+		report(t, results[i])
+	}
+	<-streamed
+
+	if sweep.Status != "done" || sweep.Evaluated != 2002 {
+		t.Fatalf("expected sweep to be done, got %+v", sweep)
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2003 || strings.Join(rows[0], ",") != "x,y,result,error" {
+		t.Fatalf("expected header and 2002 rows, got %d rows starting with %v", len(rows), rows[0])
+	}
+	if got := strings.Join(rows[8], ","); got != "0.3,-2,-0.6," {
+		t.Fatalf("expected exact row for x = 0.3, got %s", got)
+	}
+	if got := strings.Join(rows[2002], ","); got != "100,-2,-200," {
+		t.Fatalf("expected last row for x = 100, got %s", got)
+	}
+}
+
+func TestSweepStreamsJSONWithErrors(t *testing.T) {
+	resetGlobals()
+	submitSweep(t, `{"expression": "1/x", "variables": [{"name": "x", "from": -1, "to": 1, "step": 1}]}`)
+	report(t, evaluateChunk(t, dispatch(t)))
+
+	w := httptest.NewRecorder()
+	handleSweep(w, httptest.NewRequest(http.MethodGet, "/api/v1/sweeps/1/results", nil))
+	var rows []SweepRow
+	if err := json.NewDecoder(w.Body).Decode(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[0].Point["x"] != "-1" || rows[0].Result == nil || *rows[0].Result != -1 ||
+		rows[1].Error != "division by zero" || rows[2].Result == nil || *rows[2].Result != 1 {
+		t.Fatalf("unexpected rows: %+v", rows)
+	}
+}
+
+func TestFailedChunkFailsItsPoints(t *testing.T) {
+	resetGlobals()
+	submitSweep(t, `{"expression": "x", "variables": [{"name": "x", "values": [1, 2]}]}`)
+	resp := dispatch(t)
+	report(t, ResultPayload{ID: resp.Task.ID, Error: "agent crashed"})
+
+	w := httptest.NewRecorder()
+	handleSweep(w, httptest.NewRequest(http.MethodGet, "/api/v1/sweeps/1/results?format=csv", nil))
+	if got := w.Body.String(); got != "x,result,error\n1,,agent crashed\n2,,agent crashed\n" {
+		t.Fatalf("unexpected CSV: %q", got)
+	}
+}
+
+func TestSweepValidation(t *testing.T) {
+	resetGlobals()
+	for _, body := range []string{
+		`{"expression": "x"}`,
+		`{"expression": "", "variables": [{"name": "x", "values": [1]}]}`,
+		`{"expression": "x +", "variables": [{"name": "x", "values": [1]}]}`,
+		`{"expression": "x * $1", "variables": [{"name": "x", "values": [1]}]}`,
+		`{"expression": "x", "operation": "derive", "variable": "x", "variables": [{"name": "x", "values": [1]}]}`,
+		`{"expression": "x", "variables": [{"name": "x", "from": 0, "to": 1, "step": 0}]}`,
+		`{"expression": "x", "variables": [{"name": "x", "from": 0, "to": 1e7, "step": 1}]}`,
+	} {
+		if status, _ := submitSweep(t, body); status != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected %d, got %d", body, http.StatusUnprocessableEntity, status)
+		}
+	}
+	if len(tasks) != 0 || len(sweeps) != 0 {
+		t.Fatalf("expected nothing to be queued, got %d tasks", len(tasks))
+	}
+
+	submitSweep(t, `{"expression": "x", "variables": [{"name": "x", "values": [1]}]}`)
+	for path, want := range map[string]int{
+		"/api/v1/sweeps/1":                     http.StatusOK,
+		"/api/v1/sweeps/2":                     http.StatusNotFound,
+		"/api/v1/sweeps/abc":                   http.StatusBadRequest,
+		"/api/v1/sweeps/1/results?format=xlsx": http.StatusBadRequest,
+	} {
+		w := httptest.NewRecorder()
+		handleSweep(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Errorf("%s: expected %d, got %d", path, want, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleListExpressions(w, httptest.NewRequest(http.MethodGet, "/api/v1/expressions", nil))
+	if strings.Contains(w.Body.String(), `"sweep"`) {
+		t.Fatalf("expected chunks to be left out of the expressions list, got %s", w.Body.String())
+	}
+}
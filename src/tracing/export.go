@@ -0,0 +1,133 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// writerExporter writes each span as one OTLP/JSON ExportTraceServiceRequest
+// line.
+type writerExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterExporter(w io.Writer) Exporter {
+	return &writerExporter{w: w}
+}
+
+func (e *writerExporter) Export(span *Span) {
+	data, err := json.Marshal(otlpRequest(span))
+	if err != nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.w.Write(append(data, '\n'))
+}
+
+// nopCloser is the closer FromEnv returns for exporters without a file.
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+// FromEnv builds a tracer for service from TRACES_EXPORTER, which is one of
+// none (the default), stdout or file. The file exporter appends to
+// TRACES_FILE. The returned closer releases the output file, if any.
+func FromEnv(service string) (*Tracer, io.Closer, error) {
+	switch os.Getenv("TRACES_EXPORTER") {
+	case "", "none":
+		return NewTracer(service, nil), nopCloser{}, nil
+	case "stdout":
+		return NewTracer(service, NewWriterExporter(os.Stdout)), nopCloser{}, nil
+	case "file":
+		path := os.Getenv("TRACES_FILE")
+		if path == "" {
+			return nil, nil, fmt.Errorf("TRACES_FILE must be set for the file exporter")
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+		return NewTracer(service, NewWriterExporter(f)), f, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown TRACES_EXPORTER %q", os.Getenv("TRACES_EXPORTER"))
+	}
+}
+
+type otlpKeyValue struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+func otlpRequest(span *Span) map[string]interface{} {
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	out := otlpSpan{
+		TraceID:           hex.EncodeToString(span.context.TraceID[:]),
+		SpanID:            hex.EncodeToString(span.context.SpanID[:]),
+		Name:              span.name,
+		Kind:              span.kind,
+		StartTimeUnixNano: strconv.FormatInt(span.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.end.UnixNano(), 10),
+	}
+	if span.parent.IsValid() {
+		out.ParentSpanID = hex.EncodeToString(span.parent.SpanID[:])
+	}
+	for _, attr := range span.attributes {
+		out.Attributes = append(out.Attributes, otlpKeyValue{Key: attr.key, Value: otlpValue(attr.value)})
+	}
+	if span.errMessage != "" {
+		out.Status.Code = 2
+		out.Status.Message = span.errMessage
+	}
+	resource := map[string]interface{}{
+		"attributes": []otlpKeyValue{{Key: "service.name", Value: otlpValue(span.tracer.service)}},
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": resource,
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": "github.com/m4tveevm/GoCalc/tracing"},
+				"spans": []otlpSpan{out},
+			}},
+		}},
+	}
+}
+
+func otlpValue(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": v}
+	case bool:
+		return map[string]interface{}{"boolValue": v}
+	case int:
+		return map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": v}
+	default:
+		return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+	}
+}
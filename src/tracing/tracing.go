@@ -0,0 +1,221 @@
+// Package tracing records spans and propagates them between the GoCalc
+// services with the W3C traceparent header. Finished spans are written as
+// OTLP/JSON lines, the format the OpenTelemetry collector's file receiver
+// and exporter use, so traces can be inspected locally without running a
+// collector.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SpanKind int
+
+// Span kinds, numbered as in the OTLP protocol.
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+	KindClient   SpanKind = 3
+)
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats sc as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+// ParseTraceparent parses a W3C traceparent header value.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, errors.New("invalid traceparent")
+	}
+	if _, err := hex.DecodeString(parts[0]); err != nil {
+		return sc, errors.New("invalid traceparent")
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, errors.New("invalid traceparent")
+	}
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, errors.New("invalid traceparent")
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, errors.New("invalid traceparent")
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, errors.New("invalid traceparent")
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, errors.New("invalid traceparent")
+	}
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, errors.New("invalid traceparent")
+	}
+	return sc, nil
+}
+
+type contextKey struct{}
+
+// ContextWithSpanContext returns a copy of ctx whose spans become children
+// of sc.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, contextKey{}, sc)
+}
+
+// SpanContextFromContext returns the current span context of ctx, if any.
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	sc, _ := ctx.Value(contextKey{}).(SpanContext)
+	return sc
+}
+
+// Inject writes the span context of ctx into h as a traceparent header.
+func Inject(ctx context.Context, h http.Header) {
+	if sc := SpanContextFromContext(ctx); sc.IsValid() {
+		h.Set("traceparent", sc.Traceparent())
+	}
+}
+
+// Extract returns ctx carrying the span context from the traceparent
+// header in h. Missing or malformed headers leave ctx unchanged.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	sc, err := ParseTraceparent(h.Get("traceparent"))
+	if err != nil {
+		return ctx
+	}
+	return ContextWithSpanContext(ctx, sc)
+}
+
+// Exporter receives every finished span.
+type Exporter interface {
+	Export(span *Span)
+}
+
+type Tracer struct {
+	service  string
+	exporter Exporter
+}
+
+// NewTracer returns a tracer for service. A nil exporter drops finished
+// spans but still propagates trace context.
+func NewTracer(service string, exporter Exporter) *Tracer {
+	return &Tracer{service: service, exporter: exporter}
+}
+
+type Span struct {
+	tracer *Tracer
+
+	mu         sync.Mutex
+	name       string
+	kind       SpanKind
+	context    SpanContext
+	parent     SpanContext
+	start      time.Time
+	end        time.Time
+	attributes []attribute
+	errMessage string
+	ended      bool
+}
+
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+type StartOption func(*Span)
+
+func WithKind(kind SpanKind) StartOption {
+	return func(s *Span) { s.kind = kind }
+}
+
+// WithStartTime backdates a span, e.g. to cover time spent in a queue.
+func WithStartTime(t time.Time) StartOption {
+	return func(s *Span) { s.start = t }
+}
+
+// Start begins a span named name as a child of the span in ctx, or as the
+// root of a new trace. The returned context carries the new span.
+func (t *Tracer) Start(ctx context.Context, name string, opts ...StartOption) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+	span := &Span{tracer: t, name: name, kind: KindInternal, parent: parent, start: time.Now()}
+	if parent.IsValid() {
+		span.context.TraceID = parent.TraceID
+		span.context.Sampled = parent.Sampled
+	} else {
+		rand.Read(span.context.TraceID[:])
+		span.context.Sampled = true
+	}
+	rand.Read(span.context.SpanID[:])
+	for _, opt := range opts {
+		opt(span)
+	}
+	return ContextWithSpanContext(ctx, span.context), span
+}
+
+func (s *Span) Context() SpanContext {
+	return s.context
+}
+
+// SetAttributes records key/value pairs on the span. Values may be strings,
+// bools, integers or floats; anything else is recorded with fmt.
+func (s *Span) SetAttributes(keyValues ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		key, ok := keyValues[i].(string)
+		if !ok {
+			continue
+		}
+		s.attributes = append(s.attributes, attribute{key: key, value: keyValues[i+1]})
+	}
+}
+
+// RecordError marks the span as failed.
+func (s *Span) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.errMessage = err.Error()
+	s.mu.Unlock()
+}
+
+// End finishes the span and hands it to the exporter. Calls after the
+// first are ignored.
+func (s *Span) End() {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mu.Unlock()
+	if s.tracer.exporter != nil && s.context.Sampled {
+		s.tracer.exporter.Export(s)
+	}
+}
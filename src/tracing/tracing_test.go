@@ -0,0 +1,80 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	const header = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(header)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !sc.Sampled || sc.Traceparent() != header {
+		t.Fatalf("round trip mismatch: %s", sc.Traceparent())
+	}
+	for _, bad := range []string{"", "00-abc-def-01", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", "zz-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"} {
+		if _, err := ParseTraceparent(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestSpansAndPropagation(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer("test", NewWriterExporter(&buf))
+
+	ctx, root := tracer.Start(context.Background(), "root", WithKind(KindServer))
+	h := http.Header{}
+	Inject(ctx, h)
+	remote := Extract(context.Background(), h)
+	_, child := tracer.Start(remote, "child")
+	child.SetAttributes("task_id", 7)
+	child.RecordError(errors.New("boom"))
+	child.End()
+	root.End()
+	root.End()
+
+	if child.Context().TraceID != root.Context().TraceID {
+		t.Fatal("child should share the parent's trace id")
+	}
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 exported spans, got %d", len(lines))
+	}
+	var req struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []otlpSpan `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(lines[0], &req); err != nil {
+		t.Fatalf("invalid OTLP JSON: %v", err)
+	}
+	span := req.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if span.Name != "child" || span.Status.Code != 2 || span.ParentSpanID == "" {
+		t.Fatalf("unexpected span: %+v", span)
+	}
+	if span.Attributes[0].Key != "task_id" || span.Attributes[0].Value["intValue"] != "7" {
+		t.Fatalf("unexpected attributes: %+v", span.Attributes)
+	}
+}
+
+func TestFromEnvCloser(t *testing.T) {
+	for _, exporter := range []string{"none", "stdout"} {
+		t.Setenv("TRACES_EXPORTER", exporter)
+		tracer, closer, err := FromEnv("test")
+		if err != nil || tracer == nil {
+			t.Fatalf("%s: expected a tracer, got %v", exporter, err)
+		}
+		if err := closer.Close(); err != nil {
+			t.Fatalf("%s: expected closing to succeed, got %v", exporter, err)
+		}
+	}
+}